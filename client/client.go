@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"network-router/daemon"
+	"network-router/pkg/core"
 )
 
 const socketPath = "/tmp/network-router.sock"
@@ -19,9 +20,10 @@ type IPCRequest struct {
 
 // IPCResponse represents a server response
 type IPCResponse struct {
-	Success bool                 `json:"success"`
-	Message string               `json:"message,omitempty"`
-	Data    *daemon.RouterStatus `json:"data,omitempty"`
+	Success bool                    `json:"success"`
+	Message string                  `json:"message,omitempty"`
+	Data    *daemon.RouterStatus    `json:"data,omitempty"`
+	Routes  *daemon.InstalledRoutes `json:"routes,omitempty"`
 }
 
 // Client handles communication with the daemon
@@ -91,8 +93,8 @@ func (c *Client) Status() error {
 	if data := resp.Data; data != nil {
 		fmt.Printf("Auto-routing:     %v\n", data.AutoRoutingEnabled)
 		fmt.Printf("Routes applied:   %v\n", data.RoutesApplied)
-		fmt.Printf("WiFi active:      %v\n", data.WifiActive)
-		fmt.Printf("Phone active:     %v\n", data.PhoneActive)
+		fmt.Printf("WiFi active:      %v%s\n", data.WifiActive, describeInterface(data.WifiInterface))
+		fmt.Printf("Phone active:     %v%s\n", data.PhoneActive, describeInterface(data.PhoneInterface))
 
 		if !data.LastAppliedAt.IsZero() {
 			fmt.Printf("Last applied:     %s\n", data.LastAppliedAt.Format(time.RFC3339))
@@ -101,12 +103,57 @@ func (c *Client) Status() error {
 			fmt.Printf("Last cleared:     %s\n", data.LastClearedAt.Format(time.RFC3339))
 		}
 		fmt.Printf("DNS Proxy:        %v\n", data.DNSProxyEnabled)
+		if cache := data.DNSCache; cache != nil && data.DNSProxyEnabled {
+			rate := 0.0
+			if total := cache.Hits + cache.Misses; total > 0 {
+				rate = float64(cache.Hits) / float64(total) * 100
+			}
+			fmt.Printf("DNS cache:        %d entries, %d hits, %d misses (%.0f%% hit rate), %d stale, %d evicted\n",
+				cache.Entries, cache.Hits, cache.Misses, rate, cache.StaleHits, cache.Evictions)
+		}
 		fmt.Printf("Auto Refresh:     %v\n", data.AutoRefreshRouteEnabled)
+		fmt.Printf("Routes installed: %d\n", data.RoutesInstalled)
+		if len(data.RoutesBySource) > 0 {
+			fmt.Printf("Routes tracked:   %d cidr, %d static, %d dynamic\n",
+				data.RoutesBySource[core.SourceCIDR], data.RoutesBySource[core.SourceStatic], data.RoutesBySource[core.SourceDynamic])
+		}
+		for _, p := range data.Providers {
+			fmt.Printf("Provider %-8s %d domains, %d CIDRs, %d routes%s\n", p.Name+":", p.Domains, p.CIDRs,
+				data.RoutesBySource[core.ProviderSource(p.Name)], describeProvider(p))
+		}
+		if data.LastError != "" {
+			fmt.Printf("Last error:       %s\n", data.LastError)
+		}
+		printFailedRoutes(data)
 	}
 
 	return nil
 }
 
+// describeProvider formats the last run of a route provider
+func describeProvider(p core.ProviderStatus) string {
+	switch {
+	case p.LastError != "" && p.LastSuccess.IsZero():
+		return fmt.Sprintf(" (failed: %s)", p.LastError)
+	case p.LastError != "":
+		return fmt.Sprintf(" (last good %s, failed: %s)", p.LastSuccess.Format(time.RFC3339), p.LastError)
+	case p.LastSuccess.IsZero():
+		return " (not run yet)"
+	}
+	return fmt.Sprintf(" (updated %s)", p.LastSuccess.Format(time.RFC3339))
+}
+
+// describeInterface formats the detected device and matching selector
+func describeInterface(iface daemon.InterfaceStatus) string {
+	if iface.Device == "" {
+		return " (not found)"
+	}
+	if iface.Confidence > 0 {
+		return fmt.Sprintf(" (%s, auto-detected %s, confidence %.0f%%)", iface.Device, iface.TetherType, iface.Confidence*100)
+	}
+	return fmt.Sprintf(" (%s, matched %s)", iface.Device, iface.MatchedBy)
+}
+
 // Enable enables auto-routing
 func (c *Client) Enable() error {
 	resp, err := c.SendRequest(daemon.ActionEnable, nil)
@@ -146,13 +193,30 @@ func (c *Client) Apply() error {
 	}
 
 	if !resp.Success {
+		if resp.Data != nil {
+			printFailedRoutes(resp.Data)
+		}
 		return fmt.Errorf("apply request failed: %s", resp.Message)
 	}
 
 	fmt.Println("✓", resp.Message)
+	if resp.Data != nil {
+		printFailedRoutes(resp.Data)
+	}
 	return nil
 }
 
+// printFailedRoutes lists destinations the daemon could not route
+func printFailedRoutes(data *daemon.RouterStatus) {
+	if len(data.FailedRoutes) == 0 {
+		return
+	}
+	fmt.Printf("Failed routes (%d):\n", len(data.FailedRoutes))
+	for _, f := range data.FailedRoutes {
+		fmt.Printf("  ✗ %-20s %s (attempts: %d)\n", f.Destination, f.Error, f.Attempts)
+	}
+}
+
 // Clear forces route clearing
 func (c *Client) Clear() error {
 	fmt.Println("Clearing routes...")
@@ -185,6 +249,34 @@ func (c *Client) Restart() error {
 	return nil
 }
 
+// Reload makes the daemon re-read its config file
+func (c *Client) Reload() error {
+	resp, err := c.SendRequest(daemon.ActionReload, nil)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("reload request failed: %s", resp.Message)
+	}
+
+	fmt.Println("✓", resp.Message)
+	return nil
+}
+
+// InstalledRoutes fetches the routes installed by the daemon
+func (c *Client) InstalledRoutes() (*daemon.InstalledRoutes, error) {
+	resp, err := c.SendRequest(daemon.ActionRoutes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Success || resp.Routes == nil {
+		return nil, fmt.Errorf("routes request failed: %s", resp.Message)
+	}
+	return resp.Routes, nil
+}
+
 // EnableDNSProxy enables the DNS proxy
 func (c *Client) EnableDNSProxy() error {
 	resp, err := c.SendRequest(daemon.ActionEnableDNSProxy, nil)
@@ -0,0 +1,39 @@
+//go:build linux
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"network-router/daemon"
+)
+
+// ExecVia asks the daemon to route this process via the given uplink, then
+// replaces it with argv; the command and its children inherit the cgroup.
+// The daemon identifies this process from the socket connection.
+func (c *Client) ExecVia(via string, argv []string) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("no command given")
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.SendRequest(daemon.ActionExecVia, map[string]interface{}{
+		"via": via,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("exec_via request failed: %s", resp.Message)
+	}
+
+	// stdout belongs to the command
+	fmt.Fprintf(os.Stderr, "✓ %s\n", resp.Message)
+	return syscall.Exec(path, argv, os.Environ())
+}
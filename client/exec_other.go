@@ -0,0 +1,10 @@
+//go:build !linux
+
+package client
+
+import "fmt"
+
+// ExecVia needs cgroups, see exec_linux.go
+func (c *Client) ExecVia(via string, argv []string) error {
+	return fmt.Errorf("exec --via is only supported on Linux")
+}
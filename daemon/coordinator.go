@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"network-router/pkg/core"
@@ -16,8 +17,12 @@ import (
 // between the NetworkDetector, Router, and DNSProxy.
 type Coordinator struct {
 	config        *core.Config
-	router        *core.Router
+	router        atomic.Pointer[core.Router] // Replaced by the event loop and IPC, read by DNS handlers
 	routeManager  core.RouteManager
+	registry      *core.RouteRegistry
+	dynamicSet    core.DynamicSet
+	appRouter     *core.AppRouter
+	providers     *core.ProviderManager
 	dnsProxy      *core.DNSProxy
 	networkEvents <-chan NetworkEvent
 
@@ -27,28 +32,45 @@ type Coordinator struct {
 	routesApplied           bool
 	wifiActive              bool
 	phoneActive             bool
+	wifiMatch               InterfaceStatus
+	phoneMatch              InterfaceStatus
 	lastAppliedAt           time.Time
 	lastClearedAt           time.Time
 	dnsProxyEnabled         bool
 	autoRefreshRouteEnabled bool
+	lastError               string
+	routesInstalled         int
+	failedRoutes            []core.RouteFailure
 
 	refreshCron *cron.Cron
 	refreshCh   chan bool
+	rulesCh     chan bool
+
+	expiryInterval time.Duration
+
+	// ctx is the daemon lifetime context, cancelled on shutdown so that
+	// in-flight resolution started by IPC commands is abandoned too
+	ctx context.Context
 }
 
 func NewCoordinator(
 	config *core.Config,
 	rm core.RouteManager,
+	registry *core.RouteRegistry,
 	dnsProxy *core.DNSProxy,
 	networkEvents <-chan NetworkEvent,
 ) *Coordinator {
 	c := &Coordinator{
 		config:             config,
 		routeManager:       rm,
+		registry:           registry,
 		dnsProxy:           dnsProxy,
 		networkEvents:      networkEvents,
 		autoRoutingEnabled: true, // Default
 		refreshCh:          make(chan bool, 1),
+		rulesCh:            make(chan bool, 1),
+		expiryInterval:     30 * time.Second,
+		ctx:                context.Background(),
 	}
 	// Initial sync from config
 	c.dnsProxyEnabled = config.DNSProxyEnabled
@@ -56,10 +78,49 @@ func NewCoordinator(
 	return c
 }
 
+// SetConfig makes routers created from now on use config, e.g. after a
+// reload; call RefreshRoutes to re-apply with it
+func (c *Coordinator) SetConfig(config *core.Config) {
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+}
+
+func (c *Coordinator) currentConfig() *core.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// SetDynamicSet routes DNS-learned IPs through set instead of per-IP
+// routes; it must be called before Start
+func (c *Coordinator) SetDynamicSet(set core.DynamicSet) {
+	c.dynamicSet = set
+}
+
+// SetAppRouter enables routing processes via the phone with
+// RouteProcessViaPhone; it must be called before Start
+func (c *Coordinator) SetAppRouter(apps *core.AppRouter) {
+	c.appRouter = apps
+}
+
+// SetProviders makes GetStatus report the route providers; it must be
+// called before Start
+func (c *Coordinator) SetProviders(providers *core.ProviderManager) {
+	c.providers = providers
+}
+
 // Start begins the event loop for the Coordinator
 func (c *Coordinator) Start(ctx context.Context) error {
 	log.Println("Starting State Coordinator...")
 
+	c.mu.Lock()
+	c.ctx = ctx
+	c.mu.Unlock()
+
+	expiryTicker := time.NewTicker(c.expiryInterval)
+	defer expiryTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -70,6 +131,10 @@ func (c *Coordinator) Start(ctx context.Context) error {
 			c.handleNetworkEvent(netEvent)
 		case <-c.refreshCh:
 			c.performRefresh()
+		case <-c.rulesCh:
+			c.performRulesUpdate()
+		case <-expiryTicker.C:
+			c.performExpiry()
 		}
 	}
 }
@@ -80,6 +145,8 @@ func (c *Coordinator) handleNetworkEvent(event NetworkEvent) {
 	c.mu.Lock()
 	c.wifiActive = event.WifiActive
 	c.phoneActive = event.PhoneActive
+	c.wifiMatch = newInterfaceStatus(event.Wifi)
+	c.phoneMatch = newInterfaceStatus(event.Phone)
 	autoRouting := c.autoRoutingEnabled
 	routesApplied := c.routesApplied
 	c.mu.Unlock()
@@ -102,7 +169,7 @@ func (c *Coordinator) handleNetworkEvent(event NetworkEvent) {
 		} else {
 			c.setRoutesApplied(true)
 			log.Println("✓ Routes automatically applied")
-			
+
 			// Auto start DNS Proxy if configured
 			if c.dnsProxy != nil {
 				if err := c.dnsProxy.Start(); err != nil {
@@ -120,7 +187,7 @@ func (c *Coordinator) handleNetworkEvent(event NetworkEvent) {
 		} else {
 			c.setRoutesApplied(false)
 			log.Println("✓ Routes automatically cleared")
-			
+
 			// Auto stop DNS Proxy
 			if c.dnsProxy != nil {
 				if err := c.dnsProxy.Stop(); err != nil {
@@ -157,7 +224,7 @@ func (c *Coordinator) performRefresh() {
 	_ = c.clearRoutes()
 	c.stopRefreshCron()
 	c.setRoutesApplied(false)
-	c.router = nil
+	c.router.Store(nil)
 
 	// 3. Immediately re-apply since we are in refresh
 	if err := c.applyRoutes(); err != nil {
@@ -175,6 +242,57 @@ func (c *Coordinator) performRefresh() {
 	}
 }
 
+// performRulesUpdate applies the rules of the current config to the
+// installed routes, adding and deleting only what changed
+func (c *Coordinator) performRulesUpdate() {
+	c.mu.RLock()
+	routesApplied := c.routesApplied
+	c.mu.RUnlock()
+
+	// Without routes the next apply uses the new config anyway
+	current := c.router.Load()
+	if !routesApplied || current == nil {
+		return
+	}
+
+	router, err := current.Reconfigure(c.runContext(), c.currentConfig())
+	// An apply or clear meanwhile installed its own router; keep it
+	c.router.CompareAndSwap(current, router)
+
+	// The report only holds what changed; count the planned routes instead
+	installed := router.Registry().Len() - router.Registry().CountBySource()[core.SourceDynamic]
+	c.mu.Lock()
+	c.routesInstalled = installed
+	c.failedRoutes = router.LastReport().Failed
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("Error updating routes for the new rules: %v", err)
+	}
+	c.setLastError(err)
+}
+
+// performExpiry re-resolves expired domains and retires idle IPs without
+// tearing down the whole routing table
+func (c *Coordinator) performExpiry() {
+	router := c.router.Load()
+	if !c.currentConfig().RouteExpiryEnabled || router == nil {
+		return
+	}
+
+	c.mu.RLock()
+	routesApplied := c.routesApplied
+	c.mu.RUnlock()
+
+	if !routesApplied {
+		return
+	}
+
+	if err := router.RefreshExpired(c.runContext()); err != nil {
+		log.Printf("Error refreshing expired routes: %v", err)
+		c.setLastError(err)
+	}
+}
+
 // Commands from IPC
 
 func (c *Coordinator) ForceApply() error {
@@ -182,7 +300,7 @@ func (c *Coordinator) ForceApply() error {
 		return err
 	}
 	c.setRoutesApplied(true)
-	
+
 	if c.dnsProxy != nil {
 		if err := c.dnsProxy.Start(); err != nil {
 			log.Printf("Warning: Failed to force start DNS Proxy: %v", err)
@@ -219,6 +337,70 @@ func (c *Coordinator) ForceClear() error {
 	return nil
 }
 
+// RouteProcessViaPhone moves pid into the application cgroup so that all
+// its traffic uses the phone uplink of the active router. It fails while
+// routes are not applied, rather than silently using Wi-Fi.
+func (c *Coordinator) RouteProcessViaPhone(pid int) (string, error) {
+	if c.appRouter == nil {
+		return "", fmt.Errorf("per-application routing is disabled (set app_routing_enabled)")
+	}
+	c.mu.RLock()
+	routesApplied := c.routesApplied
+	c.mu.RUnlock()
+	router := c.router.Load()
+	if !routesApplied || router == nil {
+		return "", fmt.Errorf("phone routing is not active, run apply first")
+	}
+
+	device, gateway, err := router.RoutePhoneUplink()
+	if err != nil {
+		return "", err
+	}
+	if err := c.appRouter.AddProcess(pid); err != nil {
+		return "", err
+	}
+	uplink := device
+	if gateway != "" {
+		uplink = fmt.Sprintf("%s via %s", device, gateway)
+	}
+	log.Printf("📱 Routing pid %d via Phone (%s)", pid, uplink)
+	return uplink, nil
+}
+
+// InstalledRoutes returns the routes recorded in the registry and the
+// gateway of the active router
+func (c *Coordinator) InstalledRoutes() *InstalledRoutes {
+	installed := &InstalledRoutes{Routes: []core.RouteEntry{}}
+	if c.registry != nil {
+		installed.Routes = c.registry.Entries()
+	}
+	if router := c.GetActiveRouter(); router != nil {
+		installed.Gateway = router.PhoneGateway()
+	}
+	return installed
+}
+
+// PhoneDevice returns the device of the phone uplink last reported active
+// by the network detector
+func (c *Coordinator) PhoneDevice() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.phoneActive || c.phoneMatch.Device == "" {
+		return "", fmt.Errorf("phone interface is not active")
+	}
+	return c.phoneMatch.Device, nil
+}
+
+// UpdateRules queues applying the current config's rules to the installed
+// routes, see performRulesUpdate
+func (c *Coordinator) UpdateRules() {
+	select {
+	case c.rulesCh <- true:
+	default:
+		// An update is already queued and will read the latest config
+	}
+}
+
 func (c *Coordinator) RefreshRoutes() {
 	log.Println("↻ Queueing route refresh...")
 	select {
@@ -249,7 +431,7 @@ func (c *Coordinator) SetDNSProxy(enabled bool) error {
 	if c.dnsProxy == nil {
 		return fmt.Errorf("DNS Proxy not initialized")
 	}
-	
+
 	if enabled {
 		if err := c.dnsProxy.Start(); err != nil {
 			return err
@@ -266,31 +448,69 @@ func (c *Coordinator) SetDNSProxy(enabled bool) error {
 
 // Internal Action Helpers
 
+// newRouter creates a Router sharing the coordinator's route registry, so
+// routes installed by a previous Router (or the DNS proxy) stay tracked
+func (c *Coordinator) newRouter() (*core.Router, error) {
+	router, err := core.NewRouter(c.currentConfig(), c.routeManager)
+	if err != nil {
+		return nil, err
+	}
+	if c.registry != nil {
+		router.SetRegistry(c.registry)
+	}
+	if c.dynamicSet != nil {
+		router.SetDynamicSet(c.dynamicSet)
+	}
+	return router, nil
+}
+
 func (c *Coordinator) applyRoutes() error {
-	router, err := core.NewRouter(c.config, c.routeManager)
+	router, err := c.newRouter()
 	if err != nil {
 		return err
 	}
 	if err := router.DetectInterfaces(); err != nil {
+		c.setLastError(err)
 		return err
 	}
-	if err := router.ApplyRoutes(); err != nil {
+	if err := router.ApplyRoutes(c.runContext()); err != nil {
+		c.setLastError(err)
 		return err
 	}
-	c.router = router
+	c.router.Store(router)
+
+	// Partial failures don't fail the apply, but must be visible in status
+	report := router.LastReport()
+	c.setRouteReport(report)
+	if len(report.Failed) > 0 {
+		c.setLastError(fmt.Errorf("%d route(s) failed to install, first: %w", len(report.Failed), report.Err()))
+	} else {
+		c.setLastError(nil)
+	}
 	return nil
 }
 
 func (c *Coordinator) clearRoutes() error {
-	if c.router == nil {
-		router, err := core.NewRouter(c.config, c.routeManager)
-		if err != nil {
+	router := c.router.Load()
+	if router == nil {
+		var err error
+		if router, err = c.newRouter(); err != nil {
 			return err
 		}
-		c.router = router
-		_ = c.router.DetectInterfaces() // Best effort
+		_ = router.DetectInterfaces() // Best effort
+		c.router.Store(router)
 	}
-	return c.router.ClearRoutes()
+	err := router.ClearRoutes()
+	c.setRouteReport(router.LastReport())
+	c.setLastError(err)
+	return err
+}
+
+// runContext returns the context of the running event loop
+func (c *Coordinator) runContext() context.Context {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ctx
 }
 
 func (c *Coordinator) startRefreshCron() {
@@ -343,6 +563,30 @@ func (c *Coordinator) setRoutesApplied(applied bool) {
 	c.mu.Unlock()
 }
 
+// setLastError records the most recent routing failure (nil clears it)
+func (c *Coordinator) setLastError(err error) {
+	c.mu.Lock()
+	if err != nil {
+		c.lastError = err.Error()
+	} else {
+		c.lastError = ""
+	}
+	c.mu.Unlock()
+}
+
+// setRouteReport records the outcome of the last route batch for status
+func (c *Coordinator) setRouteReport(report *core.RouteReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if report == nil {
+		c.routesInstalled = 0
+		c.failedRoutes = nil
+		return
+	}
+	c.routesInstalled = len(report.Succeeded)
+	c.failedRoutes = report.Failed
+}
+
 func (c *Coordinator) setDNSProxyEnabled(enabled bool) {
 	c.mu.Lock()
 	c.dnsProxyEnabled = enabled
@@ -357,26 +601,74 @@ func (c *Coordinator) GetStatus() *RouterStatus {
 		RoutesApplied:           c.routesApplied,
 		WifiActive:              c.wifiActive,
 		PhoneActive:             c.phoneActive,
+		WifiInterface:           c.wifiMatch,
+		PhoneInterface:          c.phoneMatch,
 		LastAppliedAt:           c.lastAppliedAt,
 		LastClearedAt:           c.lastClearedAt,
 		DNSProxyEnabled:         c.dnsProxyEnabled,
 		AutoRefreshRouteEnabled: c.autoRefreshRouteEnabled,
+		LastError:               c.lastError,
+		RoutesInstalled:         c.routesInstalled,
+		FailedRoutes:            c.failedRoutes,
+		RoutesBySource:          c.routesBySource(),
+		Providers:               c.providerStatus(),
+		DNSCache:                c.dnsProxy.CacheStats(),
+	}
+}
+
+func (c *Coordinator) providerStatus() []core.ProviderStatus {
+	if c.providers == nil {
+		return nil
+	}
+	return c.providers.Status()
+}
+
+// routesBySource counts the routes currently tracked in the registry
+func (c *Coordinator) routesBySource() map[core.RouteSource]int {
+	if c.registry == nil {
+		return nil
 	}
+	return c.registry.CountBySource()
 }
 
 // RouterStatus represents the current state (copied from state.go to avoid dependency issues)
 type RouterStatus struct {
-	AutoRoutingEnabled      bool      `json:"auto_routing_enabled"`
-	RoutesApplied           bool      `json:"routes_applied"`
-	WifiActive              bool      `json:"wifi_active"`
-	PhoneActive             bool      `json:"phone_active"`
-	LastAppliedAt           time.Time `json:"last_applied_at"`
-	LastClearedAt           time.Time `json:"last_cleared_at"`
-	DNSProxyEnabled         bool      `json:"dns_proxy_enabled"`
-	AutoRefreshRouteEnabled bool      `json:"auto_refresh_enabled"`
+	AutoRoutingEnabled      bool                     `json:"auto_routing_enabled"`
+	RoutesApplied           bool                     `json:"routes_applied"`
+	WifiActive              bool                     `json:"wifi_active"`
+	PhoneActive             bool                     `json:"phone_active"`
+	WifiInterface           InterfaceStatus          `json:"wifi_interface"`
+	PhoneInterface          InterfaceStatus          `json:"phone_interface"`
+	LastAppliedAt           time.Time                `json:"last_applied_at"`
+	LastClearedAt           time.Time                `json:"last_cleared_at"`
+	DNSProxyEnabled         bool                     `json:"dns_proxy_enabled"`
+	AutoRefreshRouteEnabled bool                     `json:"auto_refresh_enabled"`
+	LastError               string                   `json:"last_error,omitempty"`
+	RoutesInstalled         int                      `json:"routes_installed"`
+	FailedRoutes            []core.RouteFailure      `json:"failed_routes,omitempty"`
+	RoutesBySource          map[core.RouteSource]int `json:"routes_by_source,omitempty"`
+	Providers               []core.ProviderStatus    `json:"providers,omitempty"`
+	DNSCache                *core.DNSCacheStats      `json:"dns_cache,omitempty"`
+}
+
+// InterfaceStatus reports the detected device and the selector that matched it
+type InterfaceStatus struct {
+	Device     string  `json:"device,omitempty"`
+	MatchedBy  string  `json:"matched_by,omitempty"`
+	TetherType string  `json:"tether_type,omitempty"` // Set when the phone was auto-detected
+	Confidence float64 `json:"confidence,omitempty"`  // Classifier confidence 0..1
+}
+
+func newInterfaceStatus(m core.InterfaceMatch) InterfaceStatus {
+	return InterfaceStatus{
+		Device:     m.Device(),
+		MatchedBy:  m.Selector,
+		TetherType: string(m.Type),
+		Confidence: m.Confidence,
+	}
 }
 
 // GetActiveRouter returns the currently active router for DNSProxy dependency
 func (c *Coordinator) GetActiveRouter() *core.Router {
-	return c.router
+	return c.router.Load()
 }
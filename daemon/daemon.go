@@ -5,21 +5,41 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"network-router/pkg/core"
+	"network-router/pkg/utils"
 
 	"golang.org/x/sync/errgroup"
 )
 
+// defaultSourceWatchInterval is how often include and ip_range_sources
+// files are checked for changes
+const defaultSourceWatchInterval = 10 * time.Second
+
 // Daemon represents the main daemon process
 type Daemon struct {
+	configPath      string
 	config          *core.Config
 	coordinator     *Coordinator
 	networkDetector *NetworkDetector
 	ipcServer       *IPCServer
 	dnsProxy        *core.DNSProxy
+	registry        *core.RouteRegistry
+	routeManager    core.RouteManager
+	nftSet          *utils.NFTSet
+	appRouter       *core.AppRouter
+	proxy           *core.TetherProxy
+	pacServer       *core.PACServer
+	providers       *core.ProviderManager
 	logManager      *LogManager
+
+	// applyMu serializes config switches; baseConfig is the config file
+	// without the rules of route providers
+	applyMu    sync.Mutex
+	baseConfig *core.Config
 }
 
 // NewDaemon creates a new daemon instance
@@ -29,7 +49,11 @@ func NewDaemon(configPath string) (*Daemon, error) {
 		return nil, err
 	}
 
-	routeManager := core.NewOSRouteManager()
+	routeManager, err := core.NewRouteManager(config)
+	if err != nil {
+		return nil, err
+	}
+	registry := core.NewRouteRegistry(core.StateFilePath())
 	networkDetector := NewNetworkDetector(config)
 
 	var coordinator *Coordinator
@@ -40,18 +64,97 @@ func NewDaemon(configPath string) (*Daemon, error) {
 		return nil
 	})
 
-	coordinator = NewCoordinator(config, routeManager, dnsProxy, networkDetector.Observe())
+	coordinator = NewCoordinator(config, routeManager, registry, dnsProxy, networkDetector.Observe())
+
+	var nftSet *utils.NFTSet
+	if config.NFTSetEnabled {
+		nftSet, err = core.NewNFTSet(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var appRouter *core.AppRouter
+	if config.AppRoutingEnabled {
+		appRouter, err = core.NewAppRouter(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var proxy *core.TetherProxy
+	if config.ProxyEnabled {
+		proxy = core.NewTetherProxy(config, coordinator.PhoneDevice)
+	}
+	var pacServer *core.PACServer
+	if config.PACEnabled {
+		pacServer = core.NewPACServer(config)
+	}
 	ipcServer := NewIPCServer(coordinator)
 	logManager := NewLogManager()
 
-	return &Daemon{
+	d := &Daemon{
+		configPath:      configPath,
 		config:          config,
 		coordinator:     coordinator,
 		networkDetector: networkDetector,
 		ipcServer:       ipcServer,
 		dnsProxy:        dnsProxy,
+		registry:        registry,
+		routeManager:    routeManager,
+		nftSet:          nftSet,
+		appRouter:       appRouter,
+		proxy:           proxy,
+		pacServer:       pacServer,
 		logManager:      logManager,
-	}, nil
+		baseConfig:      config,
+	}
+	if len(config.RouteProviders) > 0 {
+		d.providers = core.NewProviderManager(config.RouteProviders, d.applyConfig)
+		coordinator.SetProviders(d.providers)
+	}
+	ipcServer.SetReloadHandler(d.Reload)
+	return d, nil
+}
+
+// Reload re-reads the config file and applies its rules: the DNS proxy
+// (domains, upstreams and macOS resolver files), tether proxy and PAC
+// script switch at once, and installed routes are
+// updated for the domains and CIDRs that changed. Listen addresses,
+// backends and route providers keep their startup values until the daemon
+// restarts; other route settings apply on the next refresh.
+func (d *Daemon) Reload() error {
+	config, err := core.LoadConfig(d.configPath)
+	if err != nil {
+		return err
+	}
+	d.applyMu.Lock()
+	d.baseConfig = config
+	d.applyMu.Unlock()
+	log.Printf("✓ Config reloaded from %s (%d domains, %d CIDRs)", d.configPath, len(config.TetherDomains), len(config.TetherCIDRs))
+	d.applyConfig()
+	return nil
+}
+
+// applyConfig switches every component to the base config merged with the
+// current rules of the route providers and queues a rules update
+func (d *Daemon) applyConfig() {
+	d.applyMu.Lock()
+	defer d.applyMu.Unlock()
+
+	config := d.baseConfig
+	if d.providers != nil {
+		config = config.WithProviderRules(d.providers.Groups())
+	}
+	d.coordinator.SetConfig(config)
+	d.dnsProxy.SetDomains(config.TetherDomains)
+	d.dnsProxy.SetGeoIP(config.GeoIP())
+	d.dnsProxy.SetUpstreams(config.DNSUpstreams)
+	if d.proxy != nil {
+		d.proxy.SetRules(core.NewRuleSet(config))
+	}
+	if d.pacServer != nil {
+		d.pacServer.Update(config)
+	}
+	d.coordinator.UpdateRules()
 }
 
 // Run starts the daemon and runs until interrupted
@@ -66,6 +169,43 @@ func (d *Daemon) Run() error {
 	// Start log manager
 	d.logManager.Start()
 
+	// Create the nftables set before the DNS proxy can learn any IP
+	if d.nftSet != nil {
+		if err := d.nftSet.Setup(ctx); err != nil {
+			log.Printf("Warning: nftables set disabled, falling back to per-IP routes: %v", err)
+			d.nftSet = nil
+		} else {
+			log.Printf("✓ nftables set inet %s @%s ready (mark 0x%x)", d.nftSet.Table, d.nftSet.Name, d.nftSet.Mark)
+			d.coordinator.SetDynamicSet(d.nftSet)
+		}
+	}
+
+	// Processes can only be moved once the cgroup and its rules exist
+	if d.appRouter != nil {
+		if err := d.appRouter.Setup(ctx); err != nil {
+			log.Printf("Warning: per-application routing disabled: %v", err)
+			d.appRouter = nil
+		} else {
+			log.Printf("✓ Application cgroup %s ready (mark 0x%x)", d.appRouter.Cgroup.Path, d.appRouter.Cgroup.Mark)
+			d.coordinator.SetAppRouter(d.appRouter)
+		}
+	}
+
+	// The proxy needs no routes, it follows the detected phone uplink
+	if d.proxy != nil {
+		if err := d.proxy.Start(); err != nil {
+			log.Printf("Warning: tether proxy disabled: %v", err)
+			d.proxy = nil
+		}
+	}
+
+	if d.pacServer != nil {
+		if err := d.pacServer.Start(); err != nil {
+			log.Printf("Warning: PAC server disabled: %v", err)
+			d.pacServer = nil
+		}
+	}
+
 	// Start network detector
 	g.Go(func() error {
 		return d.networkDetector.Start(gCtx)
@@ -76,6 +216,45 @@ func (d *Daemon) Run() error {
 		return d.coordinator.Start(gCtx)
 	})
 
+	// Persist route registry changes in the background
+	g.Go(func() error {
+		return d.registry.Run(gCtx)
+	})
+
+	// Move configured applications into the cgroup as they start
+	if d.appRouter != nil {
+		g.Go(func() error {
+			return d.appRouter.Run(gCtx)
+		})
+	}
+
+	// Reload when a merged rule file changes, e.g. an updated provider range document
+	if d.config.SourceWatchInterval >= 0 {
+		interval := d.config.SourceWatchInterval
+		if interval == 0 {
+			interval = defaultSourceWatchInterval
+		}
+		watcher := &utils.FileWatcher{
+			Interval: interval,
+			Files:    func() []string { return d.coordinator.currentConfig().SourceFiles() },
+		}
+		g.Go(func() error {
+			return watcher.Watch(gCtx, func() {
+				log.Println("📝 Rule source file changed, reloading config...")
+				if err := d.Reload(); err != nil {
+					log.Printf("❌ Reload failed, keeping the previous rules: %v", err)
+				}
+			})
+		})
+	}
+
+	// Providers run in the background; routing starts without waiting for them
+	if d.providers != nil {
+		g.Go(func() error {
+			return d.providers.Run(gCtx)
+		})
+	}
+
 	// Start IPC server
 	g.Go(func() error {
 		return d.ipcServer.Start(gCtx)
@@ -130,6 +309,31 @@ func (d *Daemon) cleanup() error {
 	if d.dnsProxy != nil {
 		d.dnsProxy.Stop()
 	}
+	if d.proxy != nil {
+		d.proxy.Stop()
+	}
+	if d.pacServer != nil {
+		d.pacServer.Stop()
+	}
+	if d.nftSet != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := d.nftSet.Teardown(ctx); err != nil {
+			log.Printf("Failed to remove nftables table %s: %v", d.nftSet.Table, err)
+		}
+	}
+	if d.appRouter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := d.appRouter.Teardown(ctx); err != nil {
+			log.Printf("Failed to remove application cgroup %s: %v", d.appRouter.Cgroup.Path, err)
+		}
+	}
+	if shutdown, ok := d.routeManager.(core.RouteShutdown); ok {
+		if err := shutdown.Shutdown(); err != nil {
+			log.Printf("Failed to remove policy routing: %v", err)
+		}
+	}
 	if d.logManager != nil {
 		d.logManager.Stop()
 	}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net"
 	"os"
+
+	"network-router/pkg/core"
 )
 
 const socketPath = "/tmp/network-router.sock"
@@ -24,6 +26,9 @@ const (
 	ActionDisableDNSProxy    = "disable_dns_proxy"
 	ActionEnableAutoRefresh  = "enable_auto_refresh"
 	ActionDisableAutoRefresh = "disable_auto_refresh"
+	ActionExecVia            = "exec_via"
+	ActionReload             = "reload"
+	ActionRoutes             = "routes"
 )
 
 // IPCRequest represents a client request
@@ -34,15 +39,30 @@ type IPCRequest struct {
 
 // IPCResponse represents a server response
 type IPCResponse struct {
-	Success bool          `json:"success"`
-	Message string        `json:"message,omitempty"`
-	Data    *RouterStatus `json:"data,omitempty"`
+	Success bool             `json:"success"`
+	Message string           `json:"message,omitempty"`
+	Data    *RouterStatus    `json:"data,omitempty"`
+	Routes  *InstalledRoutes `json:"routes,omitempty"`
+}
+
+// InstalledRoutes is the reply of the routes action: what export renders
+// when it does not resolve the domains itself
+type InstalledRoutes struct {
+	Gateway string            `json:"gateway,omitempty"`
+	Routes  []core.RouteEntry `json:"routes"`
+}
+
+// peerCred identifies the client process of a connection
+type peerCred struct {
+	PID int
+	UID int
 }
 
 // IPCServer handles IPC communication
 type IPCServer struct {
 	coordinator *Coordinator
 	listener    net.Listener
+	reload      func() error
 }
 
 // NewIPCServer creates a new IPC server
@@ -52,6 +72,11 @@ func NewIPCServer(coordinator *Coordinator) *IPCServer {
 	}
 }
 
+// SetReloadHandler sets the function serving the reload action
+func (s *IPCServer) SetReloadHandler(reload func() error) {
+	s.reload = reload
+}
+
 // Start starts the IPC server
 func (s *IPCServer) Start(ctx context.Context) error {
 	// Remove existing socket if present
@@ -121,7 +146,12 @@ func (s *IPCServer) handleConnection(conn net.Conn) {
 		log.Printf("IPC request: %s", req.Action)
 	}
 
-	response := s.processRequest(req)
+	var response IPCResponse
+	if req.Action == ActionExecVia {
+		response = s.execVia(req, conn)
+	} else {
+		response = s.processRequest(req)
+	}
 	s.sendResponse(conn, response)
 }
 
@@ -153,11 +183,21 @@ func (s *IPCServer) processRequest(req IPCRequest) IPCResponse {
 			return IPCResponse{
 				Success: false,
 				Message: fmt.Sprintf("Failed to apply routes: %v", err),
+				Data:    s.coordinator.GetStatus(),
+			}
+		}
+		status := s.coordinator.GetStatus()
+		if len(status.FailedRoutes) > 0 {
+			return IPCResponse{
+				Success: true,
+				Message: fmt.Sprintf("Routes applied: %d installed, %d failed", status.RoutesInstalled, len(status.FailedRoutes)),
+				Data:    status,
 			}
 		}
 		return IPCResponse{
 			Success: true,
 			Message: "Routes applied successfully",
+			Data:    status,
 		}
 
 	case ActionClear:
@@ -240,6 +280,30 @@ func (s *IPCServer) processRequest(req IPCRequest) IPCResponse {
 			Message: "Auto-refresh route disabled",
 		}
 
+	case ActionReload:
+		if s.reload == nil {
+			return IPCResponse{
+				Success: false,
+				Message: "Reload is not supported",
+			}
+		}
+		if err := s.reload(); err != nil {
+			return IPCResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to reload config: %v", err),
+			}
+		}
+		return IPCResponse{
+			Success: true,
+			Message: "Config reloaded",
+		}
+
+	case ActionRoutes:
+		return IPCResponse{
+			Success: true,
+			Routes:  s.coordinator.InstalledRoutes(),
+		}
+
 	default:
 		return IPCResponse{
 			Success: false,
@@ -248,6 +312,49 @@ func (s *IPCServer) processRequest(req IPCRequest) IPCResponse {
 	}
 }
 
+// execVia routes the calling process via the phone. The socket is open to
+// every user, so the target is the peer process reported by the kernel,
+// never a pid chosen by the client.
+func (s *IPCServer) execVia(req IPCRequest, conn net.Conn) IPCResponse {
+	if via, _ := req.Params["via"].(string); via != "phone" {
+		return IPCResponse{
+			Success: false,
+			Message: fmt.Sprintf("Unsupported uplink %q (only phone)", via),
+		}
+	}
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		return IPCResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to identify the caller: %v", err),
+		}
+	}
+	if pid, ok := req.Params["pid"].(float64); ok && int(pid) != peer.PID {
+		return IPCResponse{
+			Success: false,
+			Message: "Only the calling process can be routed",
+		}
+	}
+	// The pid may have been reused since the client connected
+	if uid, err := processOwner(peer.PID); err != nil || uid != peer.UID {
+		return IPCResponse{
+			Success: false,
+			Message: fmt.Sprintf("Process %d does not belong to the caller", peer.PID),
+		}
+	}
+	uplink, err := s.coordinator.RouteProcessViaPhone(peer.PID)
+	if err != nil {
+		return IPCResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to route process via phone: %v", err),
+		}
+	}
+	return IPCResponse{
+		Success: true,
+		Message: fmt.Sprintf("Process %d routed via phone (%s)", peer.PID, uplink),
+	}
+}
+
 // sendResponse sends a response to the client
 func (s *IPCServer) sendResponse(conn net.Conn, response IPCResponse) {
 	encoder := json.NewEncoder(conn)
@@ -13,6 +13,10 @@ import (
 type NetworkEvent struct {
 	WifiActive  bool
 	PhoneActive bool
+
+	// Matched interfaces and how they were picked
+	Wifi  core.InterfaceMatch
+	Phone core.InterfaceMatch
 }
 
 // NetworkDetector periodically polls the OS for network interface changes
@@ -62,37 +66,29 @@ func (d *NetworkDetector) check() {
 		return
 	}
 
-	wifiKw := d.config.WifiInterfaceKeyword
-	if wifiKw == "" {
-		wifiKw = "Wi-Fi"
-	}
-	phoneKw := d.config.PhoneInterfaceKeyword
-	if phoneKw == "" {
-		phoneKw = "iPhone USB"
+	wifi, phone, err := core.SelectInterfaces(d.config, interfaces)
+	if err != nil {
+		log.Printf("Network check error: %v", err)
+		return
 	}
 
-	wifiIface := utils.FindInterfaceByName(interfaces, []string{wifiKw, "Wi-Fi"})
-	phoneIface := utils.FindInterfaceByName(interfaces, []string{phoneKw, "iPhone USB", "iPad USB", "RNDIS"})
-
-	wifiActive := false
-	phoneActive := false
-
-	if wifiIface != nil {
-		wifiActive = utils.IsInterfaceActive(wifiIface.DeviceName)
+	event := NetworkEvent{Wifi: wifi, Phone: phone}
+	if wifi.Interface != nil {
+		event.WifiActive = utils.IsInterfaceActive(wifi.Device())
 	}
-	if phoneIface != nil {
-		phoneActive = utils.IsInterfaceActive(phoneIface.DeviceName)
+	if phone.Interface != nil {
+		event.PhoneActive = utils.IsInterfaceActive(phone.Device())
 	}
 
 	// Send event non-blocking (replace old event if channel is full)
 	select {
-	case d.events <- NetworkEvent{WifiActive: wifiActive, PhoneActive: phoneActive}:
+	case d.events <- event:
 	default:
 		// Drain and replace
 		select {
 		case <-d.events:
 		default:
 		}
-		d.events <- NetworkEvent{WifiActive: wifiActive, PhoneActive: phoneActive}
+		d.events <- event
 	}
 }
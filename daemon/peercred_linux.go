@@ -0,0 +1,45 @@
+//go:build linux
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the process and user on the other end of a Unix
+// socket connection, as seen by the kernel when it connected (SO_PEERCRED)
+func peerCredentials(conn net.Conn) (*peerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a Unix socket connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("SO_PEERCRED: %w", credErr)
+	}
+	return &peerCred{PID: int(cred.Pid), UID: int(cred.Uid)}, nil
+}
+
+// processOwner returns the user owning pid
+func processOwner(pid int) (int, error) {
+	info, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	if err != nil {
+		return 0, fmt.Errorf("process %d not found", pid)
+	}
+	return int(info.Sys().(*syscall.Stat_t).Uid), nil
+}
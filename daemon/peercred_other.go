@@ -0,0 +1,17 @@
+//go:build !linux
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCredentials needs SO_PEERCRED, see peercred_linux.go
+func peerCredentials(conn net.Conn) (*peerCred, error) {
+	return nil, fmt.Errorf("peer credentials are only available on Linux")
+}
+
+func processOwner(pid int) (int, error) {
+	return 0, fmt.Errorf("process owners are only available on Linux")
+}
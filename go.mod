@@ -8,6 +8,7 @@ require (
 	gopkg.in/yaml.v3 v3.0.1
 	github.com/miekg/dns v1.1.72
 	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/sys v0.39.0
 )
 
 require (
@@ -21,6 +22,5 @@ require (
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
 	golang.org/x/mod v0.31.0 // indirect
 	golang.org/x/net v0.48.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/tools v0.40.0 // indirect
 )
@@ -8,14 +8,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 
 	"network-router/client"
 	"network-router/daemon"
+	"network-router/pkg/core"
 	"network-router/tray"
 )
 
@@ -45,10 +48,20 @@ func main() {
 		runClientCommand("clear")
 	case "restart":
 		runClientCommand("restart")
+	case "reload":
+		runClientCommand("reload")
 	case "enable-dns":
 		runClientCommand("enable-dns")
 	case "disable-dns":
 		runClientCommand("disable-dns")
+	case "exec":
+		runExec()
+	case "export":
+		runExport()
+	case "import":
+		runImport()
+	case "presets":
+		runPresets()
 	case "tray-enable":
 		runTrayEnable()
 	case "tray-disable":
@@ -100,6 +113,8 @@ func runClientCommand(command string) {
 		err = c.Clear()
 	case "restart":
 		err = c.Restart()
+	case "reload":
+		err = c.Reload()
 	case "enable-dns":
 		err = c.EnableDNSProxy()
 	case "disable-dns":
@@ -112,6 +127,167 @@ func runClientCommand(command string) {
 	}
 }
 
+func runExec() {
+	execCmd := flag.NewFlagSet("exec", flag.ExitOnError)
+	via := execCmd.String("via", "phone", "Uplink for all traffic of the command")
+
+	execCmd.Parse(os.Args[2:])
+
+	if err := client.NewClient().ExecVia(*via, execCmd.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runExport() {
+	if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+		fmt.Printf("Usage: network-router export <%s> [options]\n", strings.Join(core.ExportFormats, "|"))
+		os.Exit(1)
+	}
+	format := os.Args[2]
+	exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := exportCmd.String("config", "config.yaml", "Path to configuration file")
+	output := exportCmd.String("o", "", "Output file, - for stdout (default: proxy.pac for pac, else stdout)")
+	proxy := exportCmd.String("proxy", "", "PAC result for matching hosts (default: pac_proxy or the tether proxy)")
+	resolve := exportCmd.Bool("resolve", false, "Resolve tether_domains now instead of using the routes installed by the daemon")
+	dnsServer := exportCmd.String("dns-server", "", "dnsmasq server= target for the tether domains (default: the DNS proxy)")
+	nftset := exportCmd.Bool("nftset", false, "Also write dnsmasq nftset= lines for the nftables set (always with nftset_enabled)")
+
+	exportCmd.Parse(os.Args[3:])
+
+	config, err := core.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	// Same planning as ApplyRoutes, without installing anything
+	router, err := core.NewRouter(config, nil)
+	if err != nil {
+		log.Fatalf("Failed to create router: %v", err)
+	}
+	if *resolve {
+		if err := router.DetectInterfaces(); err != nil {
+			log.Printf("Warning: %v, resolving with system DNS", err)
+		}
+		if err := router.ResolveDomains(context.Background()); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	plan := router.Plan()
+	// PAC only renders the rules; other formats need the installed routes
+	if !*resolve && format != core.ExportPAC {
+		installed, err := client.NewClient().InstalledRoutes()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot read the routes installed by the daemon: %v\nRun the daemon, or pass -resolve to resolve tether_domains now\n", err)
+			os.Exit(1)
+		}
+		plan.SetInstalled(installed.Gateway, installed.Routes)
+	}
+
+	if *proxy == "" {
+		*proxy = core.PACProxy(config)
+	}
+	if *dnsServer == "" {
+		*dnsServer = core.DnsmasqServer(config)
+	}
+	content, err := core.Export(plan, format, core.ExportOptions{
+		PACProxy:    *proxy,
+		DNSServer:   *dnsServer,
+		NFTSet:      *nftset || config.NFTSetEnabled,
+		NFTSetTable: config.NFTSetTable,
+		NFTSetName:  config.NFTSetName,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "" && format == core.ExportPAC {
+		*output = "proxy.pac"
+	}
+	if *output == "" || *output == "-" {
+		os.Stdout.Write(content)
+		return
+	}
+	if err := os.WriteFile(*output, content, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Wrote %s\n", *output)
+}
+
+func runImport() {
+	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+	format := importCmd.String("format", core.FormatList, "Input format: "+strings.Join(core.ImportFormats, ", "))
+	output := importCmd.String("o", "-", "Output file (- for stdout)")
+
+	importCmd.Parse(os.Args[2:])
+
+	if importCmd.NArg() != 1 {
+		fmt.Println("Usage: network-router import --format <format> [-o rules.yaml] <file>")
+		os.Exit(1)
+	}
+	res, err := core.ImportFile(importCmd.Arg(0), *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	content, err := res.YAML()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Keep stdout for the rules, report on stderr
+	fmt.Fprintf(os.Stderr, "✓ Imported %d domains, %d CIDRs from %s\n", len(res.Domains), len(res.CIDRs), importCmd.Arg(0))
+	if len(res.Skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "⚠ Skipped %d line(s):\n", len(res.Skipped))
+		for _, skipped := range res.Skipped {
+			fmt.Fprintf(os.Stderr, "   %s\n", skipped)
+		}
+	}
+	if *output == "-" {
+		os.Stdout.Write(content)
+		return
+	}
+	if err := os.WriteFile(*output, content, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runPresets() {
+	usage := "Usage: network-router presets <list|show <name>>"
+	if len(os.Args) < 3 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	switch os.Args[2] {
+	case "list":
+		presets, err := core.Presets()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, p := range presets {
+			fmt.Printf("%-20s v%-3d %3d domains %3d CIDRs  %s\n", p.Name, p.Version, len(p.Domains), len(p.CIDRs), p.Description)
+		}
+	case "show":
+		if len(os.Args) < 4 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		content, err := core.PresetSource(os.Args[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(content)
+	default:
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+}
+
 func runTrayEnable() {
 	userHome, _ := os.UserHomeDir()
 	uid := os.Getuid()
@@ -192,8 +368,23 @@ func printUsage() {
 	fmt.Println("  apply               Force apply routes now")
 	fmt.Println("  clear               Force clear routes now")
 	fmt.Println("  restart             Clear and re-apply routes")
+	fmt.Println("  reload              Re-read the config file and re-apply its rules")
 	fmt.Println("  enable-dns          Enable DNS Proxy")
 	fmt.Println("  disable-dns         Disable DNS Proxy")
+	fmt.Println("  exec --via phone -- <cmd>")
+	fmt.Println("                      Run a command with all its traffic via the phone (Linux)")
+	fmt.Println("  export <format> [options]")
+	fmt.Println("                      Render the rules and routes as pac, shell, dnsmasq, clash or json")
+	fmt.Println("    -config string      Path to config file (default: config.yaml)")
+	fmt.Println("    -o string           Output file, - for stdout (default: proxy.pac for pac, else stdout)")
+	fmt.Println("    -proxy string       PAC result for matching hosts")
+	fmt.Println("    -resolve            Resolve domains now instead of using the installed routes")
+	fmt.Println("  import [options] <file>")
+	fmt.Println("                      Convert a domain list to tether rules (YAML)")
+	fmt.Println("    -format string      list, dnsmasq, hosts, clash or surge (default: list)")
+	fmt.Println("    -o string           Output file, - for stdout (default: -)")
+	fmt.Println("  presets list        List the built-in rule presets")
+	fmt.Println("  presets show <name> Print a preset's rules")
 	fmt.Println("  tray-enable         Register and start the tray icon")
 	fmt.Println("  tray-disable        Stop and unregister the tray icon")
 	fmt.Println()
@@ -204,5 +395,6 @@ func printUsage() {
 	fmt.Println("  ./network-router status")
 	fmt.Println("  ./network-router enable")
 	fmt.Println("  ./network-router apply")
+	fmt.Println("  ./network-router exec --via phone -- curl https://example.com")
 	fmt.Println()
 }
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+const (
+	defaultAppCgroup   = "network-router/phone"
+	defaultAppNFTTable = "network_router_apps"
+	defaultAppInterval = 5 * time.Second
+)
+
+// AppRouter sends everything selected applications send via the phone,
+// whatever the destination. Processes running one of Apps, and processes
+// started with `network-router exec --via phone`, are moved into a cgroup
+// whose sockets are marked with policy_fwmark; the sockets of Units are
+// marked in their own cgroup, so systemd keeps managing them.
+type AppRouter struct {
+	Cgroup   *utils.AppCgroup
+	Apps     []string // Absolute executable paths or file names
+	Units    []string
+	ProcRoot string
+	Interval time.Duration
+
+	mu    sync.Mutex
+	units []string // Unit cgroups currently marked
+}
+
+// NewAppRouter creates the application router configured by
+// app_routing_enabled. Like the nftables set it relies on the policy
+// backend's fwmark rule.
+func NewAppRouter(config *Config) (*AppRouter, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("app_routing_enabled is only supported on Linux")
+	}
+	if config.RouteBackend != "" && config.RouteBackend != BackendPolicy {
+		return nil, fmt.Errorf("app_routing_enabled requires route_backend %q", BackendPolicy)
+	}
+	if config.PolicyFwMark == 0 {
+		return nil, fmt.Errorf("app_routing_enabled requires policy_fwmark")
+	}
+	path := config.AppCgroup
+	if path == "" {
+		path = defaultAppCgroup
+	}
+	table := config.AppNFTTable
+	if table == "" {
+		table = defaultAppNFTTable
+	}
+	return &AppRouter{
+		Cgroup:   utils.NewAppCgroup(path, table, config.PolicyFwMark),
+		Apps:     config.PhoneApps,
+		Units:    config.PhoneUnits,
+		ProcRoot: "/proc",
+		Interval: defaultAppInterval,
+	}, nil
+}
+
+// Setup creates the cgroup and its marking rules, then picks up the
+// configured applications already running
+func (a *AppRouter) Setup(ctx context.Context) error {
+	if err := a.Cgroup.Setup(ctx); err != nil {
+		return err
+	}
+	a.Sync(ctx)
+	return nil
+}
+
+// Run keeps the cgroup in sync with running applications and units until
+// ctx is done
+func (a *AppRouter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			a.Sync(ctx)
+		}
+	}
+}
+
+// Sync moves new processes of the configured applications into the cgroup
+// and updates the rules for units that started or stopped
+func (a *AppRouter) Sync(ctx context.Context) {
+	if len(a.Units) > 0 {
+		if err := a.syncUnits(ctx); err != nil {
+			log.Printf("Warning: Could not update unit cgroups: %v", err)
+		}
+	}
+	if len(a.Apps) > 0 {
+		a.syncApps()
+	}
+}
+
+// AddProcess routes pid and the processes it starts from now on via the phone
+func (a *AppRouter) AddProcess(pid int) error {
+	return a.Cgroup.AddProcess(pid)
+}
+
+// Teardown removes the marking rules and the cgroup
+func (a *AppRouter) Teardown(ctx context.Context) error {
+	a.mu.Lock()
+	a.units = nil
+	a.mu.Unlock()
+	return a.Cgroup.Teardown(ctx)
+}
+
+func (a *AppRouter) syncUnits(ctx context.Context) error {
+	var paths []string
+	for _, unit := range a.Units {
+		path, err := utils.UnitCgroup(ctx, unit)
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if slices.Equal(paths, a.units) {
+		return nil
+	}
+	if err := a.Cgroup.MarkCgroups(ctx, paths); err != nil {
+		return err
+	}
+	a.units = paths
+	log.Printf("📱 Routing %d of %d unit(s) via Phone: %v", len(paths), len(a.Units), paths)
+	return nil
+}
+
+// syncApps scans the process table for the configured executables
+func (a *AppRouter) syncApps() {
+	entries, err := os.ReadDir(a.ProcRoot)
+	if err != nil {
+		log.Printf("Warning: Could not list processes: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		exe, err := os.Readlink(filepath.Join(a.ProcRoot, entry.Name(), "exe"))
+		if err != nil || !a.matchApp(exe) {
+			continue // Kernel thread, exited or not ours
+		}
+		cgroup, err := utils.ProcessCgroup(a.ProcRoot, pid)
+		if err != nil || cgroup == a.Cgroup.Path || strings.HasPrefix(cgroup, a.Cgroup.Path+"/") {
+			continue
+		}
+		if err := a.Cgroup.AddProcess(pid); err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		log.Printf("📱 Routing %s (pid %d) via Phone", exe, pid)
+	}
+}
+
+func (a *AppRouter) matchApp(exe string) bool {
+	exe = strings.TrimSuffix(exe, " (deleted)") // Binary replaced by an upgrade
+	for _, app := range a.Apps {
+		if strings.Contains(app, "/") {
+			if exe == app {
+				return true
+			}
+		} else if filepath.Base(exe) == app {
+			return true
+		}
+	}
+	return false
+}
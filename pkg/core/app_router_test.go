@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"network-router/pkg/internal/utilstest"
+	"network-router/pkg/utils"
+)
+
+func TestAppRouterSync(t *testing.T) {
+	fake := utilstest.NewFakeRunner()
+	fake.On("systemctl show --property ControlGroup --value agent.service", "/system.slice/agent.service\n", nil)
+	fake.On("systemctl show --property ControlGroup --value stopped.service", "\n", nil)
+	fake.On(`sudo nft flush chain inet network_router_apps extra; add rule inet network_router_apps extra socket cgroupv2 level 2 "system.slice/agent.service" meta mark set 0x66`, "", nil)
+	prev := utils.SetRunner(fake)
+	t.Cleanup(func() { utils.SetRunner(prev) })
+
+	proc := t.TempDir()
+	for pid, p := range map[string]struct{ exe, cgroup string }{
+		"100": {"/opt/agent/bin/copilot-agent", "user.slice/session-1.scope"},
+		"200": {"/usr/bin/firefox", "user.slice/session-1.scope"},
+		"300": {"/usr/bin/chromium (deleted)", "network-router/phone"}, // Already moved
+		"400": {"/usr/local/bin/chromium", "user.slice/session-1.scope"},
+	} {
+		dir := filepath.Join(proc, pid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(p.exe, filepath.Join(dir, "exe")); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, dir, "cgroup", "0::/"+p.cgroup+"\n")
+	}
+
+	cgroup := &utils.AppCgroup{Root: t.TempDir(), Path: "network-router/phone", Table: "network_router_apps", Mark: 0x66}
+	if err := os.MkdirAll(cgroup.Dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	apps := &AppRouter{
+		Cgroup:   cgroup,
+		Apps:     []string{"copilot-agent", "/usr/bin/chromium"},
+		Units:    []string{"agent.service", "stopped.service"},
+		ProcRoot: proc,
+	}
+	apps.Sync(context.Background())
+	apps.Sync(context.Background()) // Unchanged units don't touch nftables
+
+	// Only copilot-agent matches and isn't in the cgroup yet; chromium
+	// is matched by its full path only
+	pids, err := cgroup.Processes()
+	if err != nil {
+		t.Fatalf("Processes failed: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []int{100}) {
+		t.Errorf("Expected pid 100 moved to the cgroup, got %v", pids)
+	}
+	nft := 0
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call, "sudo nft") {
+			nft++
+		}
+	}
+	if nft != 1 {
+		t.Errorf("Expected 1 nft call, got %d: %v", nft, fake.Calls())
+	}
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// defaultAggregateMinBits is the shortest prefix length Aggregate may widen
+// routes to when the caller does not specify one.
+const defaultAggregateMinBits = 24
+
+// ipRange is an inclusive range of IPv4 addresses
+type ipRange struct {
+	start uint32
+	end   uint32
+}
+
+// CIDRSet is a set of IPv4 addresses kept as sorted, non-overlapping,
+// non-adjacent ranges. It supports union, subtraction and conversion back to
+// the minimal list of CIDR prefixes covering exactly the same addresses.
+type CIDRSet struct {
+	ranges []ipRange
+}
+
+// NewCIDRSet creates an empty set
+func NewCIDRSet() *CIDRSet {
+	return &CIDRSet{}
+}
+
+// ParseCIDRSet builds a set from a list of CIDRs and/or bare IPs
+func ParseCIDRSet(entries []string) (*CIDRSet, error) {
+	s := NewCIDRSet()
+	for _, entry := range entries {
+		if err := s.Add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseEntry parses "1.2.3.0/24" or "1.2.3.4" into an IPv4 range
+func parseEntry(entry string) (ipRange, error) {
+	entry = strings.TrimSpace(entry)
+	var prefix netip.Prefix
+	if isCIDR(entry) {
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		prefix = p
+	} else {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid IP %q: %w", entry, err)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if !prefix.Addr().Unmap().Is4() {
+		return ipRange{}, fmt.Errorf("only IPv4 is supported: %q", entry)
+	}
+	return prefixToRange(netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits())), nil
+}
+
+// Add inserts a CIDR or bare IP into the set
+func (s *CIDRSet) Add(entry string) error {
+	r, err := parseEntry(entry)
+	if err != nil {
+		return err
+	}
+	s.addRange(r)
+	return nil
+}
+
+// Remove subtracts a CIDR or bare IP from the set
+func (s *CIDRSet) Remove(entry string) error {
+	r, err := parseEntry(entry)
+	if err != nil {
+		return err
+	}
+	s.removeRange(r)
+	return nil
+}
+
+// Union adds every address of other to s
+func (s *CIDRSet) Union(other *CIDRSet) {
+	for _, r := range other.ranges {
+		s.addRange(r)
+	}
+}
+
+// Subtract removes every address of other from s
+func (s *CIDRSet) Subtract(other *CIDRSet) {
+	for _, r := range other.ranges {
+		s.removeRange(r)
+	}
+}
+
+// Contains reports whether the IP (or every address of the CIDR) is in the set
+func (s *CIDRSet) Contains(entry string) bool {
+	r, err := parseEntry(entry)
+	if err != nil {
+		return false
+	}
+	i := s.search(r.start)
+	return i < len(s.ranges) && s.ranges[i].start <= r.start && r.end <= s.ranges[i].end
+}
+
+// IsEmpty reports whether the set holds no addresses
+func (s *CIDRSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+// Clone returns an independent copy of the set
+func (s *CIDRSet) Clone() *CIDRSet {
+	return &CIDRSet{ranges: append([]ipRange(nil), s.ranges...)}
+}
+
+// Prefixes returns the minimal list of prefixes covering exactly the set
+func (s *CIDRSet) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range s.ranges {
+		prefixes = append(prefixes, rangeToPrefixes(r)...)
+	}
+	return prefixes
+}
+
+// Strings returns Prefixes formatted as CIDR strings
+func (s *CIDRSet) Strings() []string {
+	prefixes := s.Prefixes()
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+// Aggregate returns a superset of s made of the fewest prefixes such that
+// each widened prefix wastes at most maxWaste (0..1) of its addresses on
+// destinations that were not in s. Prefixes are never widened beyond
+// minBits (use 0 for the default); fully covered prefixes are always merged.
+func (s *CIDRSet) Aggregate(maxWaste float64, minBits int) *CIDRSet {
+	if minBits <= 0 {
+		minBits = defaultAggregateMinBits
+	}
+	out := NewCIDRSet()
+	s.aggregateInto(out, ipRange{start: 0, end: ^uint32(0)}, 0, maxWaste, minBits)
+	return out
+}
+
+func (s *CIDRSet) aggregateInto(out *CIDRSet, block ipRange, bitLen int, maxWaste float64, minBits int) {
+	covered := s.coveredIn(block)
+	if covered == 0 {
+		return
+	}
+	size := uint64(block.end-block.start) + 1
+	waste := float64(size-covered) / float64(size)
+	// Widening only pays off when it replaces more than one exact prefix
+	if covered == size || (bitLen >= minBits && waste <= maxWaste && s.prefixCountIn(block) > 1) {
+		out.addRange(block)
+		return
+	}
+	half := uint32(size / 2)
+	s.aggregateInto(out, ipRange{start: block.start, end: block.start + half - 1}, bitLen+1, maxWaste, minBits)
+	s.aggregateInto(out, ipRange{start: block.start + half, end: block.end}, bitLen+1, maxWaste, minBits)
+}
+
+// coveredIn counts how many addresses of block are in the set
+func (s *CIDRSet) coveredIn(block ipRange) uint64 {
+	var total uint64
+	for i := s.search(block.start); i < len(s.ranges) && s.ranges[i].start <= block.end; i++ {
+		lo := max(s.ranges[i].start, block.start)
+		hi := min(s.ranges[i].end, block.end)
+		total += uint64(hi-lo) + 1
+	}
+	return total
+}
+
+// prefixCountIn counts the exact prefixes needed for the part of the set inside block
+func (s *CIDRSet) prefixCountIn(block ipRange) int {
+	count := 0
+	for i := s.search(block.start); i < len(s.ranges) && s.ranges[i].start <= block.end; i++ {
+		lo := max(s.ranges[i].start, block.start)
+		hi := min(s.ranges[i].end, block.end)
+		count += len(rangeToPrefixes(ipRange{start: lo, end: hi}))
+	}
+	return count
+}
+
+// search returns the index of the first range whose end is >= ip
+func (s *CIDRSet) search(ip uint32) int {
+	return sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].end >= ip
+	})
+}
+
+func (s *CIDRSet) addRange(r ipRange) {
+	// Ranges touching r (overlapping or adjacent) are merged into it
+	lo := r.start
+	if lo > 0 {
+		lo--
+	}
+	i := s.search(lo)
+	j := i
+	for j < len(s.ranges) && (r.end == ^uint32(0) || s.ranges[j].start <= r.end+1) {
+		r.start = min(r.start, s.ranges[j].start)
+		r.end = max(r.end, s.ranges[j].end)
+		j++
+	}
+	s.ranges = append(s.ranges[:i], append([]ipRange{r}, s.ranges[j:]...)...)
+}
+
+func (s *CIDRSet) removeRange(r ipRange) {
+	var kept []ipRange
+	for _, cur := range s.ranges {
+		if cur.end < r.start || cur.start > r.end {
+			kept = append(kept, cur)
+			continue
+		}
+		if cur.start < r.start {
+			kept = append(kept, ipRange{start: cur.start, end: r.start - 1})
+		}
+		if cur.end > r.end {
+			kept = append(kept, ipRange{start: r.end + 1, end: cur.end})
+		}
+	}
+	s.ranges = kept
+}
+
+func prefixToRange(p netip.Prefix) ipRange {
+	p = p.Masked()
+	a := p.Addr().As4()
+	start := binary.BigEndian.Uint32(a[:])
+	hostBits := 32 - p.Bits()
+	var end uint32
+	if hostBits == 32 {
+		end = ^uint32(0)
+	} else {
+		end = start | (uint32(1)<<hostBits - 1)
+	}
+	return ipRange{start: start, end: end}
+}
+
+// rangeToPrefixes splits an inclusive range into the fewest aligned prefixes
+func rangeToPrefixes(r ipRange) []netip.Prefix {
+	var prefixes []netip.Prefix
+	start := uint64(r.start)
+	end := uint64(r.end)
+	for start <= end {
+		// Largest block aligned at start...
+		hostBits := 32
+		if start != 0 {
+			hostBits = bits.TrailingZeros64(start)
+			if hostBits > 32 {
+				hostBits = 32
+			}
+		}
+		// ...that still fits in the remaining range
+		for hostBits > 0 && start+(uint64(1)<<hostBits)-1 > end {
+			hostBits--
+		}
+		var a [4]byte
+		binary.BigEndian.PutUint32(a[:], uint32(start))
+		prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom4(a), 32-hostBits))
+		start += uint64(1) << hostBits
+	}
+	return prefixes
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCIDRSetSubtract(t *testing.T) {
+	set, err := ParseCIDRSet([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseCIDRSet failed: %v", err)
+	}
+	exclude, err := ParseCIDRSet([]string{"10.20.0.0/16"})
+	if err != nil {
+		t.Fatalf("ParseCIDRSet failed: %v", err)
+	}
+	set.Subtract(exclude)
+
+	want := []string{
+		"10.0.0.0/12", "10.16.0.0/14", "10.21.0.0/16", "10.22.0.0/15",
+		"10.24.0.0/13", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/9",
+	}
+	if got := set.Strings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("10.0.0.0/8 - 10.20.0.0/16 = %v, want %v", got, want)
+	}
+	if set.Contains("10.20.1.1") {
+		t.Errorf("excluded address still in set")
+	}
+	if !set.Contains("10.21.1.1") {
+		t.Errorf("expected 10.21.1.1 to remain in set")
+	}
+}
+
+func TestCIDRSetMerge(t *testing.T) {
+	set, err := ParseCIDRSet([]string{"192.168.0.0/25", "192.168.0.128/25", "192.168.0.10", "8.8.8.8"})
+	if err != nil {
+		t.Fatalf("ParseCIDRSet failed: %v", err)
+	}
+	want := []string{"8.8.8.8/32", "192.168.0.0/24"}
+	if got := set.Strings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged set = %v, want %v", got, want)
+	}
+}
+
+func TestCIDRSetAggregate(t *testing.T) {
+	set, err := ParseCIDRSet([]string{"1.2.3.1", "1.2.3.2", "1.2.3.3", "1.2.3.200", "9.9.9.9"})
+	if err != nil {
+		t.Fatalf("ParseCIDRSet failed: %v", err)
+	}
+
+	// No waste budget: only exact merges
+	exact := set.Aggregate(0, 0).Strings()
+	want := []string{"1.2.3.1/32", "1.2.3.2/31", "1.2.3.200/32", "9.9.9.9/32"}
+	if !reflect.DeepEqual(exact, want) {
+		t.Errorf("Aggregate(0) = %v, want %v", exact, want)
+	}
+
+	// 3 of 4 addresses in 1.2.3.0/30 are resolved: 25% waste
+	agg := set.Aggregate(0.25, 0).Strings()
+	want = []string{"1.2.3.0/30", "1.2.3.200/32", "9.9.9.9/32"}
+	if !reflect.DeepEqual(agg, want) {
+		t.Errorf("Aggregate(0.25) = %v, want %v", agg, want)
+	}
+
+	// Never widen past the minimum prefix length, whatever the budget
+	wide := set.Aggregate(1, 24).Strings()
+	want = []string{"1.2.3.0/24", "9.9.9.9/32"}
+	if !reflect.DeepEqual(wide, want) {
+		t.Errorf("Aggregate(1, 24) = %v, want %v", wide, want)
+	}
+}
+
+func TestCIDRSetRejectsIPv6(t *testing.T) {
+	if err := NewCIDRSet().Add("2001:db8::/32"); err == nil {
+		t.Errorf("expected error for IPv6 prefix")
+	}
+}
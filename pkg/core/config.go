@@ -2,6 +2,10 @@ package core
 
 import (
 	"os"
+	"path/filepath"
+	"time"
+
+	"network-router/pkg/utils"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,13 +14,109 @@ import (
 type Config struct {
 	TetherDomains         []string `yaml:"tether_domains"`
 	TetherCIDRs           []string `yaml:"tether_cidrs"`
-	WifiInterfaceKeyword  string   `yaml:"wifi_interface_name"`
-	PhoneInterfaceKeyword string   `yaml:"phone_interface_name"`
-	RouteRefreshCron      string   `yaml:"route_refresh_cron"` // Cron expression for scheduled refresh
-	AutoRefreshRoute      bool     `yaml:"auto_refresh_route"` // Enable/disable scheduled refresh
+	TetherCIDRsExclude    []string `yaml:"tether_cidrs_exclude"`       // Ranges carved out of tether_cidrs and resolved IPs
+	AggregateWaste        float64  `yaml:"route_aggregate_waste"`      // Max fraction (0..1) of an aggregated prefix not backed by a resolved IP
+	AggregateMinPrefix    int      `yaml:"route_aggregate_min_prefix"` // Never aggregate wider than this prefix length (default 24)
+	WifiInterfaceKeyword  string   `yaml:"wifi_interface_name"`        // Legacy: substring of the hardware port name or device name
+	PhoneInterfaceKeyword string   `yaml:"phone_interface_name"`       // Legacy: substring of the hardware port name or device name
+	RouteRefreshCron      string   `yaml:"route_refresh_cron"`         // Cron expression for scheduled refresh
+	AutoRefreshRoute      bool     `yaml:"auto_refresh_route"`         // Enable/disable scheduled refresh
 	DNSProxyEnabled       bool     `yaml:"dns_proxy_enabled"`
 	DNSProxyPort          int      `yaml:"dns_proxy_port"`
+	DNSProxyListen        []string `yaml:"dns_proxy_listen"` // Addresses (host or host:port) served over UDP and TCP (default 127.0.0.1 and ::1)
 	DNSUpstream           string   `yaml:"dns_upstream"`
+
+	// DNS proxy upstreams per domain group, first match wins; other queries go to dns_upstream or the system DNS
+	DNSUpstreams []ResolverConfig `yaml:"dns_upstreams"`
+
+	// Built-in rule presets (see `network-router presets list`), merged into tether_domains/tether_cidrs
+	Presets []PresetConfig `yaml:"presets"`
+
+	// DNS proxy response cache
+	DNSCacheSize        int           `yaml:"dns_cache_size"`         // Max cached responses (default 4096, negative = off)
+	DNSCacheNegativeTTL time.Duration `yaml:"dns_cache_negative_ttl"` // Cap for cached NXDOMAIN/NODATA (default 5m)
+	DNSServeStale       time.Duration `yaml:"dns_serve_stale"`        // Serve expired answers this long while upstreams fail (default 1h, negative = off)
+
+	// Rule lists in other formats, merged into tether_domains/tether_cidrs on every (re)load
+	Include []IncludeConfig `yaml:"include"`
+
+	// Provider IP range documents (Google, GitHub, AWS), merged into tether_cidrs on every (re)load
+	IPRangeSources      []IPRangeSource `yaml:"ip_range_sources"`
+	SourceWatchInterval time.Duration   `yaml:"source_watch_interval"` // Reload when an include or source file changes (default 10s, negative = off)
+
+	// External programs printing rules as JSON, merged under their name while the daemon runs
+	RouteProviders []RouteProviderConfig `yaml:"route_providers"`
+
+	// Destinations matched by origin ASN or country in local databases
+	TetherASNs      []string `yaml:"tether_asns"`      // "AS15169" or 15169
+	TetherCountries []string `yaml:"tether_countries"` // ISO 3166 alpha-2 codes, e.g. "SG"
+	GeoIPDatabases  []string `yaml:"geoip_databases"`  // .mmdb files, or CSV/TSV (ip2asn, DB-IP, GeoLite2 ASN blocks)
+
+	// Static domain resolution
+	DNSResolveConcurrency int              `yaml:"dns_resolve_concurrency"` // Parallel lookups (default 8)
+	DNSResolveTimeout     time.Duration    `yaml:"dns_resolve_timeout"`     // Overall deadline for ResolveDomains (default 60s)
+	Resolvers             []ResolverConfig `yaml:"resolvers"`               // Per domain group resolver, first match wins (default: system)
+
+	// Route installation
+	RouteWorkers    int           `yaml:"route_workers"`     // Concurrent route commands (default 4)
+	RouteOpTimeout  time.Duration `yaml:"route_op_timeout"`  // Timeout of a single route command (default 10s)
+	RouteMaxRetries int           `yaml:"route_max_retries"` // Attempts per route for retryable errors (default 3)
+
+	// Routing backend
+	RouteBackend       string `yaml:"route_backend"`        // "route" (routes in the main table) or "policy" (Linux only, default there)
+	PolicyTable        int    `yaml:"policy_table"`         // Dedicated routing table id (default 100)
+	PolicyRulePriority int    `yaml:"policy_rule_priority"` // Priority of the ip rules (default 10000)
+	PolicyFwMark       uint32 `yaml:"policy_fwmark"`        // Also route packets with this mark via the table (0 = off)
+
+	// nftables sets for DNS-learned IPs (Linux, policy backend with policy_fwmark)
+	NFTSetEnabled bool   `yaml:"nftset_enabled"` // Add learned IPs to a set instead of one route per IP
+	NFTSetTable   string `yaml:"nftset_table"`   // inet table owned by network-router (default network_router)
+	NFTSetName    string `yaml:"nftset_name"`    // Set name (default tether4)
+
+	// Per-application routing (Linux, policy backend with policy_fwmark)
+	AppRoutingEnabled bool     `yaml:"app_routing_enabled"` // Route everything sent by a cgroup via the phone, also used by `exec --via phone`
+	PhoneApps         []string `yaml:"phone_apps"`          // Executables (absolute path or file name) moved into the cgroup
+	PhoneUnits        []string `yaml:"phone_units"`         // systemd units whose own cgroup is routed too
+	AppCgroup         string   `yaml:"app_cgroup"`          // cgroup v2 path below /sys/fs/cgroup (default network-router/phone)
+	AppNFTTable       string   `yaml:"app_nft_table"`       // inet table owned by network-router (default network_router_apps)
+
+	// Local SOCKS5/HTTP CONNECT proxy dialing matching destinations via the phone
+	ProxyEnabled bool   `yaml:"proxy_enabled"`
+	ProxyListen  string `yaml:"proxy_listen"` // host:port for both protocols (default 127.0.0.1:1080)
+
+	// Proxy auto-config (PAC) script for browsers
+	PACEnabled bool   `yaml:"pac_enabled"` // Serve the script over HTTP
+	PACListen  string `yaml:"pac_listen"`  // host:port of the PAC server (default 127.0.0.1:1079)
+	PACProxy   string `yaml:"pac_proxy"`   // PAC result for matching hosts (default: the tether proxy)
+
+	// DNS TTL-aware route expiry
+	RouteExpiryEnabled bool          `yaml:"route_expiry_enabled"` // Re-resolve expired domains and retire idle IPs
+	DNSTTLMin          time.Duration `yaml:"dns_ttl_min"`          // Lower bound applied to answer TTLs (e.g. "1m")
+	DNSTTLMax          time.Duration `yaml:"dns_ttl_max"`          // Upper bound applied to answer TTLs (e.g. "1h")
+	RouteIdleTimeout   time.Duration `yaml:"route_idle_timeout"`   // Retire IPs not seen in answers for this long (0 = never)
+
+	// Interface selection, tried in order (first selector with a match wins)
+	WifiInterfaces  []utils.InterfaceSelector `yaml:"wifi_interfaces"`
+	PhoneInterfaces []utils.InterfaceSelector `yaml:"phone_interfaces"`
+
+	sourceFiles []string    // Include, ip_range_sources and geoip_databases files read by LoadConfig
+	geoIP       *GeoIPRules // Loaded tether_asns/tether_countries rules
+
+	appliedPresets []string            // "name@version" of the merged presets
+	providerCIDRs  map[string]*CIDRSet // CIDRs per route provider (see WithProviderRules)
+}
+
+// ResolverConfig selects how a group of tether domains is resolved for static routes
+type ResolverConfig struct {
+	Type    string   `yaml:"type"`    // "system" (default), "server", "phone" (DNS learned via DHCP on the phone link), "doh" or "dot"
+	Server  string   `yaml:"server"`  // host[:port] for types "server" and "dot" (port 853)
+	Bind    string   `yaml:"bind"`    // "phone" or "wifi": send queries through that interface only
+	Domains []string `yaml:"domains"` // Domain patterns using this resolver; empty matches every domain
+
+	// Encrypted upstreams
+	URL       string   `yaml:"url"`        // https://host/dns-query for type "doh"
+	Bootstrap []string `yaml:"bootstrap"`  // IPs of the doh/dot server, so its name is never looked up in plain DNS
+	PinSHA256 []string `yaml:"pin_sha256"` // Base64 SHA-256 of accepted certificate public keys (SPKI); empty = any valid certificate
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -29,12 +129,31 @@ func LoadConfig(path string) (*Config, error) {
 
 	var cfg Config
 	decoder := yaml.NewDecoder(file)
-	err = decoder.Decode(&cfg)
+	if err := decoder.Decode(&cfg); err != nil {
+		return &cfg, err
+	}
+	if err := cfg.mergePresets(); err != nil {
+		return &cfg, err
+	}
+	if err := cfg.mergeIncludes(filepath.Dir(path)); err != nil {
+		return &cfg, err
+	}
+	if err := cfg.mergeIPRanges(filepath.Dir(path)); err != nil {
+		return &cfg, err
+	}
+	err = cfg.mergeGeoIP(filepath.Dir(path))
 	return &cfg, err
 }
 
+// SourceFiles returns the files LoadConfig merged into the tether rules
+func (c *Config) SourceFiles() []string {
+	return c.sourceFiles
+}
+
 // ResolvedIPs stores resolved IPs and CIDRs for cleanup
 type ResolvedIPs struct {
-	IPs   []string `yaml:"ips"`
-	CIDRs []string `yaml:"cidrs"`
+	IPs     []string     `yaml:"ips,omitempty"`     // Legacy: resolved IPs
+	CIDRs   []string     `yaml:"cidrs,omitempty"`   // Legacy: configured CIDRs
+	Routes  []string     `yaml:"routes,omitempty"`  // Destinations actually installed (after aggregation)
+	Entries []RouteEntry `yaml:"entries,omitempty"` // Route registry with metadata
 }
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDNSCacheSize   = 4096
+	defaultDNSNegativeTTL = 5 * time.Minute // Cap for NXDOMAIN/NODATA (RFC 2308)
+	defaultDNSServeStale  = time.Hour
+	dnsCacheMaxTTL        = 24 * time.Hour
+	dnsStaleTTL           = 30 // Seconds, TTL of stale answers (RFC 8767)
+)
+
+// DNSCacheStats are the counters reported by status
+type DNSCacheStats struct {
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	StaleHits uint64 `json:"stale_hits"` // Expired answers served while upstreams failed
+	Evictions uint64 `json:"evictions"`
+}
+
+// DNSCache is a size-bounded LRU cache of upstream responses. Answers live
+// for their smallest TTL, NXDOMAIN and NODATA for the SOA minimum capped by
+// the negative TTL. Expired entries are kept for the serve-stale window
+// and only returned by GetStale.
+type DNSCache struct {
+	maxEntries  int
+	negativeTTL time.Duration
+	staleWindow time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[dnsCacheKey]*list.Element
+	lru     *list.List // Front is most recently used
+	stats   DNSCacheStats
+}
+
+type dnsCacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	do     bool // DNSSEC records requested
+}
+
+type dnsCacheEntry struct {
+	key      dnsCacheKey
+	msg      *dns.Msg
+	storedAt time.Time
+	expires  time.Time
+}
+
+// NewDNSCache creates the cache of config, nil when disabled
+func NewDNSCache(config *Config) *DNSCache {
+	size := config.DNSCacheSize
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultDNSCacheSize
+	}
+	negativeTTL := config.DNSCacheNegativeTTL
+	if negativeTTL <= 0 {
+		negativeTTL = defaultDNSNegativeTTL
+	}
+	staleWindow := config.DNSServeStale
+	if staleWindow == 0 {
+		staleWindow = defaultDNSServeStale
+	}
+	return &DNSCache{
+		maxEntries:  size,
+		negativeTTL: negativeTTL,
+		staleWindow: max(staleWindow, 0), // Negative turns serve-stale off
+		now:         time.Now,
+		entries:     make(map[dnsCacheKey]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+func cacheKeyOf(req *dns.Msg) (dnsCacheKey, bool) {
+	if len(req.Question) != 1 {
+		return dnsCacheKey{}, false
+	}
+	q := req.Question[0]
+	key := dnsCacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}
+	if opt := req.IsEdns0(); opt != nil {
+		key.do = opt.Do()
+	}
+	return key, true
+}
+
+// Get returns a fresh cached reply to req with TTLs counted down, or nil
+func (c *DNSCache) Get(req *dns.Msg) *dns.Msg {
+	key, ok := cacheKeyOf(req)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	entry := c.lookup(key, now)
+	if entry == nil || !now.Before(entry.expires) {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	return entry.reply(req, uint32(now.Sub(entry.storedAt)/time.Second), 0)
+}
+
+// GetStale returns an expired reply still within the serve-stale window,
+// for use when no upstream answers, or nil
+func (c *DNSCache) GetStale(req *dns.Msg) *dns.Msg {
+	key, ok := cacheKeyOf(req)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.lookup(key, c.now())
+	if entry == nil {
+		return nil
+	}
+	c.stats.StaleHits++
+	return entry.reply(req, 0, dnsStaleTTL)
+}
+
+// lookup finds the entry of key and marks it used; entries past the
+// serve-stale window are dropped
+func (c *DNSCache) lookup(key dnsCacheKey, now time.Time) *dnsCacheEntry {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*dnsCacheEntry)
+	if now.After(entry.expires.Add(c.staleWindow)) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+// Put caches the upstream response to req if it is cacheable: successful
+// answers, NXDOMAIN and NODATA; never truncated or failed responses
+func (c *DNSCache) Put(req, resp *dns.Msg) {
+	key, ok := cacheKeyOf(req)
+	if !ok || resp.Truncated {
+		return
+	}
+	var ttl time.Duration
+	switch {
+	case resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		ttl = time.Duration(minTTL(resp.Answer)) * time.Second
+	case resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError:
+		ttl = c.negativeTTL
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(ttl, time.Duration(min(soa.Hdr.Ttl, soa.Minttl))*time.Second)
+			}
+		}
+	default:
+		return
+	}
+	if ttl <= 0 {
+		return
+	}
+	ttl = min(ttl, dnsCacheMaxTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	entry := &dnsCacheEntry{key: key, msg: resp.Copy(), storedAt: now, expires: now.Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// Stats returns the current counters
+func (c *DNSCache) Stats() DNSCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// reply builds the answer to req: TTLs are reduced by age, or all set to
+// fixedTTL when it is not 0
+func (e *dnsCacheEntry) reply(req *dns.Msg, age, fixedTTL uint32) *dns.Msg {
+	resp := e.msg.Copy()
+	resp.Id = req.Id
+	resp.Question = req.Question
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			switch {
+			case hdr.Rrtype == dns.TypeOPT:
+			case fixedTTL != 0:
+				hdr.Ttl = fixedTTL
+			case hdr.Ttl > age:
+				hdr.Ttl -= age
+			default:
+				hdr.Ttl = 0
+			}
+		}
+	}
+	return resp
+}
+
+func minTTL(rrs []dns.RR) uint32 {
+	var ttl uint32
+	for i, rr := range rrs {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}
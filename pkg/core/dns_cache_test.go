@@ -0,0 +1,136 @@
+package core
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func answer(req *dns.Msg, ip string, ttl uint32) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP(ip),
+	})
+	return resp
+}
+
+func TestDNSCache(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewDNSCache(&Config{DNSCacheSize: 2, DNSServeStale: 10 * time.Minute})
+	cache.now = func() time.Time { return now }
+
+	req := new(dns.Msg)
+	req.SetQuestion("api.github.com.", dns.TypeA)
+	if cache.Get(req) != nil {
+		t.Fatal("Expected a miss on an empty cache")
+	}
+	cache.Put(req, answer(req, "140.82.112.5", 60))
+
+	// Names are matched case-insensitively, TTLs count down
+	now = now.Add(15 * time.Second)
+	upper := new(dns.Msg)
+	upper.SetQuestion("API.GitHub.com.", dns.TypeA)
+	resp := cache.Get(upper)
+	if resp == nil || resp.Id != upper.Id || resp.Answer[0].Header().Ttl != 45 {
+		t.Fatalf("Expected a hit with TTL 45 for the new ID, got %v", resp)
+	}
+
+	// Expired: a miss, but served stale with a short TTL within the window
+	now = now.Add(time.Minute)
+	if cache.Get(req) != nil {
+		t.Error("Expected a miss after the TTL")
+	}
+	if stale := cache.GetStale(req); stale == nil || stale.Answer[0].Header().Ttl != dnsStaleTTL {
+		t.Errorf("Expected a stale answer with TTL %d, got %v", dnsStaleTTL, stale)
+	}
+	now = now.Add(10 * time.Minute)
+	if cache.GetStale(req) != nil {
+		t.Error("Expected no stale answer after the serve-stale window")
+	}
+
+	// NXDOMAIN is cached for the SOA minimum, SERVFAIL and truncated answers never
+	nx := new(dns.Msg)
+	nx.SetQuestion("missing.example.", dns.TypeA)
+	nxResp := new(dns.Msg)
+	nxResp.SetRcode(nx, dns.RcodeNameError)
+	nxResp.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600}, Minttl: 30}}
+	cache.Put(nx, nxResp)
+	if resp := cache.Get(nx); resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected a cached NXDOMAIN, got %v", resp)
+	}
+	now = now.Add(31 * time.Second)
+	if cache.Get(nx) != nil {
+		t.Error("Expected the NXDOMAIN to expire with the SOA minimum")
+	}
+	fail := new(dns.Msg)
+	fail.SetQuestion("flaky.example.", dns.TypeA)
+	failResp := new(dns.Msg)
+	failResp.SetRcode(fail, dns.RcodeServerFailure)
+	cache.Put(fail, failResp)
+	tc := answer(fail, "10.0.0.1", 60)
+	tc.Truncated = true
+	cache.Put(fail, tc)
+	if cache.GetStale(fail) != nil {
+		t.Error("SERVFAIL and truncated responses must not be cached")
+	}
+
+	// The least recently used entries (the NXDOMAIN, then a) are evicted beyond the size
+	for _, name := range []string{"a.example.", "b.example.", "c.example."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		cache.Put(q, answer(q, "10.0.0.1", 300))
+	}
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Evictions != 2 || stats.Hits != 2 || stats.StaleHits != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestDNSProxyServeStale(t *testing.T) {
+	var queries atomic.Int32
+	var down atomic.Bool
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		if down.Load() {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			w.WriteMsg(m)
+			return
+		}
+		w.WriteMsg(answer(r, "140.82.112.5", 1))
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	proxy := NewDNSProxy(&Config{DNSUpstream: conn.LocalAddr().String()}, func() *Router { return nil })
+	req := new(dns.Msg)
+	req.SetQuestion("api.github.com.", dns.TypeA)
+	for i := 0; i < 2; i++ {
+		if resp, err := proxy.exchange(req); err != nil || len(resp.Answer) != 1 {
+			t.Fatalf("exchange failed: %v %v", resp, err)
+		}
+	}
+	if n := queries.Load(); n != 1 {
+		t.Errorf("Expected the second query from the cache, upstream saw %d", n)
+	}
+
+	// The upstream fails after the TTL: the expired answer is served
+	down.Store(true)
+	time.Sleep(1100 * time.Millisecond)
+	resp, err := proxy.exchange(req)
+	if err != nil || len(resp.Answer) != 1 || resp.Answer[0].Header().Ttl != dnsStaleTTL {
+		t.Fatalf("Expected a stale answer, got %v %v", resp, err)
+	}
+	if stats := proxy.CacheStats(); stats.Hits != 1 || stats.Misses != 2 || stats.StaleHits != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
@@ -1,10 +1,14 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,16 +16,27 @@ import (
 	"github.com/miekg/dns"
 )
 
-const resolverDir = "/etc/resolver"
+// resolverDir holds the macOS per domain resolver files; tests replace it
+var resolverDir = "/etc/resolver"
+
+const (
+	defaultDNSProxyPort  = 5454
+	upstreamQueryTimeout = 2 * time.Second
+)
 
 // DNSProxy handles DNS queries and implements dynamic routing
 type DNSProxy struct {
 	config           *Config
 	getRouter        func() *Router
-	server           *dns.Server
+	runMu            sync.Mutex
+	servers          []*dns.Server // A UDP and a TCP server per listen address
 	mu               sync.RWMutex
 	domains          map[string]bool
-	createdResolvers []string
+	geoIP            *GeoIPRules // Answer IPs of other domains matching these are routed too
+	cache            *DNSCache   // nil when dns_cache_size is negative
+	upstreams        []proxyUpstream
+	resolverAddr     *net.UDPAddr    // Set while resolver files point at the proxy
+	createdResolvers map[string]bool // Guarded by runMu, like resolverAddr
 }
 
 // NewDNSProxy creates a new DNS Proxy instance
@@ -31,48 +46,151 @@ func NewDNSProxy(config *Config, getRouter func() *Router) *DNSProxy {
 		domains[strings.ToLower(d)] = true
 	}
 
-	return &DNSProxy{
+	p := &DNSProxy{
 		config:    config,
 		getRouter: getRouter,
 		domains:   domains,
+		geoIP:     config.GeoIP(),
+		cache:     NewDNSCache(config),
+	}
+	p.SetUpstreams(config.DNSUpstreams)
+	return p
+}
+
+// proxyUpstream is a dns_upstreams group; a nil upstream is the default
+// path (dns_upstream or the system DNS)
+type proxyUpstream struct {
+	domains  []string
+	upstream DNSUpstream
+}
+
+// SetUpstreams replaces the per domain group upstreams, e.g. after a
+// config reload
+func (p *DNSProxy) SetUpstreams(groups []ResolverConfig) {
+	var upstreams []proxyUpstream
+	for i, rc := range groups {
+		group := proxyUpstream{domains: rc.Domains}
+		switch {
+		case rc.Bind != "":
+			log.Printf("⚠️  DNS upstream group %d: bind is only supported by resolvers, ignoring the group", i+1)
+			continue
+		case rc.Type == "" || rc.Type == ResolverSystem:
+		default:
+			upstream, err := NewDNSUpstream(rc, "")
+			if err != nil {
+				log.Printf("⚠️  DNS upstream group %d (%s): %v, ignoring the group", i+1, rc.Type, err)
+				continue
+			}
+			group.upstream = upstream
+		}
+		upstreams = append(upstreams, group)
+	}
+
+	p.mu.Lock()
+	old := p.upstreams
+	p.upstreams = upstreams
+	p.mu.Unlock()
+	for _, group := range old {
+		if group.upstream != nil {
+			group.upstream.Close()
+		}
+	}
+}
+
+// upstreamFor returns the upstream of the first group matching domain,
+// nil for the default path
+func (p *DNSProxy) upstreamFor(domain string) DNSUpstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, group := range p.upstreams {
+		if len(group.domains) == 0 {
+			return group.upstream
+		}
+		for _, pattern := range group.domains {
+			if matchDomain(strings.ToLower(pattern), domain) {
+				return group.upstream
+			}
+		}
 	}
+	return nil
 }
 
-// Start begins the DNS proxy server
+// SetDomains replaces the tether domains matched by the proxy, e.g. after
+// a config reload. While the proxy runs, resolver files are added and
+// removed at once so the system sends the new domains to it.
+func (p *DNSProxy) SetDomains(tetherDomains []string) {
+	domains := make(map[string]bool)
+	for _, d := range tetherDomains {
+		domains[strings.ToLower(d)] = true
+	}
+	p.mu.Lock()
+	p.domains = domains
+	p.mu.Unlock()
+
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	if p.resolverAddr != nil {
+		p.syncSystemResolvers()
+	}
+}
+
+// SetGeoIP replaces the ASN/country rules checked per answer IP
+func (p *DNSProxy) SetGeoIP(rules *GeoIPRules) {
+	p.mu.Lock()
+	p.geoIP = rules
+	p.mu.Unlock()
+}
+
+// Start begins the DNS proxy server on UDP and TCP of every listen
+// address. It returns once all listeners serve, or with the bind error.
 func (p *DNSProxy) Start() error {
 	if !p.config.DNSProxyEnabled {
 		return nil
 	}
 
-	port := p.config.DNSProxyPort
-	if port == 0 {
-		port = 5454
-	}
-
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	p.server = &dns.Server{
-		Addr: addr,
-		Net:  "udp",
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	if len(p.servers) > 0 {
+		return nil // Already running
 	}
 
 	mux := dns.NewServeMux()
 	mux.HandleFunc(".", p.handleDNSRequest)
-	p.server.Handler = mux
 
-	log.Printf("📡 DNS Proxy starting on %s...", addr)
-
-	// Start server in background
-	go func() {
-		if err := p.server.ListenAndServe(); err != nil {
-			log.Printf("❌ DNS Proxy failed: %v", err)
+	var servers []*dns.Server
+	for _, listen := range p.listenAddrs() {
+		udp, tcp, err := listenDNS(listen.addr)
+		if err != nil {
+			if listen.optional {
+				log.Printf("⚠️ DNS Proxy: skipping %s: %v", listen.addr, err)
+				continue
+			}
+			closeDNSServers(servers, 0)
+			return fmt.Errorf("dns proxy listen on %s: %w", listen.addr, err)
 		}
-	}()
+		servers = append(servers,
+			&dns.Server{PacketConn: udp, Handler: mux},
+			&dns.Server{Listener: tcp, Handler: mux})
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("dns proxy: no address to listen on")
+	}
 
-	// Give the server a moment to start before setting up resolvers
-	time.Sleep(100 * time.Millisecond)
+	for i, server := range servers {
+		if err := serveDNS(server); err != nil {
+			closeDNSServers(servers, i)
+			return fmt.Errorf("dns proxy: %w", err)
+		}
+	}
+	p.servers = servers
+	for _, server := range servers {
+		if server.PacketConn != nil {
+			log.Printf("📡 DNS Proxy listening on %s (UDP + TCP)", server.PacketConn.LocalAddr())
+		}
+	}
 
 	// Setup system resolvers
-	if err := p.setupSystemResolvers(port); err != nil {
+	if err := p.setupSystemResolvers(servers[0].PacketConn.LocalAddr().(*net.UDPAddr)); err != nil {
 		log.Printf("❌ Failed to setup system resolvers: %v", err)
 		// We continue anyway, but log the error clearly
 	}
@@ -80,22 +198,124 @@ func (p *DNSProxy) Start() error {
 	return nil
 }
 
+type dnsListenAddr struct {
+	addr     string
+	optional bool // Default IPv6 loopback, skipped when IPv6 is unavailable
+}
+
+// listenAddrs returns dns_proxy_listen as host:port; hosts without a port
+// use dns_proxy_port
+func (p *DNSProxy) listenAddrs() []dnsListenAddr {
+	port := p.config.DNSProxyPort
+	if port == 0 {
+		port = defaultDNSProxyPort
+	}
+	defaultPort := strconv.Itoa(port)
+	if len(p.config.DNSProxyListen) == 0 {
+		return []dnsListenAddr{
+			{addr: net.JoinHostPort("127.0.0.1", defaultPort)},
+			{addr: net.JoinHostPort("::1", defaultPort), optional: true},
+		}
+	}
+	addrs := make([]dnsListenAddr, 0, len(p.config.DNSProxyListen))
+	for _, entry := range p.config.DNSProxyListen {
+		entry = strings.TrimSpace(entry)
+		if _, _, err := net.SplitHostPort(entry); err != nil {
+			entry = net.JoinHostPort(strings.Trim(entry, "[]"), defaultPort)
+		}
+		addrs = append(addrs, dnsListenAddr{addr: entry})
+	}
+	return addrs
+}
+
+// listenDNS binds UDP and TCP on addr, TCP on the same port as UDP
+func listenDNS(addr string) (net.PacketConn, net.Listener, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return nil, nil, err
+	}
+	return udp, tcp, nil
+}
+
+// serveDNS starts server on its bound socket and waits until it serves
+func serveDNS(server *dns.Server) error {
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.ActivateAndServe() }()
+	select {
+	case <-started:
+		go func() {
+			if err := <-errCh; err != nil {
+				log.Printf("❌ DNS Proxy server stopped: %v", err)
+			}
+		}()
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+// closeDNSServers shuts down the first started servers and closes the
+// sockets of the others
+func closeDNSServers(servers []*dns.Server, started int) {
+	for i, server := range servers {
+		switch {
+		case i < started:
+			server.Shutdown()
+		case server.PacketConn != nil:
+			server.PacketConn.Close()
+		case server.Listener != nil:
+			server.Listener.Close()
+		}
+	}
+}
+
+// Addrs returns the UDP and TCP addresses served, nil when stopped
+func (p *DNSProxy) Addrs() []net.Addr {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	var addrs []net.Addr
+	for _, server := range p.servers {
+		if server.PacketConn != nil {
+			addrs = append(addrs, server.PacketConn.LocalAddr())
+		} else {
+			addrs = append(addrs, server.Listener.Addr())
+		}
+	}
+	return addrs
+}
+
 // Stop stops the DNS proxy server
 func (p *DNSProxy) Stop() error {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
 	// Clean up resolvers first
 	if err := p.cleanupSystemResolvers(); err != nil {
 		log.Printf("❌ Failed to cleanup system resolvers: %v", err)
 	}
 
-	if p.server != nil {
-		log.Println("🛑 Stopping DNS Proxy...")
-		return p.server.Shutdown()
+	if len(p.servers) == 0 {
+		return nil
 	}
-	return nil
+	log.Println("🛑 Stopping DNS Proxy...")
+	var errs []error
+	for _, server := range p.servers {
+		errs = append(errs, server.Shutdown())
+	}
+	p.servers = nil
+	return errors.Join(errs...)
 }
 
-// setupSystemResolvers creates resolver files for macOS
-func (p *DNSProxy) setupSystemResolvers(port int) error {
+// setupSystemResolvers creates resolver files for macOS pointing at addr.
+// Callers hold runMu.
+func (p *DNSProxy) setupSystemResolvers(addr *net.UDPAddr) error {
 	// Check if we are running as root
 	if os.Geteuid() != 0 {
 		return fmt.Errorf("root privileges required to manage /etc/resolver")
@@ -106,51 +326,79 @@ func (p *DNSProxy) setupSystemResolvers(port int) error {
 		return fmt.Errorf("failed to create resolver directory: %w", err)
 	}
 
-	content := fmt.Sprintf("# Generated by Network Router\nnameserver 127.0.0.1\nport %d\n", port)
+	p.resolverAddr = addr
+	p.syncSystemResolvers()
+
+	// Force Flush DNS Cache (mDNSResponder)
+	// We execute it but don't fail if it doesn't work perfectly
+	/*
+		cmd := exec.Command("killall", "-HUP", "mDNSResponder")
+		if err := cmd.Run(); err != nil {
+			log.Printf("⚠️ Failed to flush DNS cache: %v", err)
+		}
+	*/
+
+	return nil
+}
 
-	p.createdResolvers = make([]string, 0)
+// syncSystemResolvers creates a resolver file for every tether domain
+// without one and removes the files of domains no longer configured.
+// Callers hold runMu.
+func (p *DNSProxy) syncSystemResolvers() {
+	content := fmt.Sprintf("# Generated by Network Router\nnameserver %s\nport %d\n", p.resolverAddr.IP, p.resolverAddr.Port)
 
-	// Create resolver file for each domain
-	// We iterate over the map keys (which include both "example.com" and "*.example.com")
+	// The domain keys include both "example.com" and "*.example.com"
+	wanted := make(map[string]bool)
+	p.mu.RLock()
 	for domain := range p.domains {
 		// Clean domain name for filename (remove leading *.)
 		cleanDomain := strings.TrimPrefix(domain, "*.")
 		cleanDomain = strings.TrimPrefix(cleanDomain, ".")
+		if cleanDomain != "" {
+			wanted[cleanDomain] = true
+		}
+	}
+	p.mu.RUnlock()
 
-		if cleanDomain == "" {
+	if p.createdResolvers == nil {
+		p.createdResolvers = make(map[string]bool)
+	}
+	for domain := range wanted {
+		if p.createdResolvers[domain] {
 			continue
 		}
-
-		filename := filepath.Join(resolverDir, cleanDomain)
+		filename := filepath.Join(resolverDir, domain)
 		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
-			log.Printf("⚠️ Failed to create resolver for %s: %v", cleanDomain, err)
+			log.Printf("⚠️ Failed to create resolver for %s: %v", domain, err)
 			continue
 		}
-
-		log.Printf("✓ Created system resolver for: %s", cleanDomain)
-		p.createdResolvers = append(p.createdResolvers, cleanDomain)
+		log.Printf("✓ Created system resolver for: %s", domain)
+		p.createdResolvers[domain] = true
 	}
-
-	// Force Flush DNS Cache (mDNSResponder)
-	// We execute it but don't fail if it doesn't work perfectly
-	/*
-		cmd := exec.Command("killall", "-HUP", "mDNSResponder")
-		if err := cmd.Run(); err != nil {
-			log.Printf("⚠️ Failed to flush DNS cache: %v", err)
+	for domain := range p.createdResolvers {
+		if wanted[domain] {
+			continue
 		}
-	*/
-
-	return nil
+		filename := filepath.Join(resolverDir, domain)
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to remove resolver for %s: %v", domain, err)
+		} else {
+			log.Printf("✓ Removed system resolver for: %s", domain)
+		}
+		delete(p.createdResolvers, domain)
+	}
 }
 
-// cleanupSystemResolvers removes the created resolver files
+// cleanupSystemResolvers removes the created resolver files. Callers hold
+// runMu.
 func (p *DNSProxy) cleanupSystemResolvers() error {
+	p.resolverAddr = nil
 	if len(p.createdResolvers) == 0 {
 		return nil
 	}
 
 	log.Println("Cleaning up system resolvers...")
-	for _, domain := range p.createdResolvers {
+	for domain := range p.createdResolvers {
 		filename := filepath.Join(resolverDir, domain)
 		if err := os.Remove(filename); err != nil {
 			log.Printf("⚠️ Failed to remove resolver for %s: %v", domain, err)
@@ -162,11 +410,9 @@ func (p *DNSProxy) cleanupSystemResolvers() error {
 }
 
 func (p *DNSProxy) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Compress = false
-
 	if len(r.Question) == 0 {
+		m := new(dns.Msg)
+		m.SetReply(r)
 		w.WriteMsg(m)
 		return
 	}
@@ -177,31 +423,67 @@ func (p *DNSProxy) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 
 	log.Printf("🔍 DNS Proxy Received: [%s] Type: %s", domain, dns.TypeToString[question.Qtype])
 
-	// Check if domain matches wildcard patterns
-	matched := p.matchesTetherDomain(domain)
+	resp, err := p.exchange(r)
+	if err != nil {
+		log.Printf("⚠️ Upstream resolution failed for %s: %v", domain, err)
+		dns.HandleFailed(w, r)
+		return
+	}
 
-	if matched {
-		log.Printf("🎯 Match found for %s! Resolving and adding dynamic route...", domain)
+	// Check if domain matches wildcard patterns; cached answers are
+	// processed too, which keeps the leases of IPs in use alive
+	if p.matchesTetherDomain(domain) {
+		log.Printf("🎯 Match found for %s! Adding dynamic route...", domain)
+		p.processResponse(resp, nil)
+	} else {
+		p.mu.RLock()
+		geoIP := p.geoIP
+		p.mu.RUnlock()
+		if geoIP != nil {
+			p.processResponse(resp, geoIP.Match)
+		}
+	}
 
-		// Use upstream to resolve
-		resp, err := p.resolveUpstream(r)
-		if err == nil {
-			// Extract IPs and add routes
-			p.processResponse(resp)
-			w.WriteMsg(resp)
-			return
+	// UDP clients get what fits their buffer with TC set, and retry over TCP
+	if _, ok := w.LocalAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
 		}
-		log.Printf("⚠️ Upstream resolution failed for %s: %v", domain, err)
+		resp.Truncate(size)
 	}
+	w.WriteMsg(resp)
+}
 
-	// Forward to upstream for everything else or if resolution failed
-	log.Printf("⏩ DNS Forwarding: %s to upstream", domain)
+// exchange answers r from the cache or the upstreams. When no upstream
+// gives an answer, an expired cached one within the serve-stale window is
+// used instead.
+func (p *DNSProxy) exchange(r *dns.Msg) (*dns.Msg, error) {
+	if p.cache == nil {
+		return p.resolveUpstream(r)
+	}
+	if resp := p.cache.Get(r); resp != nil {
+		return resp, nil
+	}
 	resp, err := p.resolveUpstream(r)
-	if err == nil {
-		w.WriteMsg(resp)
-	} else {
-		dns.HandleFailed(w, r)
+	if err == nil && resp.Rcode != dns.RcodeServerFailure {
+		p.cache.Put(r, resp)
+		return resp, nil
+	}
+	if stale := p.cache.GetStale(r); stale != nil {
+		log.Printf("♻️ Upstream unavailable, serving stale answer for %s", r.Question[0].Name)
+		return stale, nil
+	}
+	return resp, err
+}
+
+// CacheStats returns the response cache counters, nil when disabled
+func (p *DNSProxy) CacheStats() *DNSCacheStats {
+	if p == nil || p.cache == nil {
+		return nil
 	}
+	stats := p.cache.Stats()
+	return &stats
 }
 
 func (p *DNSProxy) matchesTetherDomain(domain string) bool {
@@ -215,11 +497,8 @@ func (p *DNSProxy) matchesTetherDomain(domain string) bool {
 
 	// Wildcard match
 	for pattern := range p.domains {
-		if strings.HasPrefix(pattern, "*.") {
-			suffix := strings.TrimPrefix(pattern, "*") // keep the dot: e.g., ".googlevideo.com"
-			if strings.HasSuffix(domain, suffix) || domain == strings.TrimPrefix(suffix, ".") {
-				return true
-			}
+		if strings.HasPrefix(pattern, "*.") && matchDomain(pattern, domain) {
+			return true
 		}
 	}
 
@@ -227,6 +506,12 @@ func (p *DNSProxy) matchesTetherDomain(domain string) bool {
 }
 
 func (p *DNSProxy) resolveUpstream(m *dns.Msg) (*dns.Msg, error) {
+	if upstream := p.upstreamFor(strings.TrimSuffix(strings.ToLower(m.Question[0].Name), ".")); upstream != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), encryptedQueryTimeout)
+		defer cancel()
+		return upstream.Exchange(ctx, m)
+	}
+
 	var upstreams []string
 
 	if p.config.DNSUpstream != "" {
@@ -238,12 +523,9 @@ func (p *DNSProxy) resolveUpstream(m *dns.Msg) (*dns.Msg, error) {
 		}
 	}
 
-	c := new(dns.Client)
-	c.Timeout = 2 * time.Second
-
 	var lastErr error
 	for _, upstream := range upstreams {
-		resp, _, err := c.Exchange(m, upstream)
+		resp, err := (&plainUpstream{addr: upstream}).Exchange(context.Background(), m)
 		if err == nil {
 			return resp, nil
 		}
@@ -267,17 +549,31 @@ func (p *DNSProxy) getSystemDNS() []string {
 	return servers
 }
 
-func (p *DNSProxy) processResponse(resp *dns.Msg) {
+// processResponse routes the A records of resp; with match, only the IPs
+// it accepts
+func (p *DNSProxy) processResponse(resp *dns.Msg, match func(ip string) bool) {
 	router := p.getRouter()
 	if router == nil {
 		log.Println("⚠️ DNS Proxy: Cannot add dynamic route, router is not initialized yet")
 		return
 	}
 
+	domain := ""
+	if len(resp.Question) > 0 {
+		domain = strings.TrimSuffix(strings.ToLower(resp.Question[0].Name), ".")
+	}
+
 	for _, rr := range resp.Answer {
 		if a, ok := rr.(*dns.A); ok {
 			ip := a.A.String()
-			if err := router.AddDynamicRoute(ip); err != nil {
+			if match != nil {
+				if !match(ip) {
+					continue
+				}
+				log.Printf("🌍 %s (%s) matches an ASN/country rule", ip, domain)
+			}
+			ttl := time.Duration(a.Hdr.Ttl) * time.Second
+			if err := router.AddDynamicRoute(ip, domain, ttl); err != nil {
 				log.Printf("❌ Failed to add dynamic route for %s: %v", ip, err)
 			}
 		}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSProxyListeners(t *testing.T) {
+	// Upstream that only gives the full answer set over TCP
+	const records = 60
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		if _, udp := w.LocalAddr().(*net.UDPAddr); udp {
+			resp.Truncated = true
+		} else {
+			for i := 0; i < records; i++ {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+					A:   net.IPv4(10, 0, 0, byte(i+1)),
+				})
+			}
+		}
+		w.WriteMsg(resp)
+	})
+	udp, tcp, err := listenDNS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range []*dns.Server{{PacketConn: udp, Handler: handler}, {Listener: tcp, Handler: handler}} {
+		if err := serveDNS(server); err != nil {
+			t.Fatal(err)
+		}
+		defer server.Shutdown()
+	}
+
+	listen := []string{"127.0.0.1:0"}
+	if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l.Close()
+		listen = append(listen, "::1")
+	}
+	config := &Config{DNSProxyEnabled: true, DNSProxyPort: freePort(t), DNSProxyListen: listen, DNSUpstream: udp.LocalAddr().String()}
+	proxy := NewDNSProxy(config, func() *Router { return nil })
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer proxy.Stop()
+	addrs := proxy.Addrs()
+	if len(addrs) != 2*len(listen) {
+		t.Fatalf("Expected UDP and TCP on %v, got %v", listen, addrs)
+	}
+
+	// A second proxy on the same addresses fails at once
+	busy := NewDNSProxy(&Config{DNSProxyEnabled: true, DNSProxyListen: []string{addrs[0].String()}}, func() *Router { return nil })
+	if err := busy.Start(); err == nil {
+		busy.Stop()
+		t.Error("Expected Start to report the bind failure")
+	}
+
+	query := func(network string, addr net.Addr, edns bool) *dns.Msg {
+		t.Helper()
+		req := new(dns.Msg)
+		req.SetQuestion("big.example.", dns.TypeA)
+		if edns {
+			req.SetEdns0(4096, false)
+		}
+		resp, _, err := (&dns.Client{Net: network}).Exchange(req, addr.String())
+		if err != nil {
+			t.Fatalf("%s query to %s failed: %v", network, addr, err)
+		}
+		return resp
+	}
+	for _, addr := range addrs {
+		network := "tcp"
+		if _, ok := addr.(*net.UDPAddr); ok {
+			network = "udp"
+			// Without EDNS only 512 bytes fit: the client is told to retry over TCP
+			if resp := query(network, addr, false); !resp.Truncated || len(resp.Answer) >= records {
+				t.Errorf("Expected a truncated answer over UDP on %s, got TC=%v with %d records", addr, resp.Truncated, len(resp.Answer))
+			}
+		}
+		if resp := query(network, addr, true); resp.Truncated || len(resp.Answer) != records {
+			t.Errorf("Expected all %d records over %s on %s, got TC=%v with %d", records, network, addr, resp.Truncated, len(resp.Answer))
+		}
+	}
+
+	if err := proxy.Stop(); err != nil || proxy.Addrs() != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+}
+
+// freePort returns a port free on the loopback for UDP and TCP
+func freePort(t *testing.T) int {
+	t.Helper()
+	udp, tcp, err := listenDNS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	defer tcp.Close()
+	return udp.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestDNSProxySetDomainsSyncsResolvers(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved string) { resolverDir = saved }(resolverDir)
+	resolverDir = dir
+
+	proxy := NewDNSProxy(&Config{TetherDomains: []string{"github.com", "*.githubcopilot.com"}}, func() *Router { return nil })
+	proxy.resolverAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5454}
+	proxy.syncSystemResolvers()
+
+	// A reload adds the new domain and removes the dropped one at once
+	proxy.SetDomains([]string{"github.com", "openai.com"})
+	for name, want := range map[string]bool{"github.com": true, "openai.com": true, "githubcopilot.com": false} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("Resolver file for %s exists = %v, want %v", name, got, want)
+		}
+	}
+
+	proxy.Stop()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected Stop to remove every resolver file, got %v", entries)
+	}
+}
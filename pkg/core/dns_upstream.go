@@ -0,0 +1,348 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"network-router/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultDoTPort        = "853"
+	encryptedQueryTimeout = 5 * time.Second
+	maxIdleUpstreamConns  = 4
+	dohMediaType          = "application/dns-message"
+)
+
+// DNSUpstream exchanges DNS messages with one upstream server
+type DNSUpstream interface {
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+	// Close drops the idle connections
+	Close()
+	String() string
+}
+
+// upstreamOptions are the connection settings of DoH and DoT upstreams
+type upstreamOptions struct {
+	bootstrap []string       // IPs dialed instead of resolving the server name
+	pins      [][]byte       // Accepted SHA-256 digests of a certificate public key, empty = any
+	device    string         // Send through this interface (see ResolverConfig.Bind)
+	rootCAs   *x509.CertPool // nil = system roots
+}
+
+// NewDNSUpstream creates the upstream of a "server", "doh" or "dot"
+// resolver config, sending through device when not empty
+func NewDNSUpstream(rc ResolverConfig, device string) (DNSUpstream, error) {
+	opts := upstreamOptions{device: device}
+	for _, ip := range rc.Bootstrap {
+		if _, err := netip.ParseAddr(ip); err != nil {
+			return nil, fmt.Errorf("bootstrap %q is not an IP address", ip)
+		}
+		opts.bootstrap = append(opts.bootstrap, ip)
+	}
+	for _, pin := range rc.PinSHA256 {
+		digest, err := base64.StdEncoding.DecodeString(strings.TrimLeft(strings.TrimPrefix(pin, "sha256"), "/"))
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is not a base64 SHA-256 digest", pin)
+		}
+		opts.pins = append(opts.pins, digest)
+	}
+
+	switch rc.Type {
+	case ResolverServer:
+		if rc.Server == "" {
+			return nil, fmt.Errorf("server is required")
+		}
+		return &plainUpstream{addr: withDNSPort(rc.Server), device: device}, nil
+	case ResolverDoH:
+		return newDoHUpstream(rc.URL, opts)
+	case ResolverDoT:
+		return newDoTUpstream(rc.Server, opts)
+	default:
+		return nil, fmt.Errorf("unknown upstream type %q", rc.Type)
+	}
+}
+
+// dialContext dials addr, or its port on the bootstrap IPs in turn
+func (o *upstreamOptions) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: encryptedQueryTimeout, Control: utils.InterfaceDialControl(o.device)}
+	if len(o.bootstrap) == 0 {
+		return d.DialContext(ctx, network, addr)
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range o.bootstrap {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// tlsConfig verifies the certificate for serverName, then the pins
+func (o *upstreamOptions) tlsConfig(serverName string) *tls.Config {
+	config := &tls.Config{ServerName: serverName, RootCAs: o.rootCAs, MinVersion: tls.VersionTLS12}
+	if len(o.pins) > 0 {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range o.pins {
+					if bytes.Equal(digest[:], pin) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("certificate of %s matches no pinned key", serverName)
+		}
+	}
+	return config
+}
+
+// plainUpstream is a classic DNS server over UDP, retried over TCP when
+// the answer is truncated
+type plainUpstream struct {
+	addr   string // host:port
+	device string
+}
+
+func (u *plainUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	dialer := &net.Dialer{Timeout: upstreamQueryTimeout, Control: utils.InterfaceDialControl(u.device)}
+	c := &dns.Client{Timeout: upstreamQueryTimeout, Dialer: dialer}
+	resp, _, err := c.ExchangeContext(ctx, m, u.addr)
+	if err == nil && resp.Truncated {
+		// Ask again over TCP for the full answer
+		tcp := &dns.Client{Net: "tcp", Timeout: upstreamQueryTimeout, Dialer: dialer}
+		if full, _, tcpErr := tcp.ExchangeContext(ctx, m, u.addr); tcpErr == nil {
+			resp = full
+		} else {
+			log.Printf("⚠️ TCP retry of truncated answer from %s failed: %v", u.addr, tcpErr)
+		}
+	}
+	return resp, err
+}
+
+func (u *plainUpstream) Close() {}
+
+func (u *plainUpstream) String() string { return u.addr }
+
+// dohUpstream sends RFC 8484 POST requests; the HTTP transport keeps the
+// connection open between queries
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHUpstream(rawURL string, opts upstreamOptions) (*dohUpstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("url %q must be https://host/path", rawURL)
+	}
+	transport := &http.Transport{
+		DialContext:         opts.dialContext,
+		TLSClientConfig:     opts.tlsConfig(u.Hostname()),
+		TLSHandshakeTimeout: encryptedQueryTimeout,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: maxIdleUpstreamConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &dohUpstream{url: rawURL, client: &http.Client{Transport: transport, Timeout: encryptedQueryTimeout}}, nil
+}
+
+func (u *dohUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// ID 0 makes identical queries cacheable by HTTP caches (RFC 8484 4.1)
+	query := m.Copy()
+	query.Id = 0
+	wire, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %s", u.url, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, dohMediaType) {
+		return nil, fmt.Errorf("%s: unexpected content type %q", u.url, ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("%s: %w", u.url, err)
+	}
+	reply.Id = m.Id
+	return reply, nil
+}
+
+func (u *dohUpstream) Close() {
+	u.client.CloseIdleConnections()
+}
+
+func (u *dohUpstream) String() string { return u.url }
+
+// dotUpstream sends queries over RFC 7858 TLS connections, keeping up to
+// maxIdleUpstreamConns of them open for reuse
+type dotUpstream struct {
+	addr string // host:port
+	opts upstreamOptions
+	tls  *tls.Config
+
+	mu   sync.Mutex
+	idle []*dns.Conn
+}
+
+func newDoTUpstream(server string, opts upstreamOptions) (*dotUpstream, error) {
+	if server == "" {
+		return nil, fmt.Errorf("server is required")
+	}
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, defaultDoTPort)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return &dotUpstream{addr: addr, opts: opts, tls: opts.tlsConfig(host)}, nil
+}
+
+func (u *dotUpstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	for {
+		conn, reused, err := u.conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := exchangeOn(ctx, conn, m)
+		if err == nil {
+			u.release(conn)
+			return resp, nil
+		}
+		conn.Close()
+		// The server may have closed an idle connection: try the next one
+		if !reused {
+			return nil, fmt.Errorf("%s: %w", u.addr, err)
+		}
+	}
+}
+
+// conn returns an idle connection, or dials a new one
+func (u *dotUpstream) conn(ctx context.Context) (*dns.Conn, bool, error) {
+	u.mu.Lock()
+	if n := len(u.idle); n > 0 {
+		conn := u.idle[n-1]
+		u.idle = u.idle[:n-1]
+		u.mu.Unlock()
+		return conn, true, nil
+	}
+	u.mu.Unlock()
+
+	raw, err := u.opts.dialContext(ctx, "tcp", u.addr)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", u.addr, err)
+	}
+	tlsConn := tls.Client(raw, u.tls)
+	handshakeCtx, cancel := context.WithTimeout(ctx, encryptedQueryTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		raw.Close()
+		return nil, false, fmt.Errorf("%s: %w", u.addr, err)
+	}
+	return &dns.Conn{Conn: tlsConn}, false, nil
+}
+
+func (u *dotUpstream) release(conn *dns.Conn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.idle) >= maxIdleUpstreamConns {
+		conn.Close()
+		return
+	}
+	u.idle = append(u.idle, conn)
+}
+
+func (u *dotUpstream) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, conn := range u.idle {
+		conn.Close()
+	}
+	u.idle = nil
+}
+
+func (u *dotUpstream) String() string { return "tls://" + u.addr }
+
+func exchangeOn(ctx context.Context, conn *dns.Conn, m *dns.Msg) (*dns.Msg, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(encryptedQueryTimeout)
+	}
+	conn.SetDeadline(deadline)
+	if err := conn.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	resp, err := conn.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Id != m.Id {
+		return nil, fmt.Errorf("reply ID %d does not match query %d", resp.Id, m.Id)
+	}
+	return resp, nil
+}
+
+// UpstreamResolver resolves static domains through a DNSUpstream, e.g.
+// DoH or DoT resolver groups
+type UpstreamResolver struct {
+	Upstream DNSUpstream
+}
+
+func (r *UpstreamResolver) Resolve(ctx context.Context, domain string) ([]string, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	resp, err := r.Upstream.Exchange(ctx, m)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DNS query to %s failed: %v", r.Upstream, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, 0, fmt.Errorf("DNS resolution failed: %s", dns.RcodeToString[resp.Rcode])
+	}
+	var ips []string
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			ips = append(ips, a.A.String())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("no IPv4 addresses found")
+	}
+	return ips, time.Duration(minTTL(resp.Answer)) * time.Second, nil
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// countingListener counts accepted connections
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestEncryptedUpstreams(t *testing.T) {
+	reply := func(r *dns.Msg, ip string) *dns.Msg {
+		return answer(r, ip, 120)
+	}
+
+	// DoH stand-in: RFC 8484 POST, reachable as example.com only via bootstrap
+	var dohConns atomic.Int32
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMediaType || req.Unpack(body) != nil || req.Id != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		wire, _ := reply(req, "10.0.0.1").Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(wire)
+	}))
+	doh.EnableHTTP2 = true
+	doh.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dohConns.Add(1)
+		}
+	}
+	doh.StartTLS()
+	defer doh.Close()
+
+	// DoT stand-in with the same certificate
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: doh.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dotListener := &countingListener{Listener: tlsListener}
+	dot := &dns.Server{Listener: dotListener, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(reply(r, "10.0.0.2"))
+	})}
+	if err := serveDNS(dot); err != nil {
+		t.Fatal(err)
+	}
+	defer dot.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(doh.Certificate())
+	spki := sha256.Sum256(doh.Certificate().RawSubjectPublicKeyInfo)
+	opts := upstreamOptions{bootstrap: []string{"127.0.0.1"}, pins: [][]byte{spki[:]}, rootCAs: roots}
+	dohURL := strings.Replace(doh.URL, "127.0.0.1", "example.com", 1) + "/dns-query"
+	dohUpstream, err := newDoHUpstream(dohURL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dohUpstream.Close()
+	_, dotPort, _ := net.SplitHostPort(tlsListener.Addr().String())
+	dotUpstream, err := newDoTUpstream("example.com:"+dotPort, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dotUpstream.Close()
+
+	// Queries keep their ID and share one connection per upstream
+	for _, tc := range []struct {
+		upstream DNSUpstream
+		ip       string
+		conns    func() int32
+	}{
+		{dohUpstream, "10.0.0.1", dohConns.Load},
+		{dotUpstream, "10.0.0.2", dotListener.accepted.Load},
+	} {
+		for i := 0; i < 3; i++ {
+			req := new(dns.Msg)
+			req.SetQuestion("api.github.com.", dns.TypeA)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			resp, err := tc.upstream.Exchange(ctx, req)
+			cancel()
+			if err != nil {
+				t.Fatalf("%s: %v", tc.upstream, err)
+			}
+			if resp.Id != req.Id || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != tc.ip {
+				t.Errorf("%s: unexpected answer %v", tc.upstream, resp)
+			}
+		}
+		if n := tc.conns(); n != 1 {
+			t.Errorf("%s: expected one reused connection, got %d", tc.upstream, n)
+		}
+	}
+
+	// A DoT connection closed by the server is replaced transparently
+	dotUpstream.mu.Lock()
+	dotUpstream.idle[0].Conn.(*tls.Conn).NetConn().Close()
+	dotUpstream.mu.Unlock()
+	req := new(dns.Msg)
+	req.SetQuestion("api.github.com.", dns.TypeA)
+	if _, err := dotUpstream.Exchange(context.Background(), req); err != nil || dotListener.accepted.Load() != 2 {
+		t.Errorf("Expected a new connection after the old one broke, got %d: %v", dotListener.accepted.Load(), err)
+	}
+
+	// A certificate not matching the pin is refused
+	wrongPin := sha256.Sum256([]byte("other key"))
+	for _, build := range []func(upstreamOptions) (DNSUpstream, error){
+		func(o upstreamOptions) (DNSUpstream, error) { return newDoHUpstream(dohURL, o) },
+		func(o upstreamOptions) (DNSUpstream, error) { return newDoTUpstream("example.com:"+dotPort, o) },
+	} {
+		upstream, _ := build(upstreamOptions{bootstrap: []string{"127.0.0.1"}, pins: [][]byte{wrongPin[:]}, rootCAs: roots})
+		if _, err := upstream.Exchange(context.Background(), req); err == nil || !strings.Contains(err.Error(), "pinned") {
+			t.Errorf("%s: expected a pin mismatch, got %v", upstream, err)
+		}
+	}
+
+	// Config validation
+	for _, rc := range []ResolverConfig{
+		{Type: ResolverDoH, URL: "http://dns.example/dns-query"},
+		{Type: ResolverDoT},
+		{Type: ResolverDoT, Server: "dns.example", Bootstrap: []string{"dns.example"}},
+		{Type: ResolverDoH, URL: "https://dns.example/dns-query", PinSHA256: []string{"not-base64"}},
+	} {
+		if _, err := NewDNSUpstream(rc, ""); err == nil {
+			t.Errorf("Expected %+v to be rejected", rc)
+		}
+	}
+	pinned := ResolverConfig{Type: ResolverDoT, Server: "dns.example", PinSHA256: []string{"sha256//" + base64.StdEncoding.EncodeToString(spki[:])}}
+	if up, err := NewDNSUpstream(pinned, ""); err != nil || up.String() != "tls://dns.example:853" {
+		t.Errorf("Unexpected upstream %v: %v", up, err)
+	}
+
+	// Static resolution through an encrypted resolver group
+	ips, ttl, err := (&UpstreamResolver{Upstream: dohUpstream}).Resolve(context.Background(), "api.github.com")
+	if err != nil || !reflect.DeepEqual(ips, []string{"10.0.0.1"}) || ttl != 2*time.Minute {
+		t.Errorf("Resolve returned %v %v %v", ips, ttl, err)
+	}
+
+	// The DNS proxy picks the upstream of the first matching group
+	var plainQueries atomic.Int32
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		plainQueries.Add(1)
+		w.WriteMsg(reply(r, "10.9.9.9"))
+	})}
+	if err := serveDNS(plain); err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Shutdown()
+	proxy := NewDNSProxy(&Config{
+		DNSUpstream:  conn.LocalAddr().String(),
+		DNSCacheSize: -1,
+		DNSUpstreams: []ResolverConfig{{Type: ResolverSystem, Domains: []string{"*.corp.example"}}},
+	}, func() *Router { return nil })
+	proxy.upstreams = append(proxy.upstreams,
+		proxyUpstream{domains: []string{"*.github.com"}, upstream: dotUpstream},
+		proxyUpstream{upstream: dohUpstream})
+	for domain, want := range map[string]string{"api.github.com": "10.0.0.2", "www.google.com": "10.0.0.1", "git.corp.example": "10.9.9.9"} {
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+		resp, err := proxy.exchange(req)
+		if err != nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != want {
+			t.Errorf("%s: expected %s, got %v %v", domain, want, resp, err)
+		}
+	}
+	if n := plainQueries.Load(); n != 1 {
+		t.Errorf("Expected only the system group to use plain DNS, got %d queries", n)
+	}
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+const (
+	defaultNFTSetTable = "network_router"
+	defaultNFTSetName  = "tether4"
+)
+
+// DynamicSet receives IPs learned from DNS answers in place of per-IP
+// routes; entries expire on their own after ttl
+type DynamicSet interface {
+	Add(ip string, ttl time.Duration) error
+}
+
+// NewNFTSet creates the nftables set configured by nftset_enabled. Marked
+// packets are routed by the policy backend's fwmark rule, so it requires
+// route_backend policy with policy_fwmark set.
+func NewNFTSet(config *Config) (*utils.NFTSet, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("nftset_enabled is only supported on Linux")
+	}
+	if config.RouteBackend != "" && config.RouteBackend != BackendPolicy {
+		return nil, fmt.Errorf("nftset_enabled requires route_backend %q", BackendPolicy)
+	}
+	if config.PolicyFwMark == 0 {
+		return nil, fmt.Errorf("nftset_enabled requires policy_fwmark")
+	}
+	table := config.NFTSetTable
+	if table == "" {
+		table = defaultNFTSetTable
+	}
+	name := config.NFTSetName
+	if name == "" {
+		name = defaultNFTSetName
+	}
+	return utils.NewNFTSet(table, name, config.PolicyFwMark), nil
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats accepted by export
+const (
+	ExportPAC     = "pac"
+	ExportShell   = "shell"   // route / ip route commands
+	ExportDnsmasq = "dnsmasq" // server= lines forwarding the tether domains, optionally nftset=
+	ExportClash   = "clash"   // Classical rule-set payload
+	ExportJSON    = "json"
+)
+
+// ExportFormats lists the supported formats
+var ExportFormats = []string{ExportPAC, ExportShell, ExportDnsmasq, ExportClash, ExportJSON}
+
+// RoutingPlan is what export renders: the compiled tether rules and the
+// destinations routed via the phone, as ApplyRoutes installs them
+type RoutingPlan struct {
+	Rules   *RuleSet
+	Exclude []string
+	Routes  []RouteEntry
+	Gateway string   // Phone gateway, when known
+	Presets []string // Built-in presets merged into the rules, "name@version"
+}
+
+// Plan returns the routing plan of the router. Routes are the plan of the
+// last ResolveDomains if any, else the configured CIDRs alone; use
+// SetInstalled to export what the daemon installed instead.
+func (r *Router) Plan() *RoutingPlan {
+	plan := &RoutingPlan{
+		Rules:   NewRuleSet(r.config),
+		Exclude: r.config.TetherCIDRsExclude,
+		Gateway: r.phoneGateway,
+		Presets: r.config.AppliedPresets(),
+	}
+	for _, target := range r.planRoutes() {
+		plan.Routes = append(plan.Routes, r.planEntry(target))
+	}
+	return plan
+}
+
+// SetInstalled replaces the planned routes with the routes installed by
+// the daemon, as reported over IPC; the state file the daemon keeps in its
+// own temp directory is not readable from the CLI on every OS.
+func (p *RoutingPlan) SetInstalled(gateway string, routes []RouteEntry) {
+	p.Routes = routes
+	if gateway != "" {
+		p.Gateway = gateway
+	}
+}
+
+// ExportOptions tune formats that need more than the plan
+type ExportOptions struct {
+	PACProxy    string // PAC result for matching hosts
+	DNSServer   string // dnsmasq server= target, "addr#port" (default the DNS proxy)
+	NFTSet      bool   // Also write dnsmasq nftset= lines
+	NFTSetTable string
+	NFTSetName  string
+}
+
+// DnsmasqServer returns the server dnsmasq forwards the tether domains to:
+// the DNS proxy, which routes the answers via the phone
+func DnsmasqServer(config *Config) string {
+	port := config.DNSProxyPort
+	if port == 0 {
+		port = defaultDNSProxyPort
+	}
+	return fmt.Sprintf("127.0.0.1#%d", port)
+}
+
+// Export renders plan in one of ExportFormats
+func Export(plan *RoutingPlan, format string, opts ExportOptions) ([]byte, error) {
+	switch format {
+	case ExportPAC:
+		return []byte(GeneratePAC(plan.Rules, opts.PACProxy)), nil
+	case ExportShell:
+		return []byte(renderShell(plan)), nil
+	case ExportDnsmasq:
+		return []byte(renderDnsmasq(plan, opts)), nil
+	case ExportClash:
+		return renderClash(plan)
+	case ExportJSON:
+		return renderJSON(plan)
+	default:
+		return nil, fmt.Errorf("unknown export format %q (supported: %s)", format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// renderShell writes a script adding (or deleting, with "delete") every
+// route via $PHONE_GW, with route on macOS and ip route elsewhere
+func renderShell(plan *RoutingPlan) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Generated by network-router: %d route(s) via the phone\n", len(plan.Routes))
+	b.WriteString("# Usage: PHONE_GW=<phone gateway> sh routes.sh [add|delete]\n")
+	for _, preset := range plan.Presets {
+		fmt.Fprintf(&b, "# preset: %s\n", preset)
+	}
+	for _, d := range plan.Rules.Domains {
+		fmt.Fprintf(&b, "# domain: %s\n", d)
+	}
+	if plan.Gateway != "" {
+		fmt.Fprintf(&b, "PHONE_GW=\"${PHONE_GW:-%s}\"\n", plan.Gateway)
+	} else {
+		b.WriteString(": \"${PHONE_GW:?set PHONE_GW to the phone gateway}\"\n")
+	}
+	b.WriteString(`action="${1:-add}"
+
+route_one() {
+  case "$(uname)" in
+    Darwin) sudo route -n "$action" -net "$1" "$PHONE_GW" ;;
+    *)
+      if [ "$action" = add ]; then
+        sudo ip route replace "$1" via "$PHONE_GW"
+      else
+        sudo ip route del "$1"
+      fi ;;
+  esac
+}
+
+`)
+	for _, route := range plan.Routes {
+		fmt.Fprintf(&b, "route_one %s%s\n", route.Destination, routeComment(route))
+	}
+	return b.String()
+}
+
+func routeComment(route RouteEntry) string {
+	if route.Domain != "" {
+		return fmt.Sprintf(" # %s (%s)", route.Source, route.Domain)
+	}
+	return fmt.Sprintf(" # %s", route.Source)
+}
+
+// renderDnsmasq writes server= lines forwarding the tether domains to
+// opts.DNSServer and, with opts.NFTSet, nftset= lines so dnsmasq adds the
+// answers to the set of nftset_enabled (Linux only). dnsmasq matches every
+// domain with its subdomains, so exact domains get wider here.
+func renderDnsmasq(plan *RoutingPlan, opts ExportOptions) string {
+	server := opts.DNSServer
+	if server == "" {
+		server = fmt.Sprintf("127.0.0.1#%d", defaultDNSProxyPort)
+	}
+	table, set := opts.NFTSetTable, opts.NFTSetName
+	if table == "" {
+		table = defaultNFTSetTable
+	}
+	if set == "" {
+		set = defaultNFTSetName
+	}
+	var b strings.Builder
+	b.WriteString("# Generated by network-router from tether_domains\n")
+	seen := make(map[string]bool)
+	for _, d := range plan.Rules.Domains {
+		base := strings.TrimPrefix(d, "*.")
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		fmt.Fprintf(&b, "server=/%s/%s\n", base, server)
+		if opts.NFTSet {
+			fmt.Fprintf(&b, "nftset=/%s/4#inet#%s#%s\n", base, table, set)
+		}
+	}
+	if len(plan.Routes) > 0 {
+		b.WriteString("# Static routes dnsmasq cannot express:\n")
+		for _, route := range plan.Routes {
+			fmt.Fprintf(&b, "#   %s\n", route.Destination)
+		}
+	}
+	return b.String()
+}
+
+// renderClash writes a classical rule-set (rule-providers, behavior
+// classical); network-router import --format clash reads it back
+func renderClash(plan *RoutingPlan) ([]byte, error) {
+	var payload []string
+	for _, d := range plan.Rules.Domains {
+		if base, ok := strings.CutPrefix(d, "*."); ok {
+			payload = append(payload, "DOMAIN-SUFFIX,"+base)
+		} else {
+			payload = append(payload, "DOMAIN,"+d)
+		}
+	}
+	for _, route := range plan.Routes {
+		payload = append(payload, "IP-CIDR,"+route.Destination+",no-resolve")
+	}
+	out, err := yaml.Marshal(map[string][]string{"payload": payload})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("# Generated by network-router\n"), out...), nil
+}
+
+func renderJSON(plan *RoutingPlan) ([]byte, error) {
+	routes := append([]RouteEntry(nil), plan.Routes...)
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Destination < routes[j].Destination })
+	doc := struct {
+		GeneratedAt time.Time    `json:"generated_at"`
+		Presets     []string     `json:"presets,omitempty"`
+		Domains     []string     `json:"domains"`
+		CIDRs       []string     `json:"cidrs"`
+		Exclude     []string     `json:"exclude,omitempty"`
+		Gateway     string       `json:"gateway,omitempty"`
+		Routes      []RouteEntry `json:"routes"`
+	}{time.Now(), plan.Presets, plan.Rules.Domains, plan.Rules.CIDRs.Strings(), plan.Exclude, plan.Gateway, routes}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
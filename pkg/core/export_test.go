@@ -0,0 +1,76 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportFormats(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	config := &Config{
+		TetherDomains: []string{"github.com", "*.githubcopilot.com"},
+		TetherCIDRs:   []string{"10.0.0.0/8"},
+	}
+	router, err := NewRouter(config, NewMockRouteManager())
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	router.resolvedIPs = []string{"140.82.112.3"}
+	router.leases.observe("140.82.112.3", "github.com", time.Minute, time.Now())
+	plan := router.Plan()
+
+	export := func(format string) string {
+		out, err := Export(plan, format, ExportOptions{PACProxy: "PROXY 127.0.0.1:1080"})
+		if err != nil {
+			t.Fatalf("Export(%s) failed: %v", format, err)
+		}
+		return string(out)
+	}
+
+	shell := export(ExportShell)
+	for _, want := range []string{"route_one 10.0.0.0/8 # cidr\n", "route_one 140.82.112.3/32 # static (github.com)\n", ": \"${PHONE_GW:?"} {
+		if !strings.Contains(shell, want) {
+			t.Errorf("Shell script is missing %q:\n%s", want, shell)
+		}
+	}
+	if strings.Contains(shell, "set -e") {
+		t.Errorf("Shell script must not stop at the first route already gone:\n%s", shell)
+	}
+	if dnsmasq := export(ExportDnsmasq); !strings.Contains(dnsmasq, "server=/githubcopilot.com/127.0.0.1#5454\n") || strings.Contains(dnsmasq, "nftset=") {
+		t.Errorf("Unexpected dnsmasq config:\n%s", dnsmasq)
+	}
+	out, err := Export(plan, ExportDnsmasq, ExportOptions{DNSServer: "172.20.10.1", NFTSet: true})
+	if err != nil || !strings.Contains(string(out), "server=/github.com/172.20.10.1\nnftset=/github.com/4#inet#network_router#tether4\n") {
+		t.Errorf("Unexpected dnsmasq config with nftset (%v):\n%s", err, out)
+	}
+
+	// The Clash rule set reads back into the same rules
+	res, err := ParseRules(strings.NewReader(export(ExportClash)), FormatClash)
+	if err != nil {
+		t.Fatalf("ParseRules failed: %v", err)
+	}
+	if !reflect.DeepEqual(res.Domains, config.TetherDomains) || !reflect.DeepEqual(res.CIDRs, []string{"10.0.0.0/8", "140.82.112.3/32"}) || len(res.Skipped) > 0 {
+		t.Errorf("Clash export did not round-trip: %+v", res)
+	}
+
+	var doc struct {
+		Routes []RouteEntry `json:"routes"`
+	}
+	if err := json.Unmarshal([]byte(export(ExportJSON)), &doc); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(doc.Routes) != 2 || doc.Routes[0].Destination != "10.0.0.0/8" || doc.Routes[1].Domain != "github.com" {
+		t.Errorf("Unexpected JSON routes: %+v", doc.Routes)
+	}
+
+	// The routes installed by the daemon replace the plan
+	plan = router.Plan()
+	plan.SetInstalled("172.20.10.1", []RouteEntry{{Destination: "1.1.1.1/32", Source: SourceDynamic, Domain: "api.github.com"}})
+	if len(plan.Routes) != 1 || plan.Routes[0].Destination != "1.1.1.1/32" || plan.Gateway != "172.20.10.1" {
+		t.Errorf("Expected the installed routes, got %+v via %s", plan.Routes, plan.Gateway)
+	}
+}
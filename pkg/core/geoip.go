@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoIPRecord is what a database knows about an address
+type GeoIPRecord struct {
+	ASN     uint32
+	Country string // ISO 3166-1 alpha-2, uppercase
+}
+
+// geoIPDatabase is an ASN and/or country database
+type geoIPDatabase interface {
+	Lookup(ip netip.Addr) (GeoIPRecord, bool)
+	// Prefixes returns the IPv4 networks whose record matches
+	Prefixes(match func(GeoIPRecord) bool) (*CIDRSet, error)
+}
+
+// GeoIPRules matches destinations by origin ASN (tether_asns) or country
+// (tether_countries) using the local geoip_databases. Each database
+// answers what it knows: ASN databases the ASN, country databases the
+// country, combined ones (e.g. ip2asn, IPinfo) both.
+type GeoIPRules struct {
+	ASNs      map[uint32]bool
+	Countries map[string]bool
+	databases []geoIPDatabase
+}
+
+// LoadGeoIPRules opens the databases of config; relative paths are
+// resolved against dir. It returns nil when no ASN or country rule is set.
+func LoadGeoIPRules(config *Config, dir string) (*GeoIPRules, error) {
+	if len(config.TetherASNs) == 0 && len(config.TetherCountries) == 0 {
+		return nil, nil
+	}
+	if len(config.GeoIPDatabases) == 0 {
+		return nil, fmt.Errorf("tether_asns/tether_countries need geoip_databases")
+	}
+	rules := &GeoIPRules{ASNs: make(map[uint32]bool), Countries: make(map[string]bool)}
+	for _, entry := range config.TetherASNs {
+		asn, err := parseASN(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules.ASNs[asn] = true
+	}
+	for _, country := range config.TetherCountries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 {
+			return nil, fmt.Errorf("invalid country code %q (use ISO 3166 alpha-2, e.g. US)", country)
+		}
+		rules.Countries[country] = true
+	}
+	for _, path := range config.GeoIPDatabases {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		db, err := openGeoIPDatabase(path)
+		if err != nil {
+			return nil, err
+		}
+		rules.databases = append(rules.databases, db)
+	}
+	return rules, nil
+}
+
+// parseASN accepts "AS15169", "as15169" and "15169"
+func parseASN(entry string) (uint32, error) {
+	entry = strings.TrimSpace(entry)
+	digits := strings.TrimPrefix(strings.TrimPrefix(entry, "AS"), "as")
+	asn, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil || asn == 0 {
+		return 0, fmt.Errorf("invalid ASN %q", entry)
+	}
+	return uint32(asn), nil
+}
+
+// openGeoIPDatabase opens a .mmdb file, anything else is read as CSV/TSV
+func openGeoIPDatabase(path string) (geoIPDatabase, error) {
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		return openMMDB(path)
+	}
+	return loadGeoIPCSV(path)
+}
+
+// Match reports whether a database puts ip in one of the ASNs or countries
+func (g *GeoIPRules) Match(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Unmap().Is4() {
+		return false
+	}
+	for _, db := range g.databases {
+		if rec, ok := db.Lookup(addr.Unmap()); ok && g.matchRecord(rec) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *GeoIPRules) matchRecord(rec GeoIPRecord) bool {
+	return g.ASNs[rec.ASN] || g.Countries[rec.Country]
+}
+
+// Prefixes expands the rules to the IPv4 networks of all databases
+func (g *GeoIPRules) Prefixes() (*CIDRSet, error) {
+	set := NewCIDRSet()
+	for _, db := range g.databases {
+		prefixes, err := db.Prefixes(g.matchRecord)
+		if err != nil {
+			return nil, err
+		}
+		set.Union(prefixes)
+	}
+	return set, nil
+}
+
+// mergeGeoIP loads the ASN/country rules and appends their networks to
+// tether_cidrs, so static routes, the proxy and exports cover them. The DNS
+// proxy also checks answer IPs against them (see GeoIP).
+func (c *Config) mergeGeoIP(dir string) error {
+	rules, err := LoadGeoIPRules(c, dir)
+	if err != nil || rules == nil {
+		return err
+	}
+	set, err := rules.Prefixes()
+	if err != nil {
+		return err
+	}
+	prefixes := set.Strings()
+	c.TetherCIDRs = appendMissing(c.TetherCIDRs, prefixes)
+	c.geoIP = rules
+	for _, path := range c.GeoIPDatabases {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		c.sourceFiles = append(c.sourceFiles, path)
+	}
+	log.Printf("🌍 %d ASN(s) and %d countr(y/ies) expanded to %d IPv4 prefix(es)", len(rules.ASNs), len(rules.Countries), len(prefixes))
+	return nil
+}
+
+// GeoIP returns the ASN/country rules loaded by LoadConfig, nil if none
+func (c *Config) GeoIP() *GeoIPRules {
+	return c.geoIP
+}
+
+// geoIPRange is a CSV row
+type geoIPRange struct {
+	ipRange
+	GeoIPRecord
+}
+
+// geoIPCSV is a database read from CSV or TSV rows of either
+// "start,end,values..." (ip2asn, DB-IP) or "network,values..." (GeoLite2
+// ASN blocks). With a header, columns named like asn or country are used;
+// without one, a number or "AS123" is the ASN and two letters the country.
+// IPv6 rows are skipped.
+type geoIPCSV struct {
+	ranges []geoIPRange // Sorted by start
+}
+
+func loadGeoIPCSV(path string) (*geoIPCSV, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db := &geoIPCSV{}
+	asnCol, countryCol := -1, -1
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for first := true; scanner.Scan(); {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		header := first
+		first = false
+		sep := ","
+		if strings.Contains(line, "\t") {
+			sep = "\t"
+		}
+		fields := strings.Split(line, sep)
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+
+		var r ipRange
+		var values []string
+		cols := 1
+		if prefix, err := netip.ParsePrefix(fields[0]); err == nil {
+			if !prefix.Addr().Is4() {
+				continue
+			}
+			r = prefixToRange(prefix)
+			values = fields[1:]
+		} else if start, err := netip.ParseAddr(fields[0]); err == nil && len(fields) > 1 {
+			end, err := netip.ParseAddr(fields[1])
+			if err != nil || !start.Is4() || !end.Is4() {
+				continue
+			}
+			r = ipRange{start: addrToUint32(start), end: addrToUint32(end)}
+			values = fields[2:]
+			cols = 2
+		} else {
+			if header {
+				// Exact names only: "country_name" holds no code, and the
+				// first matching column wins
+				for i, name := range fields {
+					switch strings.ToLower(name) {
+					case "country", "country_code", "iso_code", "country_iso_code":
+						if countryCol < 0 {
+							countryCol = i
+						}
+					case "asn", "autonomous_system_number", "as_number":
+						if asnCol < 0 {
+							asnCol = i
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		var rec GeoIPRecord
+		if asnCol >= 0 || countryCol >= 0 {
+			if asnCol >= cols && asnCol < len(fields) {
+				rec.ASN, _ = parseASN(fields[asnCol])
+			}
+			if countryCol >= cols && countryCol < len(fields) && len(fields[countryCol]) == 2 {
+				rec.Country = strings.ToUpper(fields[countryCol])
+			}
+		} else {
+			for _, v := range values {
+				if asn, err := parseASN(v); err == nil && rec.ASN == 0 {
+					rec.ASN = asn
+				} else if len(v) == 2 && isLetters(v) && rec.Country == "" {
+					rec.Country = strings.ToUpper(v)
+				}
+			}
+		}
+		db.ranges = append(db.ranges, geoIPRange{r, rec})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start < db.ranges[j].start })
+	return db, nil
+}
+
+func (db *geoIPCSV) Lookup(ip netip.Addr) (GeoIPRecord, bool) {
+	v := addrToUint32(ip)
+	i := sort.Search(len(db.ranges), func(i int) bool { return db.ranges[i].start > v })
+	if i == 0 || db.ranges[i-1].end < v {
+		return GeoIPRecord{}, false
+	}
+	return db.ranges[i-1].GeoIPRecord, true
+}
+
+func (db *geoIPCSV) Prefixes(match func(GeoIPRecord) bool) (*CIDRSet, error) {
+	set := NewCIDRSet()
+	for _, r := range db.ranges {
+		if match(r.GeoIPRecord) {
+			set.addRange(r.ipRange)
+		}
+	}
+	return set, nil
+}
+
+func addrToUint32(addr netip.Addr) uint32 {
+	a := addr.As4()
+	return uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3])
+}
+
+func isLetters(s string) bool {
+	for _, ch := range s {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// geoIPRecordOf reads the ASN and country of an MMDB record. GeoLite2 and
+// DB-IP use autonomous_system_number and country.iso_code; IPinfo and
+// others use flat asn ("AS15169") and country fields.
+func geoIPRecordOf(value any) GeoIPRecord {
+	var rec GeoIPRecord
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return rec
+	}
+	if asn, ok := fields["autonomous_system_number"].(uint64); ok {
+		rec.ASN = uint32(asn)
+	} else {
+		if s, ok := fields["asn"].(string); ok {
+			rec.ASN, _ = parseASN(s)
+		}
+	}
+	switch country := fields["country"].(type) {
+	case map[string]any:
+		rec.Country, _ = country["iso_code"].(string)
+	case string:
+		rec.Country = country
+	}
+	if rec.Country == "" {
+		rec.Country, _ = fields["country_code"].(string)
+	}
+	rec.Country = strings.ToUpper(rec.Country)
+	return rec
+}
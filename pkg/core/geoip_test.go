@@ -0,0 +1,163 @@
+package core
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"network-router/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// writeTestMMDB writes an IPv4 MaxMind DB (24-bit records) mapping each
+// prefix to a map of string/uint32 fields
+func writeTestMMDB(t *testing.T, path string, networks map[string]map[string]any) {
+	t.Helper()
+	const empty = -1
+	type node [2]int // Child node, empty, or -(2+data index)
+	nodes := []node{{empty, empty}}
+	var data []byte
+	for cidr, fields := range networks {
+		prefix := netip.MustParsePrefix(cidr)
+		addr := addrToUint32(prefix.Addr())
+		dataRef := -(2 + len(data))
+		data = append(data, encodeMMDB(fields)...)
+		n := 0
+		for depth := 0; depth < prefix.Bits(); depth++ {
+			bit := int(addr >> (31 - depth) & 1)
+			if depth == prefix.Bits()-1 {
+				nodes[n][bit] = dataRef
+				break
+			}
+			if nodes[n][bit] < 0 {
+				nodes = append(nodes, node{empty, empty})
+				nodes[n][bit] = len(nodes) - 1
+			}
+			n = nodes[n][bit]
+		}
+	}
+
+	var buf []byte
+	count := len(nodes)
+	for _, nd := range nodes {
+		for _, child := range nd {
+			record := child
+			switch {
+			case child == empty:
+				record = count
+			case child < 0:
+				record = count + 16 + (-child - 2)
+			}
+			buf = append(buf, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, mmdbMetadataMarker...)
+	buf = append(buf, encodeMMDB(map[string]any{"node_count": uint32(count), "record_size": uint32(24), "ip_version": uint32(4)})...)
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeMMDB(value any) []byte {
+	switch v := value.(type) {
+	case string:
+		if len(v) >= 29 {
+			return append([]byte{mmdbString<<5 | 29, byte(len(v) - 29)}, v...)
+		}
+		return append([]byte{mmdbString<<5 | byte(len(v))}, v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		return append([]byte{mmdbUint32<<5 | 4}, b...)
+	case map[string]any:
+		out := []byte{mmdbMap<<5 | byte(len(v))}
+		for key, field := range v {
+			out = append(out, encodeMMDB(key)...)
+			out = append(out, encodeMMDB(field)...)
+		}
+		return out
+	}
+	panic("unsupported test value")
+}
+
+func TestGeoIPCSVHeader(t *testing.T) {
+	dir := t.TempDir()
+	// IPinfo layout behind a comment: country_name must not replace country
+	csv := "# IPinfo country_asn\nstart_ip,end_ip,country,country_name,continent,asn\n" +
+		"1.0.0.0,1.0.0.255,AU,Australia,OC,AS13335\n103.1.0.0,103.1.255.255,SG,Singapore,AS,AS4657\n"
+	writeTestFile(t, dir, "country_asn.csv", csv)
+
+	db, err := loadGeoIPCSV(filepath.Join(dir, "country_asn.csv"))
+	if err != nil {
+		t.Fatalf("loadGeoIPCSV failed: %v", err)
+	}
+	for ip, want := range map[string]GeoIPRecord{"1.0.0.1": {ASN: 13335, Country: "AU"}, "103.1.2.3": {ASN: 4657, Country: "SG"}} {
+		if got, ok := db.Lookup(netip.MustParseAddr(ip)); !ok || got != want {
+			t.Errorf("Lookup(%s) = %+v, want %+v", ip, got, want)
+		}
+	}
+}
+
+func TestGeoIPRules(t *testing.T) {
+	dir := t.TempDir()
+	writeTestMMDB(t, filepath.Join(dir, "asn.mmdb"), map[string]map[string]any{
+		"8.8.8.0/24":     {"autonomous_system_number": uint32(15169), "autonomous_system_organization": "GOOGLE"},
+		"8.8.4.0/24":     {"autonomous_system_number": uint32(15169)},
+		"1.1.1.0/24":     {"autonomous_system_number": uint32(13335)},
+		"142.250.0.0/15": {"autonomous_system_number": uint32(15169)},
+	})
+	writeTestMMDB(t, filepath.Join(dir, "country.mmdb"), map[string]map[string]any{
+		"103.1.0.0/16": {"country": map[string]any{"iso_code": "SG"}},
+		"9.9.9.0/24":   {"country": map[string]any{"iso_code": "US"}},
+	})
+	// ip2asn TSV: start, end, ASN, country, description
+	tsv := "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n34.64.0.0\t34.127.255.255\t15169\tUS\tGOOGLE\n"
+	writeTestFile(t, dir, "ip2asn-v4.tsv", tsv)
+
+	config := &Config{
+		TetherASNs:      []string{"AS15169"},
+		TetherCountries: []string{"sg"},
+		GeoIPDatabases:  []string{"asn.mmdb", "country.mmdb", "ip2asn-v4.tsv"},
+	}
+	rules, err := LoadGeoIPRules(config, dir)
+	if err != nil {
+		t.Fatalf("LoadGeoIPRules failed: %v", err)
+	}
+	for ip, want := range map[string]bool{"8.8.8.8": true, "142.251.10.1": true, "1.1.1.1": false, "103.1.2.3": true, "9.9.9.9": false, "34.100.0.1": true, "1.0.0.1": false} {
+		if got := rules.Match(ip); got != want {
+			t.Errorf("Match(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	set, err := rules.Prefixes()
+	if err != nil {
+		t.Fatalf("Prefixes failed: %v", err)
+	}
+	want := []string{"8.8.4.0/24", "8.8.8.0/24", "34.64.0.0/10", "103.1.0.0/16", "142.250.0.0/15"}
+	if got := set.Strings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected prefixes %v, got %v", want, got)
+	}
+
+	// Answers of other domains are routed per IP when a rule matches
+	t.Setenv("TMPDIR", t.TempDir())
+	config.geoIP = rules
+	mockRM := NewMockRouteManager()
+	router, _ := NewRouter(config, mockRM)
+	router.phoneIface = &utils.InterfaceInfo{DeviceName: "usb0"}
+	router.phoneGateway = "172.20.10.1"
+	proxy := NewDNSProxy(config, func() *Router { return router })
+	resp := new(dns.Msg)
+	resp.SetQuestion("www.google.com.", dns.TypeA)
+	for _, ip := range []string{"142.250.1.1", "1.1.1.1"} {
+		resp.Answer = append(resp.Answer, &dns.A{Hdr: dns.RR_Header{Name: "www.google.com.", Rrtype: dns.TypeA, Ttl: 300}, A: net.ParseIP(ip)})
+	}
+	proxy.processResponse(resp, proxy.geoIP.Match)
+	if want := []string{"142.250.1.1/32"}; !reflect.DeepEqual(mockRM.addedRoutes, want) {
+		t.Errorf("Expected routes %v, got %v", want, mockRM.addedRoutes)
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+// minTetherConfidence is the confidence needed to auto-pick a phone uplink
+const minTetherConfidence = 0.5
+
+// InterfaceMatch is an interface chosen by one of the configured selectors,
+// or by the tether classifier when no phone selector is configured
+type InterfaceMatch struct {
+	Interface  *utils.InterfaceInfo
+	Selector   string           // The selector that matched, e.g. "device=en8" or "auto:iphone-usb"
+	Type       utils.TetherType // Classified tether type (auto-picked phone only)
+	Confidence float64          // Classifier confidence 0..1 (auto-picked phone only)
+}
+
+// Device returns the matched device name, or "" if nothing matched
+func (m InterfaceMatch) Device() string {
+	if m.Interface == nil {
+		return ""
+	}
+	return m.Interface.DeviceName
+}
+
+// WifiSelectors returns the Wi-Fi selectors in priority order: configured
+// wifi_interfaces, then the legacy wifi_interface_name, then the defaults
+func (c *Config) WifiSelectors() []utils.InterfaceSelector {
+	selectors := append([]utils.InterfaceSelector(nil), c.WifiInterfaces...)
+	selectors = append(selectors, legacySelectors(c.WifiInterfaceKeyword)...)
+	return append(selectors,
+		utils.InterfaceSelector{Name: "Wi-Fi"},
+		utils.InterfaceSelector{Kind: utils.KindWifi},
+	)
+}
+
+// PhoneSelectors returns the phone selectors in priority order: configured
+// phone_interfaces, then the legacy phone_interface_name, then the defaults
+func (c *Config) PhoneSelectors() []utils.InterfaceSelector {
+	selectors := append([]utils.InterfaceSelector(nil), c.PhoneInterfaces...)
+	selectors = append(selectors, legacySelectors(c.PhoneInterfaceKeyword)...)
+	return append(selectors,
+		utils.InterfaceSelector{Name: "iPhone USB"},
+		utils.InterfaceSelector{Name: "iPad USB"},
+		utils.InterfaceSelector{Name: "RNDIS"},
+		utils.InterfaceSelector{Kind: utils.KindTether},
+	)
+}
+
+// legacySelectors maps the old keyword settings, which were matched against
+// the hardware port name but are often set to a device name like "en8"
+func legacySelectors(keyword string) []utils.InterfaceSelector {
+	if keyword == "" {
+		return nil
+	}
+	return []utils.InterfaceSelector{{Name: keyword}, {Device: keyword}}
+}
+
+// phoneConfigured reports whether the user told us which interface is the phone
+func (c *Config) phoneConfigured() bool {
+	return len(c.PhoneInterfaces) > 0 || c.PhoneInterfaceKeyword != ""
+}
+
+// SelectInterfaces picks the Wi-Fi and phone interfaces. The phone is never
+// the device already chosen for Wi-Fi. Without phone selectors in the config
+// the tether classifier picks the most likely phone uplink.
+func SelectInterfaces(config *Config, interfaces []utils.InterfaceInfo) (wifi, phone InterfaceMatch, err error) {
+	iface, sel, err := utils.SelectInterface(interfaces, config.WifiSelectors(), utils.IsInterfaceActive)
+	if err != nil {
+		return wifi, phone, err
+	}
+	if iface != nil {
+		wifi = InterfaceMatch{Interface: iface, Selector: sel.String()}
+	}
+
+	var rest []utils.InterfaceInfo
+	for _, i := range interfaces {
+		if i.DeviceName != wifi.Device() {
+			rest = append(rest, i)
+		}
+	}
+	if !config.phoneConfigured() {
+		macs := make(map[string]string, len(rest))
+		for _, i := range rest {
+			macs[i.DeviceName] = i.MacAddress
+		}
+		gateway := func(device string) string { return tetherGateways.get(device, macs[device], activeGateway) }
+		if candidates := utils.ClassifyTethers(rest, wifi.Device(), gateway, utils.IsInterfaceActive); len(candidates) > 0 {
+			best := candidates[0]
+			if best.Confidence >= minTetherConfidence {
+				phone = InterfaceMatch{
+					Interface:  &best.Interface,
+					Selector:   "auto:" + string(best.Type),
+					Type:       best.Type,
+					Confidence: best.Confidence,
+				}
+				return wifi, phone, nil
+			}
+			log.Printf("Tether classifier: best guess %s (%s, confidence %.2f: %s) is below %.2f",
+				best.Interface.DeviceName, best.Type, best.Confidence, strings.Join(best.Reasons, ", "), minTetherConfidence)
+		}
+	}
+
+	iface, sel, err = utils.SelectInterface(rest, config.PhoneSelectors(), utils.IsInterfaceActive)
+	if err != nil {
+		return wifi, phone, err
+	}
+	if iface != nil {
+		phone = InterfaceMatch{Interface: iface, Selector: sel.String()}
+	}
+	return wifi, phone, nil
+}
+
+// activeGateway returns the gateway of an active device, or "" so inactive
+// adapters are classified by name and driver alone
+func activeGateway(device string) string {
+	if !utils.IsInterfaceActive(device) {
+		return ""
+	}
+	gateway, err := utils.GetInterfaceGateway(device)
+	if err != nil {
+		return ""
+	}
+	return gateway
+}
+
+// Gateways found for classification stay valid this long; adapters without
+// one are looked at again sooner, e.g. while DHCP is still running
+const (
+	tetherGatewayTTL   = 5 * time.Minute
+	tetherNoGatewayTTL = 30 * time.Second
+)
+
+// gatewayCache remembers activeGateway per device and MAC, so detector polls
+// don't run ifconfig/ipconfig for every adapter each time
+type gatewayCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedGateway
+	now     func() time.Time
+}
+
+type cachedGateway struct {
+	gateway string
+	expires time.Time
+}
+
+var tetherGateways = &gatewayCache{entries: make(map[string]cachedGateway), now: time.Now}
+
+func (c *gatewayCache) get(device, mac string, lookup func(string) string) string {
+	key := device + "|" + mac
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.gateway
+	}
+
+	gateway := lookup(device)
+	ttl := tetherGatewayTTL
+	if gateway == "" {
+		ttl = tetherNoGatewayTTL
+	}
+	c.mu.Lock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k) // Unplugged adapters
+		}
+	}
+	c.entries[key] = cachedGateway{gateway: gateway, expires: now.Add(ttl)}
+	c.mu.Unlock()
+	return gateway
+}
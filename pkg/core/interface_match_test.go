@@ -0,0 +1,43 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGatewayCache(t *testing.T) {
+	now := time.Now()
+	cache := &gatewayCache{entries: make(map[string]cachedGateway), now: func() time.Time { return now }}
+	lookups := 0
+	gateways := map[string]string{"en8": "172.20.10.1"}
+	lookup := func(device string) string {
+		lookups++
+		return gateways[device]
+	}
+
+	for i := 0; i < 3; i++ {
+		if gw := cache.get("en8", "5e:5b:35:8c:2d:64", lookup); gw != "172.20.10.1" {
+			t.Fatalf("Expected 172.20.10.1, got %q", gw)
+		}
+		cache.get("en5", "00:e0:4c:68:01:9f", lookup)
+	}
+	if lookups != 2 {
+		t.Errorf("Expected one lookup per device, got %d", lookups)
+	}
+
+	// Another phone on the same device name is looked up again
+	cache.get("en8", "9a:10:77:02:4e:51", lookup)
+	if lookups != 3 {
+		t.Errorf("Expected a lookup for the new MAC, got %d", lookups)
+	}
+
+	// Adapters without a gateway are retried sooner than those with one
+	gateways["en5"] = "192.168.42.129"
+	now = now.Add(tetherNoGatewayTTL)
+	if gw := cache.get("en5", "00:e0:4c:68:01:9f", lookup); gw != "192.168.42.129" {
+		t.Errorf("Expected the new gateway after %s, got %q", tetherNoGatewayTTL, gw)
+	}
+	if cache.get("en8", "5e:5b:35:8c:2d:64", lookup); lookups != 4 {
+		t.Errorf("Expected the en8 gateway still cached, got %d lookups", lookups)
+	}
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Provider document formats for ip_range_sources
+const (
+	RangesGoogle = "google" // goog.json / cloud.json: prefixes[].ipv4Prefix, service, scope
+	RangesGitHub = "github" // api.github.com/meta: service key -> list of CIDRs
+	RangesAWS    = "aws"    // ip-ranges.json: prefixes[].ip_prefix, service, region
+)
+
+// IPRangeSource is a provider's published IP ranges, read from a local
+// file and merged into tether_cidrs on every (re)load
+type IPRangeSource struct {
+	Path     string   `yaml:"path"`     // Relative to the config file
+	Format   string   `yaml:"format"`   // google, github or aws (default: detected)
+	Services []string `yaml:"services"` // Keep only these services (GitHub meta keys, AWS/Google service names)
+	Regions  []string `yaml:"regions"`  // Keep only these regions (AWS region, Google scope)
+}
+
+// LoadIPRanges reads the IPv4 prefixes of src selected by its filters,
+// merged into the fewest prefixes covering exactly the same addresses
+func LoadIPRanges(src IPRangeSource) ([]string, error) {
+	data, err := os.ReadFile(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Path, err)
+	}
+
+	format := src.Format
+	if format == "" {
+		format = detectRangesFormat(doc)
+	}
+	var prefixes []string
+	switch format {
+	case RangesGoogle:
+		prefixes, err = googleRanges(doc, src)
+	case RangesGitHub:
+		prefixes, err = githubRanges(doc, src)
+	case RangesAWS:
+		prefixes, err = awsRanges(doc, src)
+	default:
+		return nil, fmt.Errorf("%s: unknown IP range format %q (supported: google, github, aws)", src.Path, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Path, err)
+	}
+
+	set := NewCIDRSet()
+	for _, p := range prefixes {
+		if err := set.Add(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", src.Path, err)
+		}
+	}
+	return set.Strings(), nil
+}
+
+// detectRangesFormat tells the documents apart by their prefix entries
+func detectRangesFormat(doc map[string]json.RawMessage) string {
+	raw, ok := doc["prefixes"]
+	if !ok {
+		return RangesGitHub
+	}
+	if strings.Contains(string(raw), `"ip_prefix"`) {
+		return RangesAWS
+	}
+	return RangesGoogle
+}
+
+func googleRanges(doc map[string]json.RawMessage, src IPRangeSource) ([]string, error) {
+	var entries []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		Service    string `json:"service"`
+		Scope      string `json:"scope"`
+	}
+	if err := json.Unmarshal(doc["prefixes"], &entries); err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, e := range entries {
+		if e.IPv4Prefix != "" && matchFilter(src.Services, e.Service) && matchFilter(src.Regions, e.Scope) {
+			prefixes = append(prefixes, e.IPv4Prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+func awsRanges(doc map[string]json.RawMessage, src IPRangeSource) ([]string, error) {
+	var entries []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Service  string `json:"service"`
+		Region   string `json:"region"`
+	}
+	if err := json.Unmarshal(doc["prefixes"], &entries); err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, e := range entries {
+		if matchFilter(src.Services, e.Service) && matchFilter(src.Regions, e.Region) {
+			prefixes = append(prefixes, e.IPPrefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// githubRanges reads the service keys whose value is a list of CIDRs;
+// IPv6 entries and other strings (e.g. "ssh_keys") are skipped
+func githubRanges(doc map[string]json.RawMessage, src IPRangeSource) ([]string, error) {
+	if len(src.Regions) > 0 {
+		log.Printf("Warning: %s: GitHub ranges have no regions, ignoring the filter", src.Path)
+	}
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var prefixes []string
+	for _, key := range keys {
+		var list []string
+		if json.Unmarshal(doc[key], &list) != nil || !matchFilter(src.Services, key) {
+			continue // Not a list (e.g. "domains") or filtered out
+		}
+		for _, entry := range list {
+			if prefix, err := netip.ParsePrefix(entry); err == nil && prefix.Addr().Is4() {
+				prefixes = append(prefixes, entry)
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// matchFilter reports whether value passes a filter; an empty filter
+// passes everything
+func matchFilter(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if strings.EqualFold(f, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeIPRanges appends the prefixes of every ip_range_sources entry to
+// tether_cidrs. Relative paths are resolved against dir.
+func (c *Config) mergeIPRanges(dir string) error {
+	for _, src := range c.IPRangeSources {
+		if !filepath.IsAbs(src.Path) {
+			src.Path = filepath.Join(dir, src.Path)
+		}
+		prefixes, err := LoadIPRanges(src)
+		if err != nil {
+			return fmt.Errorf("ip_range_sources: %w", err)
+		}
+		c.TetherCIDRs = appendMissing(c.TetherCIDRs, prefixes)
+		c.sourceFiles = append(c.sourceFiles, src.Path)
+		log.Printf("📥 Loaded %d IPv4 prefix(es) from %s", len(prefixes), src.Path)
+	}
+	return nil
+}
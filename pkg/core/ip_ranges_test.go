@@ -0,0 +1,60 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadIPRanges(t *testing.T) {
+	dir := t.TempDir()
+	google := writeTestFile(t, dir, "cloud.json", `{"syncToken": "1", "prefixes": [
+		{"ipv4Prefix": "34.1.0.0/17", "service": "Google Cloud", "scope": "us-central1"},
+		{"ipv4Prefix": "34.1.128.0/17", "service": "Google Cloud", "scope": "us-central1"},
+		{"ipv6Prefix": "2600:1900::/35", "service": "Google Cloud", "scope": "us-central1"},
+		{"ipv4Prefix": "35.2.0.0/16", "service": "Google Cloud", "scope": "europe-west1"}]}`)
+	github := writeTestFile(t, dir, "meta.json", `{"verifiable_password_authentication": true,
+		"api": ["140.82.112.0/20", "2a0a:a440::/29"], "copilot": ["140.82.112.0/24", "192.30.252.0/22"],
+		"web": ["185.199.108.0/22"], "domains": {"copilot": ["*.githubcopilot.com"]},
+		"ssh_keys": ["ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"]}`)
+	aws := writeTestFile(t, dir, "ip-ranges.json", `{"prefixes": [
+		{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+		{"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT"}],
+		"ipv6_prefixes": [{"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2", "service": "AMAZON"}]}`)
+
+	tests := []struct {
+		name string
+		src  IPRangeSource
+		want []string
+	}{
+		{"google region, adjacent prefixes merged", IPRangeSource{Path: google, Regions: []string{"us-central1"}}, []string{"34.1.0.0/16"}},
+		{"github keys, IPv6 skipped", IPRangeSource{Path: github, Services: []string{"api", "copilot"}}, []string{"140.82.112.0/20", "192.30.252.0/22"}},
+		{"github all keys", IPRangeSource{Path: github, Format: RangesGitHub}, []string{"140.82.112.0/20", "185.199.108.0/22", "192.30.252.0/22"}},
+		{"aws service", IPRangeSource{Path: aws, Services: []string{"cloudfront"}}, []string{"13.32.0.0/15"}},
+	}
+	for _, tt := range tests {
+		got, err := LoadIPRanges(tt.src)
+		if err != nil {
+			t.Fatalf("%s: LoadIPRanges failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := LoadIPRanges(IPRangeSource{Path: aws, Format: "azure"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+
+	writeTestFile(t, dir, "config.yaml", "tether_cidrs: ['10.0.0.0/8']\nip_range_sources:\n  - path: meta.json\n    services: [web]\n")
+	config, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if want := []string{"10.0.0.0/8", "185.199.108.0/22"}; !reflect.DeepEqual(config.TetherCIDRs, want) {
+		t.Errorf("Expected %v, got %v", want, config.TetherCIDRs)
+	}
+	if want := []string{github}; !reflect.DeepEqual(config.SourceFiles(), want) {
+		t.Errorf("Expected source files %v, got %v", want, config.SourceFiles())
+	}
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of the file
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDatabase is a read-only MaxMind DB (GeoLite2/GeoIP2, DB-IP, IPinfo
+// and other .mmdb files), kept in memory. Only what the GeoIP rules need
+// is implemented: IPv4 lookups and walking the IPv4 part of the tree.
+type mmdbDatabase struct {
+	path       string
+	tree       []byte
+	data       []byte
+	nodeCount  uint32
+	recordSize int
+	ipv4Start  uint32 // Node of ::/96 in IPv6 trees, 0 in IPv4 trees
+}
+
+func openMMDB(path string) (*mmdbDatabase, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	marker := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if marker < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+	}
+	meta, _, err := (&mmdbDecoder{buf: buf[marker+len(mmdbMetadataMarker):]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: metadata: %w", path, err)
+	}
+	fields, _ := meta.(map[string]any)
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, recordSize)
+	}
+
+	treeSize := int(nodeCount) * int(recordSize) / 4
+	if treeSize+16 > marker {
+		return nil, fmt.Errorf("%s: search tree larger than the file", path)
+	}
+	db := &mmdbDatabase{
+		path:       path,
+		tree:       buf[:treeSize],
+		data:       buf[treeSize+16 : marker],
+		nodeCount:  uint32(nodeCount),
+		recordSize: int(recordSize),
+	}
+	if ipVersion == 6 {
+		// IPv4 addresses live at ::a.b.c.d
+		node := uint32(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (db *mmdbDatabase) record(node uint32, bit int) uint32 {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+uint32(bit)*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint32(b[3]&0xF0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0F)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(db.tree[node*8+uint32(bit)*4:])
+	}
+}
+
+// recordValue decodes the data a record points to
+func (db *mmdbDatabase) recordValue(record uint32) (GeoIPRecord, error) {
+	value, _, err := (&mmdbDecoder{buf: db.data}).decode(int(record - db.nodeCount - 16))
+	if err != nil {
+		return GeoIPRecord{}, fmt.Errorf("%s: %w", db.path, err)
+	}
+	return geoIPRecordOf(value), nil
+}
+
+func (db *mmdbDatabase) Lookup(ip netip.Addr) (GeoIPRecord, bool) {
+	a := ip.As4()
+	addr := binary.BigEndian.Uint32(a[:])
+	node := db.ipv4Start
+	for i := 0; i < 32 && node < db.nodeCount; i++ {
+		node = db.record(node, int(addr>>(31-i)&1))
+	}
+	if node <= db.nodeCount {
+		return GeoIPRecord{}, false
+	}
+	rec, err := db.recordValue(node)
+	return rec, err == nil
+}
+
+func (db *mmdbDatabase) Prefixes(match func(GeoIPRecord) bool) (*CIDRSet, error) {
+	set := NewCIDRSet()
+	matches := make(map[uint32]bool) // By record: networks share their data
+	var walk func(node, addr uint32, depth int) error
+	walk = func(node, addr uint32, depth int) error {
+		for bit := 0; bit < 2; bit++ {
+			next := addr | uint32(bit)<<(31-depth)
+			record := db.record(node, bit)
+			switch {
+			case record < db.nodeCount && depth < 31:
+				if err := walk(record, next, depth+1); err != nil {
+					return err
+				}
+			case record > db.nodeCount:
+				matched, ok := matches[record]
+				if !ok {
+					rec, err := db.recordValue(record)
+					if err != nil {
+						return err
+					}
+					matched = match(rec)
+					matches[record] = matched
+				}
+				if matched {
+					set.addRange(prefixToRange(netip.PrefixFrom(uint32ToAddr(next), depth+1)))
+				}
+			}
+		}
+		return nil
+	}
+	if db.ipv4Start >= db.nodeCount {
+		return set, nil // No IPv4 data
+	}
+	return set, walk(db.ipv4Start, 0, 0)
+}
+
+func uint32ToAddr(v uint32) netip.Addr {
+	var a [4]byte
+	binary.BigEndian.PutUint32(a[:], v)
+	return netip.AddrFrom4(a)
+}
+
+// mmdbDecoder decodes the MaxMind DB data format; pointers are relative to
+// the start of buf
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// MaxMind DB data types
+const (
+	mmdbExtended = 0
+	mmdbPointer  = 1
+	mmdbString   = 2
+	mmdbDouble   = 3
+	mmdbBytes    = 4
+	mmdbUint16   = 5
+	mmdbUint32   = 6
+	mmdbMap      = 7
+	mmdbInt32    = 8
+	mmdbUint64   = 9
+	mmdbUint128  = 10
+	mmdbArray    = 11
+	mmdbBool     = 14
+	mmdbFloat    = 15
+)
+
+// decode returns the value at offset and the offset after it. Maps become
+// map[string]any, arrays []any and unsigned integers uint64.
+func (d *mmdbDecoder) decode(offset int) (any, int, error) {
+	if offset < 0 || offset >= len(d.buf) {
+		return nil, 0, fmt.Errorf("data offset %d out of range", offset)
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == mmdbPointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+	if typ == mmdbExtended {
+		if offset >= len(d.buf) {
+			return nil, 0, fmt.Errorf("truncated data")
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return nil, 0, fmt.Errorf("truncated data")
+		}
+		extra := 0
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		offset += n
+		size = [...]int{29, 285, 65821}[n-1] + extra
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := 0; i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		list := make([]any, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			list = append(list, value)
+			offset = next
+		}
+		return list, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, fmt.Errorf("truncated data")
+	}
+	raw := d.buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(raw), offset, nil
+	case mmdbBytes:
+		return raw, offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		var v uint64
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, offset, nil
+	case mmdbUint128:
+		return raw, offset, nil // Not needed by the rules
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+}
+
+// pointer returns the offset a pointer refers to and the offset after it
+func (d *mmdbDecoder) pointer(ctrl byte, offset int) (int, int, error) {
+	n := int(ctrl>>3&0x3) + 1
+	if offset+n > len(d.buf) {
+		return 0, 0, fmt.Errorf("truncated pointer")
+	}
+	v := 0
+	if n < 4 {
+		v = int(ctrl & 0x7)
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		v = v<<8 | int(b)
+	}
+	v += [...]int{0, 2048, 526336, 0}[n-1]
+	return v, offset + n, nil
+}
@@ -1,27 +1,65 @@
 package core
 
-import "network-router/pkg/utils"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+// defaultRouteOpTimeout bounds a single route command
+const defaultRouteOpTimeout = 10 * time.Second
 
 // OSRouteManager is an adapter that implements RouteManager
 // by delegating to OS-specific utilities in the utils package.
-type OSRouteManager struct{}
+type OSRouteManager struct {
+	// Timeout bounds each route command; a command that hangs longer is
+	// killed and reported as context.DeadlineExceeded
+	Timeout time.Duration
+}
 
 func NewOSRouteManager() *OSRouteManager {
-	return &OSRouteManager{}
+	return &OSRouteManager{Timeout: defaultRouteOpTimeout}
+}
+
+func (m *OSRouteManager) AddRoute(ctx context.Context, destination string, interfaceName string) error {
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	return ignoreErr(utils.AddRoute(ctx, destination, interfaceName), ErrExists)
+}
+
+func (m *OSRouteManager) AddRouteViaGateway(ctx context.Context, destination string, gatewayIP string) error {
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	return ignoreErr(utils.AddRouteViaGateway(ctx, destination, gatewayIP), ErrExists)
 }
 
-func (m *OSRouteManager) AddRoute(destination string, interfaceName string) error {
-	return utils.AddRoute(destination, interfaceName)
+func (m *OSRouteManager) ChangeDefaultGateway(ctx context.Context, gatewayIP string) error {
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	return utils.ChangeDefaultGateway(ctx, gatewayIP)
 }
 
-func (m *OSRouteManager) AddRouteViaGateway(destination string, gatewayIP string) error {
-	return utils.AddRouteViaGateway(destination, gatewayIP)
+func (m *OSRouteManager) DeleteRoute(ctx context.Context, destination string) error {
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	return ignoreErr(utils.DeleteRoute(ctx, destination), ErrNotFound)
 }
 
-func (m *OSRouteManager) ChangeDefaultGateway(gatewayIP string) error {
-	return utils.ChangeDefaultGateway(gatewayIP)
+// opContext bounds a command by the caller's ctx and the timeout
+func (m *OSRouteManager) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = defaultRouteOpTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
-func (m *OSRouteManager) DeleteRoute(destination string) error {
-	return utils.DeleteRoute(destination)
+// ignoreErr returns nil when err matches target, keeping add/delete idempotent
+func ignoreErr(err error, target error) error {
+	if errors.Is(err, target) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPACListen = "127.0.0.1:1079"
+
+// PACProxy returns the PAC result for matching hosts: pac_proxy, or the
+// tether proxy, which speaks both SOCKS5 and HTTP CONNECT
+func PACProxy(config *Config) string {
+	if config.PACProxy != "" {
+		return config.PACProxy
+	}
+	listen := config.ProxyListen
+	if listen == "" {
+		listen = defaultProxyListen
+	}
+	return fmt.Sprintf("SOCKS5 %s; PROXY %s", listen, listen)
+}
+
+// GeneratePAC renders a proxy auto-config script sending hosts matching
+// rules to proxy (e.g. "PROXY 127.0.0.1:1080") and everything else DIRECT.
+// Wildcard domains match the domain itself and its subdomains, like the
+// DNS proxy; CIDRs are matched against the resolved address.
+func GeneratePAC(rules *RuleSet, proxy string) string {
+	var exact, suffixes []string
+	for _, d := range rules.Domains {
+		if base, ok := strings.CutPrefix(d, "*."); ok {
+			exact = append(exact, base)
+			suffixes = append(suffixes, "."+base)
+		} else {
+			exact = append(exact, d)
+		}
+	}
+	var nets []string
+	for _, p := range rules.CIDRs.Prefixes() {
+		mask := net.IP(net.CIDRMask(p.Bits(), 32)).String()
+		nets = append(nets, fmt.Sprintf("[%s, %s]", strconv.Quote(p.Addr().String()), strconv.Quote(mask)))
+	}
+
+	var b strings.Builder
+	b.WriteString("// Generated by network-router from tether_domains and tether_cidrs\n")
+	fmt.Fprintf(&b, "var proxy = %s;\n", strconv.Quote(proxy))
+	fmt.Fprintf(&b, "var domains = [%s];\n", quoteList(exact))
+	fmt.Fprintf(&b, "var suffixes = [%s];\n", quoteList(suffixes))
+	fmt.Fprintf(&b, "var nets = [%s];\n", strings.Join(nets, ", "))
+	b.WriteString(`
+function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  if (host.charAt(host.length - 1) == ".") {
+    host = host.substring(0, host.length - 1);
+  }
+  for (var i = 0; i < domains.length; i++) {
+    if (host == domains[i]) {
+      return proxy;
+    }
+  }
+  for (var i = 0; i < suffixes.length; i++) {
+    if (dnsDomainIs(host, suffixes[i])) {
+      return proxy;
+    }
+  }
+  if (nets.length > 0) {
+    var ip = dnsResolve(host);
+    if (ip) {
+      for (var i = 0; i < nets.length; i++) {
+        if (isInNet(ip, nets[i][0], nets[i][1])) {
+          return proxy;
+        }
+      }
+    }
+  }
+  return "DIRECT";
+}
+`)
+	return b.String()
+}
+
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// PACServer serves the PAC script of the current config over HTTP, for
+// browsers configured with an automatic proxy configuration URL
+type PACServer struct {
+	listen string
+
+	mu     sync.RWMutex
+	script string
+	server *http.Server
+}
+
+func NewPACServer(config *Config) *PACServer {
+	listen := config.PACListen
+	if listen == "" {
+		listen = defaultPACListen
+	}
+	s := &PACServer{listen: listen}
+	s.Update(config)
+	return s
+}
+
+// Update regenerates the script, e.g. after a config reload
+func (s *PACServer) Update(config *Config) {
+	script := GeneratePAC(NewRuleSet(config), PACProxy(config))
+	s.mu.Lock()
+	s.script = script
+	s.mu.Unlock()
+}
+
+// Start listens on pac_listen and serves in the background; a bind
+// failure is returned
+func (s *PACServer) Start() error {
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return fmt.Errorf("PAC server listen on %s: %w", s.listen, err)
+	}
+	server := &http.Server{Handler: s}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
+	log.Printf("📜 PAC script served at http://%s/proxy.pac", listener.Addr())
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("❌ PAC server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop closes the server
+func (s *PACServer) Stop() error {
+	s.mu.Lock()
+	server := s.server
+	s.server = nil
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Close()
+}
+
+// ServeHTTP returns the script for any path, browsers differ in what they ask for
+func (s *PACServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	script := s.script
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, script)
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGeneratePAC(t *testing.T) {
+	config := &Config{
+		TetherDomains:      []string{"*.githubcopilot.com", "github.com"},
+		TetherCIDRs:        []string{"10.0.0.0/8"},
+		TetherCIDRsExclude: []string{"10.128.0.0/9"},
+		ProxyListen:        "127.0.0.1:1081",
+	}
+	pac := GeneratePAC(NewRuleSet(config), PACProxy(config))
+	for _, want := range []string{
+		`var proxy = "SOCKS5 127.0.0.1:1081; PROXY 127.0.0.1:1081";`,
+		`var domains = ["githubcopilot.com", "github.com"];`,
+		`var suffixes = [".githubcopilot.com"];`,
+		`var nets = [["10.0.0.0", "255.128.0.0"]];`,
+		"function FindProxyForURL(url, host)",
+	} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("PAC script is missing %q:\n%s", want, pac)
+		}
+	}
+
+	// The server follows config reloads
+	server := NewPACServer(config)
+	config.TetherDomains = []string{"example.com"}
+	server.Update(config)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/proxy.pac", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `var domains = ["example.com"];`) {
+		t.Errorf("Expected the reloaded rules to be served, got:\n%s", body)
+	}
+}
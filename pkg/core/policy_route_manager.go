@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+const (
+	defaultPolicyTable    = 100
+	defaultPolicyPriority = 10000
+
+	// maxRuleFlush bounds the teardown loop deleting rules one by one
+	maxRuleFlush = 4096
+)
+
+// RouteTeardown is implemented by route managers that keep state besides
+// the individual routes (e.g. a dedicated table). ClearRoutes calls Teardown
+// once every route was deleted.
+type RouteTeardown interface {
+	Teardown() error
+}
+
+// RouteShutdown is implemented by route managers that keep state for the
+// daemon's lifetime (e.g. the fwmark rule); the daemon calls Shutdown on exit
+type RouteShutdown interface {
+	Shutdown() error
+}
+
+// UplinkRouter is implemented by route managers that can route marked
+// traffic via the phone without any destination route
+type UplinkRouter interface {
+	EnsureDefault(ctx context.Context, gatewayIP, device string) error
+}
+
+// PolicyRouteManager is the Linux routing backend. Instead of adding host
+// routes to the main table (where NetworkManager rewrites them) it keeps a
+// dedicated table whose only route is a default via the phone, and adds an
+// `ip rule` per destination prefix (plus one for FwMark, if set) that sends
+// matching traffic to that table. Switching phones replaces the table's
+// default in one step. Destination rules use Priority and the fwmark rule
+// Priority-1, so teardown removes exactly the rules of this backend; the
+// fwmark rule and the table default stay until Shutdown.
+type PolicyRouteManager struct {
+	Table    int           // Routing table id
+	Priority int           // Priority of the rules
+	FwMark   uint32        // Also route packets with this mark via the table (0 = off)
+	Timeout  time.Duration // Bounds each ip command
+
+	mu       sync.Mutex
+	nexthop  string          // Current default of the table
+	rules    map[string]bool // Destinations with a rule
+	markRule bool
+}
+
+func NewPolicyRouteManager(table, priority int, fwmark uint32) *PolicyRouteManager {
+	if table <= 0 {
+		table = defaultPolicyTable
+	}
+	if priority <= 0 {
+		priority = defaultPolicyPriority
+	}
+	return &PolicyRouteManager{
+		Table:    table,
+		Priority: priority,
+		FwMark:   fwmark,
+		Timeout:  defaultRouteOpTimeout,
+		rules:    make(map[string]bool),
+	}
+}
+
+func (m *PolicyRouteManager) AddRoute(ctx context.Context, destination string, interfaceName string) error {
+	if err := m.EnsureDefault(ctx, "", interfaceName); err != nil {
+		return err
+	}
+	return m.addRule(ctx, destination)
+}
+
+func (m *PolicyRouteManager) AddRouteViaGateway(ctx context.Context, destination string, gatewayIP string) error {
+	if err := m.EnsureDefault(ctx, gatewayIP, ""); err != nil {
+		return err
+	}
+	return m.addRule(ctx, destination)
+}
+
+// ChangeDefaultGateway leaves the main table alone: the system default
+// route is never changed by this backend, so there is nothing to restore
+func (m *PolicyRouteManager) ChangeDefaultGateway(ctx context.Context, gatewayIP string) error {
+	return nil
+}
+
+func (m *PolicyRouteManager) DeleteRoute(ctx context.Context, destination string) error {
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	err := utils.DeleteIPRule(ctx, m.rule(destination))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.rules, destination)
+	m.mu.Unlock()
+	return nil
+}
+
+// Teardown deletes the destination rules of this backend, including rules
+// left at its priority by a previous run. Without FwMark the table is
+// flushed too; with it, marked traffic keeps using the table.
+func (m *PolicyRouteManager) Teardown() error {
+	ctx, cancel := m.opContext(context.Background())
+	defer cancel()
+
+	var errs []error
+	for i := 0; i < maxRuleFlush; i++ {
+		err := utils.DeleteIPRule(ctx, utils.IPRule{Table: m.Table, Priority: m.Priority})
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	m.mu.Lock()
+	m.rules = make(map[string]bool)
+	m.mu.Unlock()
+	if m.FwMark == 0 {
+		if err := m.flushTable(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("policy routing teardown (table %d): %w", m.Table, errors.Join(errs...))
+	}
+	log.Printf("✓ Removed the rules of routing table %d", m.Table)
+	return nil
+}
+
+// Shutdown removes the fwmark rule and flushes the table
+func (m *PolicyRouteManager) Shutdown() error {
+	ctx, cancel := m.opContext(context.Background())
+	defer cancel()
+
+	var errs []error
+	if m.FwMark != 0 {
+		err := utils.DeleteIPRule(ctx, m.markRuleSpec())
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+		m.mu.Lock()
+		m.markRule = false
+		m.mu.Unlock()
+	}
+	if err := m.flushTable(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("policy routing shutdown (table %d): %w", m.Table, errors.Join(errs...))
+	}
+	log.Printf("✓ Removed routing table %d", m.Table)
+	return nil
+}
+
+func (m *PolicyRouteManager) flushTable(ctx context.Context) error {
+	if err := utils.FlushTable(ctx, m.Table); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	m.mu.Lock()
+	m.nexthop = ""
+	m.mu.Unlock()
+	return nil
+}
+
+// EnsureDefault points the table at the phone, replacing the previous
+// default in place when the gateway changed, and adds the fwmark rule
+func (m *PolicyRouteManager) EnsureDefault(ctx context.Context, gatewayIP, device string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nexthop := gatewayIP + "%" + device
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	if m.nexthop != nexthop {
+		if err := utils.ReplaceTableDefault(ctx, m.Table, gatewayIP, device); err != nil {
+			return err
+		}
+		m.nexthop = nexthop
+	}
+	if m.FwMark != 0 && !m.markRule {
+		err := utils.AddIPRule(ctx, m.markRuleSpec())
+		if err != nil && !errors.Is(err, ErrExists) {
+			return err
+		}
+		m.markRule = true
+	}
+	return nil
+}
+
+// addRule adds the destination rule once; the kernel would accept duplicates
+func (m *PolicyRouteManager) addRule(ctx context.Context, destination string) error {
+	m.mu.Lock()
+	if m.rules[destination] {
+		m.mu.Unlock()
+		return nil
+	}
+	m.rules[destination] = true
+	m.mu.Unlock()
+
+	ctx, cancel := m.opContext(ctx)
+	defer cancel()
+	if err := utils.AddIPRule(ctx, m.rule(destination)); err != nil && !errors.Is(err, ErrExists) {
+		m.mu.Lock()
+		delete(m.rules, destination)
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (m *PolicyRouteManager) rule(destination string) utils.IPRule {
+	return utils.IPRule{To: destination, Table: m.Table, Priority: m.Priority}
+}
+
+// markRuleSpec sits just before the destination rules, at its own priority
+func (m *PolicyRouteManager) markRuleSpec() utils.IPRule {
+	return utils.IPRule{FwMark: m.FwMark, Table: m.Table, Priority: max(m.Priority-1, 1)}
+}
+
+// opContext bounds a command by the caller's ctx and the timeout
+func (m *PolicyRouteManager) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = defaultRouteOpTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"network-router/pkg/internal/utilstest"
+	"network-router/pkg/utils"
+)
+
+func TestPolicyRouteManagerRecorded(t *testing.T) {
+	fake := utilstest.NewFakeRunner()
+	if err := fake.LoadTranscript("../utils/testdata/linux_policy.txt"); err != nil {
+		t.Fatalf("LoadTranscript failed: %v", err)
+	}
+	prev := utils.SetRunner(fake)
+	t.Cleanup(func() { utils.SetRunner(prev) })
+
+	rm := NewPolicyRouteManager(0, 0, 0x66)
+	for _, dest := range []string{"1.1.1.1/32", "10.10.0.0/16", "1.1.1.1/32"} {
+		if err := rm.AddRouteViaGateway(context.Background(), dest, "192.168.42.129"); err != nil {
+			t.Fatalf("AddRouteViaGateway(%s) failed: %v", dest, err)
+		}
+	}
+	// A new phone gateway only replaces the table's default route
+	if err := rm.AddRouteViaGateway(context.Background(), "10.10.0.0/16", "192.168.43.1"); err != nil {
+		t.Fatalf("AddRouteViaGateway failed: %v", err)
+	}
+	if err := rm.ChangeDefaultGateway(context.Background(), "192.168.1.1"); err != nil {
+		t.Fatalf("ChangeDefaultGateway failed: %v", err)
+	}
+	// The second rule is already gone, which counts as deleted
+	for _, dest := range []string{"1.1.1.1/32", "10.10.0.0/16"} {
+		if err := rm.DeleteRoute(context.Background(), dest); err != nil {
+			t.Fatalf("DeleteRoute(%s) failed: %v", dest, err)
+		}
+	}
+	// Teardown only removes rules at the destination priority: the fwmark
+	// rule and the table default stay for marked traffic
+	if err := rm.Teardown(); err != nil {
+		t.Fatalf("Teardown failed: %v", err)
+	}
+	if err := rm.AddRouteViaGateway(context.Background(), "1.1.1.1/32", "192.168.43.1"); err != nil {
+		t.Fatalf("AddRouteViaGateway after Teardown failed: %v", err)
+	}
+	if err := rm.DeleteRoute(context.Background(), "1.1.1.1/32"); err != nil {
+		t.Fatalf("DeleteRoute failed: %v", err)
+	}
+	if err := rm.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	want := []string{
+		"sudo ip route replace default via 192.168.42.129 table 100",
+		"sudo ip rule add fwmark 0x66 lookup 100 priority 9999",
+		"sudo ip rule add to 1.1.1.1/32 lookup 100 priority 10000",
+		"sudo ip rule add to 10.10.0.0/16 lookup 100 priority 10000",
+		"sudo ip route replace default via 192.168.43.1 table 100",
+		"sudo ip rule del to 1.1.1.1/32 lookup 100 priority 10000",
+		"sudo ip rule del to 10.10.0.0/16 lookup 100 priority 10000",
+		"sudo ip rule del lookup 100 priority 10000",
+		"sudo ip rule add to 1.1.1.1/32 lookup 100 priority 10000",
+		"sudo ip rule del to 1.1.1.1/32 lookup 100 priority 10000",
+		"sudo ip rule del fwmark 0x66 lookup 100 priority 9999",
+		"sudo ip route flush table 100",
+	}
+	if !reflect.DeepEqual(fake.Calls(), want) {
+		t.Errorf("Expected commands:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(fake.Calls(), "\n"))
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed presets/*.yaml
+var presetFiles embed.FS
+
+// Preset is a versioned rule list for a common service, embedded in the
+// binary. The version is bumped whenever its rules change.
+type Preset struct {
+	Name        string   `yaml:"name"`
+	Version     int      `yaml:"version"`
+	Description string   `yaml:"description"`
+	Domains     []string `yaml:"domains"`
+	CIDRs       []string `yaml:"cidrs"`
+}
+
+// Ref returns "name@version"
+func (p *Preset) Ref() string {
+	return fmt.Sprintf("%s@%d", p.Name, p.Version)
+}
+
+// PresetConfig references a preset from the config, either by name alone
+// ("- github-copilot") or as a mapping with overrides
+type PresetConfig struct {
+	Name           string   `yaml:"name"`
+	Version        int      `yaml:"version"`         // Version the config was written for (0 = any); a different one is logged
+	Domains        []string `yaml:"domains"`         // Added to the preset's domains
+	CIDRs          []string `yaml:"cidrs"`           // Added to the preset's CIDRs
+	ExcludeDomains []string `yaml:"exclude_domains"` // Preset domains left out
+	ExcludeCIDRs   []string `yaml:"exclude_cidrs"`   // Preset CIDRs left out
+}
+
+// UnmarshalYAML accepts a plain preset name
+func (p *PresetConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		p.Name = node.Value
+		return nil
+	}
+	type plain PresetConfig
+	return node.Decode((*plain)(p))
+}
+
+// Presets returns the embedded presets sorted by name
+func Presets() ([]*Preset, error) {
+	entries, err := presetFiles.ReadDir("presets")
+	if err != nil {
+		return nil, err
+	}
+	var presets []*Preset
+	for _, entry := range entries {
+		data, err := presetFiles.ReadFile(path.Join("presets", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		preset, err := parsePreset(entry.Name(), data)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets, nil
+}
+
+// LookupPreset returns the embedded preset called name
+func LookupPreset(name string) (*Preset, error) {
+	data, err := PresetSource(name)
+	if err != nil {
+		return nil, err
+	}
+	return parsePreset(name, data)
+}
+
+// PresetSource returns the embedded YAML of a preset, comments included
+func PresetSource(name string) ([]byte, error) {
+	var data []byte
+	err := fs.ErrNotExist
+	if !strings.ContainsAny(name, "/.") {
+		data, err = presetFiles.ReadFile(path.Join("presets", name+".yaml"))
+	}
+	if err != nil {
+		var names []string
+		presets, _ := Presets()
+		for _, p := range presets {
+			names = append(names, p.Name)
+		}
+		return nil, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return data, nil
+}
+
+func parsePreset(name string, data []byte) (*Preset, error) {
+	var preset Preset
+	if err := yaml.Unmarshal(data, &preset); err != nil {
+		return nil, fmt.Errorf("preset %s: %w", name, err)
+	}
+	return &preset, nil
+}
+
+// Apply returns the preset's rules with the overrides of ref
+func (p *Preset) Apply(ref PresetConfig) (domains, cidrs []string) {
+	return withoutEntries(appendMissing(append([]string{}, p.Domains...), ref.Domains), ref.ExcludeDomains),
+		withoutEntries(appendMissing(append([]string{}, p.CIDRs...), ref.CIDRs), ref.ExcludeCIDRs)
+}
+
+func withoutEntries(list, remove []string) []string {
+	if len(remove) == 0 {
+		return list
+	}
+	drop := entrySet(remove)
+	kept := list[:0]
+	for _, item := range list {
+		if !drop[strings.ToLower(item)] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// mergePresets appends the rules of the referenced presets to the tether
+// rules, skipping entries already present
+func (c *Config) mergePresets() error {
+	for _, ref := range c.Presets {
+		preset, err := LookupPreset(ref.Name)
+		if err != nil {
+			return fmt.Errorf("presets: %w", err)
+		}
+		if ref.Version != 0 && ref.Version != preset.Version {
+			log.Printf("Warning: preset %s is at version %d, the config was written for version %d; check `network-router presets show %s`",
+				preset.Name, preset.Version, ref.Version, preset.Name)
+		}
+		domains, cidrs := preset.Apply(ref)
+		c.TetherDomains = appendMissing(c.TetherDomains, domains)
+		c.TetherCIDRs = appendMissing(c.TetherCIDRs, cidrs)
+		c.appliedPresets = append(c.appliedPresets, preset.Ref())
+		log.Printf("📦 Preset %s: %d domains, %d CIDRs", preset.Ref(), len(domains), len(cidrs))
+	}
+	return nil
+}
+
+// AppliedPresets returns the presets merged by LoadConfig as "name@version"
+func (c *Config) AppliedPresets() []string {
+	return c.appliedPresets
+}
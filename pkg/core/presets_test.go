@@ -0,0 +1,69 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	presets, err := Presets()
+	if err != nil {
+		t.Fatalf("Presets failed: %v", err)
+	}
+	var names []string
+	for _, p := range presets {
+		names = append(names, p.Name)
+		if p.Version < 1 || len(p.Domains) == 0 {
+			t.Errorf("Preset %s needs a version and rules", p.Name)
+		}
+		for _, d := range p.Domains {
+			if !validDomain(strings.TrimPrefix(d, "*.")) {
+				t.Errorf("Preset %s: invalid domain %q", p.Name, d)
+			}
+		}
+	}
+	if want := []string{"gemini", "github-copilot", "google-workspace"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected presets %v, got %v", want, names)
+	}
+
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "config.yaml", `tether_domains: ['api.github.com']
+presets:
+  - github-copilot
+  - name: google-workspace
+    version: 1
+    domains: ['keep.google.com']
+    exclude_domains: ['pop.gmail.com', 'smtp.gmail.com']
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	rules := NewRuleSet(config)
+	for host, want := range map[string]bool{"proxy.individual.githubcopilot.com": true, "keep.google.com": true, "imap.gmail.com": true, "pop.gmail.com": false} {
+		if got := rules.Match(host); got != want {
+			t.Errorf("Match(%s) = %v, want %v", host, got, want)
+		}
+	}
+	if config.TetherDomains[0] != "api.github.com" || strings.Count(strings.Join(config.TetherDomains, " "), "api.github.com") != 1 {
+		t.Errorf("Expected own domains first and no duplicates, got %v", config.TetherDomains)
+	}
+
+	// The merged rules and the preset versions show up in the plan
+	router, _ := NewRouter(config, NewMockRouteManager())
+	plan := router.Plan()
+	if want := []string{"github-copilot@1", "google-workspace@1"}; !reflect.DeepEqual(plan.Presets, want) {
+		t.Errorf("Expected plan presets %v, got %v", want, plan.Presets)
+	}
+	out, err := Export(plan, ExportJSON, ExportOptions{})
+	if err != nil || !strings.Contains(string(out), `"github-copilot@1"`) || !strings.Contains(string(out), `"keep.google.com"`) {
+		t.Errorf("Expected the presets in the JSON plan, got %s (%v)", out, err)
+	}
+
+	writeTestFile(t, dir, "config.yaml", "presets: [copilot]\n")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "github-copilot") {
+		t.Errorf("Expected an unknown preset error listing the presets, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+// Resolver types accepted in the resolvers config section
+const (
+	ResolverSystem = "system"
+	ResolverServer = "server"
+	ResolverPhone  = "phone"
+	ResolverDoH    = "doh" // DNS-over-HTTPS (RFC 8484)
+	ResolverDoT    = "dot" // DNS-over-TLS (RFC 7858)
+)
+
+// Resolver resolves a domain to IPv4 addresses and the TTL of the answer
+// (0 when unknown). It is used for static domain resolution in ApplyRoutes.
+type Resolver interface {
+	Resolve(ctx context.Context, domain string) ([]string, time.Duration, error)
+}
+
+// SystemResolver resolves through whatever DNS the OS is currently using
+type SystemResolver struct{}
+
+func (SystemResolver) Resolve(ctx context.Context, domain string) ([]string, time.Duration, error) {
+	return utils.ResolveDomainWithTTL(ctx, domain)
+}
+
+// ServerResolver queries an explicit DNS server, optionally sending the
+// query through a specific interface so the answer matches what that
+// network sees.
+type ServerResolver struct {
+	Server string // host:port
+	Device string // e.g. "en8"; empty uses the routing table
+}
+
+func (s *ServerResolver) Resolve(ctx context.Context, domain string) ([]string, time.Duration, error) {
+	return utils.QueryA(ctx, s.Server, s.Device, domain)
+}
+
+// SetResolver overrides the configured resolvers for every domain
+func (r *Router) SetResolver(res Resolver) {
+	r.resolver = res
+}
+
+// ensureResolvers builds the group resolvers once per set of detected
+// interfaces, so DoH/DoT upstreams keep their connections between passes
+func (r *Router) ensureResolvers() {
+	ifaces := ""
+	if r.wifiIface != nil {
+		ifaces = r.wifiIface.DeviceName
+	}
+	if r.phoneIface != nil {
+		ifaces += "|" + r.phoneIface.DeviceName
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.groupResolvers != nil && r.resolverIfaces == ifaces {
+		return
+	}
+	r.prepareResolvers()
+	r.resolverIfaces = ifaces
+}
+
+// prepareResolvers builds the resolver for each configured group. It must
+// run after DetectInterfaces since phone/wifi resolvers need the devices.
+func (r *Router) prepareResolvers() {
+	r.closeUpstreams()
+	r.groupResolvers = make([]Resolver, len(r.config.Resolvers))
+	for i, rc := range r.config.Resolvers {
+		res, err := r.buildResolver(rc)
+		if err != nil {
+			log.Printf("⚠️  Resolver group %d (%s): %v, using system DNS", i+1, rc.Type, err)
+			res = SystemResolver{}
+		}
+		r.groupResolvers[i] = res
+	}
+}
+
+// closeResolvers drops the connections of the resolver groups once r is
+// replaced; they are rebuilt if r is used again
+func (r *Router) closeResolvers() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeUpstreams()
+	r.groupResolvers = nil
+}
+
+func (r *Router) closeUpstreams() {
+	for _, old := range r.groupResolvers {
+		if res, ok := old.(*UpstreamResolver); ok {
+			res.Upstream.Close()
+		}
+	}
+}
+
+func (r *Router) buildResolver(rc ResolverConfig) (Resolver, error) {
+	device := ""
+	switch rc.Bind {
+	case "":
+	case "phone":
+		if r.phoneIface == nil {
+			return nil, fmt.Errorf("phone interface not detected")
+		}
+		device = r.phoneIface.DeviceName
+	case "wifi":
+		if r.wifiIface == nil {
+			return nil, fmt.Errorf("wifi interface not detected")
+		}
+		device = r.wifiIface.DeviceName
+	default:
+		return nil, fmt.Errorf("unknown bind interface %q", rc.Bind)
+	}
+
+	switch rc.Type {
+	case "", ResolverSystem:
+		return SystemResolver{}, nil
+	case ResolverServer:
+		if rc.Server == "" {
+			return nil, fmt.Errorf("server is required")
+		}
+		return &ServerResolver{Server: withDNSPort(rc.Server), Device: device}, nil
+	case ResolverDoH, ResolverDoT:
+		upstream, err := NewDNSUpstream(rc, device)
+		if err != nil {
+			return nil, err
+		}
+		return &UpstreamResolver{Upstream: upstream}, nil
+	case ResolverPhone:
+		if r.phoneIface == nil {
+			return nil, fmt.Errorf("phone interface not detected")
+		}
+		server, err := utils.GetInterfaceDNSServer(r.phoneIface.DeviceName)
+		if err != nil {
+			return nil, fmt.Errorf("could not get Phone DNS server: %w", err)
+		}
+		log.Printf("Using Phone DNS server %s (via %s)", server, r.phoneIface.DeviceName)
+		return &ServerResolver{Server: withDNSPort(server), Device: r.phoneIface.DeviceName}, nil
+	default:
+		return nil, fmt.Errorf("unknown resolver type %q", rc.Type)
+	}
+}
+
+// resolverFor returns the resolver of the first group matching domain
+func (r *Router) resolverFor(domain string) Resolver {
+	if r.resolver != nil {
+		return r.resolver
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rc := range r.config.Resolvers {
+		if i >= len(r.groupResolvers) {
+			break
+		}
+		if len(rc.Domains) == 0 {
+			return r.groupResolvers[i]
+		}
+		for _, pattern := range rc.Domains {
+			if pattern == domain || matchDomain(strings.ToLower(pattern), strings.ToLower(resolveTarget(domain))) {
+				return r.groupResolvers[i]
+			}
+		}
+	}
+	return SystemResolver{}
+}
+
+// matchDomain reports whether domain matches pattern, where "*.example.com"
+// matches example.com and all of its subdomains
+func matchDomain(pattern, domain string) bool {
+	if pattern == domain {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := strings.TrimPrefix(pattern, "*") // keep the dot: e.g., ".googlevideo.com"
+		return strings.HasSuffix(domain, suffix) || domain == strings.TrimPrefix(suffix, ".")
+	}
+	return false
+}
+
+func withDNSPort(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
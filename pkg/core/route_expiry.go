@@ -0,0 +1,198 @@
+package core
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDNSTTL is used when the resolver could not report a TTL
+const defaultDNSTTL = 5 * time.Minute
+
+// ipLease tracks the DNS lifetime of a routed IP
+type ipLease struct {
+	domain    string
+	expiresAt time.Time
+	lastSeen  time.Time
+}
+
+// leaseTable holds per-IP leases and per-domain expiry for configured domains.
+// It is shared between the coordinator and DNS proxy goroutines.
+type leaseTable struct {
+	mu      sync.Mutex
+	ips     map[string]*ipLease
+	domains map[string]time.Time
+}
+
+func newLeaseTable() *leaseTable {
+	return &leaseTable{
+		ips:     make(map[string]*ipLease),
+		domains: make(map[string]time.Time),
+	}
+}
+
+// observe records that ip was returned for domain with the given (clamped) TTL
+func (t *leaseTable) observe(ip, domain string, ttl time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lease, ok := t.ips[ip]
+	if !ok {
+		lease = &ipLease{}
+		t.ips[ip] = lease
+	}
+	lease.domain = domain
+	lease.lastSeen = now
+	if exp := now.Add(ttl); exp.After(lease.expiresAt) {
+		lease.expiresAt = exp
+	}
+}
+
+// setDomainExpiry records when a configured domain needs to be re-resolved
+func (t *leaseTable) setDomainExpiry(domain string, at time.Time) {
+	t.mu.Lock()
+	t.domains[domain] = at
+	t.mu.Unlock()
+}
+
+// expiredDomains returns configured domains whose answers have expired
+func (t *leaseTable) expiredDomains(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var expired []string
+	for domain, at := range t.domains {
+		if !now.Before(at) {
+			expired = append(expired, domain)
+		}
+	}
+	return expired
+}
+
+// idleIPs returns IPs whose lease expired and that have not been seen for idle
+func (t *leaseTable) idleIPs(now time.Time, idle time.Duration) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []string
+	for ip, lease := range t.ips {
+		if now.After(lease.expiresAt) && now.Sub(lease.lastSeen) > idle {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// domainOf returns the domain an IP was last returned for
+func (t *leaseTable) domainOf(ip string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lease, ok := t.ips[ip]; ok {
+		return lease.domain
+	}
+	return ""
+}
+
+// forgetDomain stops re-resolving a domain that is no longer configured
+func (t *leaseTable) forgetDomain(domain string) {
+	t.mu.Lock()
+	delete(t.domains, domain)
+	t.mu.Unlock()
+}
+
+func (t *leaseTable) forget(ip string) {
+	t.mu.Lock()
+	delete(t.ips, ip)
+	t.mu.Unlock()
+}
+
+// clampTTL applies the configured TTL bounds
+func (r *Router) clampTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = defaultDNSTTL
+	}
+	if r.config.DNSTTLMin > 0 && ttl < r.config.DNSTTLMin {
+		ttl = r.config.DNSTTLMin
+	}
+	if r.config.DNSTTLMax > 0 && ttl > r.config.DNSTTLMax {
+		ttl = r.config.DNSTTLMax
+	}
+	return ttl
+}
+
+// RefreshExpired re-resolves configured domains whose TTL has expired and
+// retires IPs nobody has queried recently. Unlike a full refresh it only
+// adds and deletes the individual routes that changed.
+func (r *Router) RefreshExpired(ctx context.Context) error {
+	if r.phoneIface == nil {
+		return nil
+	}
+	now := time.Now()
+	changed := false
+
+	expired := r.leases.expiredDomains(now)
+	if len(expired) > 0 {
+		r.ensureResolvers()
+	}
+	for _, domain := range expired {
+		ips, ttl, err := r.resolverFor(domain).Resolve(ctx, resolveTarget(domain))
+		if err != nil {
+			// Keep existing routes, try again after the minimum TTL
+			log.Printf("⏳ Re-resolution of %s failed: %v", domain, err)
+			r.leases.setDomainExpiry(domain, now.Add(r.clampTTL(0)))
+			continue
+		}
+		ttl = r.clampTTL(ttl)
+		r.leases.setDomainExpiry(domain, now.Add(ttl))
+		for _, ip := range ips {
+			r.leases.observe(ip, domain, ttl, now)
+			target := ip + "/32"
+			if !r.registry.TryAdd(RouteEntry{Destination: target, Source: SourceStatic, Domain: domain}) {
+				r.registry.Touch(ip)
+				continue
+			}
+			if err := r.addPhoneRoute(ctx, target); err != nil {
+				log.Printf("Error adding route for %s: %v", target, err)
+				r.registry.Remove(target)
+				continue
+			}
+			log.Printf("✓ Added route for %s via Phone (%s re-resolved)\n", target, domain)
+			r.mu.Lock()
+			r.resolvedIPs = append(r.resolvedIPs, ip)
+			r.mu.Unlock()
+			changed = true
+		}
+	}
+
+	if r.config.RouteIdleTimeout > 0 {
+		for _, ip := range r.leases.idleIPs(now, r.config.RouteIdleTimeout) {
+			r.leases.forget(ip)
+			// Only IPs routed on their own can be retired; aggregated
+			// prefixes and configured CIDRs stay until the next full refresh
+			target := ip + "/32"
+			if entry, ok := r.registry.Get(target); !ok || entry.Source == SourceCIDR || entry.Source.IsProvider() {
+				continue
+			}
+			if err := r.deleteRoute(ctx, target); err != nil {
+				log.Printf("Error retiring route for %s: %v", target, err)
+				continue
+			}
+			log.Printf("✓ Retired idle route for %s\n", target)
+			r.registry.Remove(target)
+			r.mu.Lock()
+			r.resolvedIPs = slices.DeleteFunc(r.resolvedIPs, func(s string) bool { return s == ip })
+			r.mu.Unlock()
+			changed = true
+		}
+	}
+
+	if changed {
+		return r.registry.Flush()
+	}
+	return nil
+}
+
+// resolveTarget maps a configured domain to the name that is actually resolved
+func resolveTarget(domain string) string {
+	return strings.TrimPrefix(domain, "*.")
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultRouteWorkers    = 4
+	defaultRouteMaxRetries = 3
+	routeRetryBackoff      = 250 * time.Millisecond
+)
+
+// RouteFailure describes a destination that could not be added or deleted
+type RouteFailure struct {
+	Destination string `json:"destination"`
+	Error       string `json:"error"`
+	Attempts    int    `json:"attempts"`
+}
+
+// RouteReport summarises a batch of route operations
+type RouteReport struct {
+	Succeeded []string       `json:"-"`
+	Failed    []RouteFailure `json:"failed,omitempty"`
+	Duration  time.Duration  `json:"duration"`
+
+	errs []error
+}
+
+// Err returns the first failure, or nil if every operation succeeded
+func (rep *RouteReport) Err() error {
+	if len(rep.errs) == 0 {
+		return nil
+	}
+	return rep.errs[0]
+}
+
+// isRetryable reports whether a route failure may succeed on a later attempt.
+// Permission errors and cancellation are final; an unreachable gateway is
+// retried since the phone link may still be coming up.
+func isRetryable(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrPermission),
+		errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
+
+// runRouteOps applies op to every destination through a bounded worker pool,
+// retrying retryable failures with exponential backoff. A permission error
+// stops the batch since every remaining operation would fail the same way;
+// op gets the batch context, so cancelling it also kills running commands.
+func (r *Router) runRouteOps(ctx context.Context, verb string, targets []string, op func(context.Context, string) error) *RouteReport {
+	start := time.Now()
+
+	workers := r.config.RouteWorkers
+	if workers <= 0 {
+		workers = defaultRouteWorkers
+	}
+	maxRetries := r.config.RouteMaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultRouteMaxRetries
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		attempts int
+		err      error
+	}
+	results := make([]result, len(targets))
+
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for i, target := range targets {
+		g.Go(func() error {
+			attempts, err := retryRouteOp(ctx, maxRetries, func() error { return op(ctx, target) })
+			if err != nil {
+				log.Printf("Error %s route for %s (attempt %d): %v", verb, target, attempts, err)
+				if errors.Is(err, ErrPermission) {
+					cancel()
+				}
+			}
+			results[i] = result{attempts: attempts, err: err}
+			return nil
+		})
+	}
+	g.Wait()
+
+	report := &RouteReport{Duration: time.Since(start)}
+	for i, res := range results {
+		if res.err != nil {
+			report.Failed = append(report.Failed, RouteFailure{
+				Destination: targets[i],
+				Error:       res.err.Error(),
+				Attempts:    res.attempts,
+			})
+			report.errs = append(report.errs, res.err)
+			continue
+		}
+		report.Succeeded = append(report.Succeeded, targets[i])
+	}
+	return report
+}
+
+// retryRouteOp runs op up to maxRetries times, doubling the pause between
+// attempts, and returns the number of attempts made
+func retryRouteOp(ctx context.Context, maxRetries int, op func() error) (int, error) {
+	backoff := routeRetryBackoff
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return attempt - 1, err
+		}
+		err := op()
+		if err == nil || !isRetryable(err) || attempt >= maxRetries {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
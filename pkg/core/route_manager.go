@@ -1,10 +1,67 @@
 package core
 
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"network-router/pkg/utils"
+)
+
+// Routing backends accepted in route_backend
+const (
+	BackendRoute  = "route"
+	BackendPolicy = "policy"
+)
+
+// Errors returned by RouteManager implementations, matched with errors.Is
+var (
+	ErrExists     = utils.ErrExists     // Add: destination already routed
+	ErrNotFound   = utils.ErrNotFound   // Delete: destination not in the table
+	ErrNoGateway  = utils.ErrNoGateway  // Gateway or interface unreachable
+	ErrPermission = utils.ErrPermission // Not allowed to modify the routing table
+)
+
 // RouteManager defines the interface for interacting with the OS routing table.
 // This acts as a Seam to allow testing Router logic without touching the host OS.
+//
+// Add and Delete are idempotent: adding an existing route or deleting a missing
+// one succeeds. Real failures wrap ErrNoGateway, ErrPermission or the OS error.
+// Each command runs under ctx, further bounded by the manager's timeout.
 type RouteManager interface {
-	AddRoute(destination string, interfaceName string) error
-	AddRouteViaGateway(destination string, gatewayIP string) error
-	ChangeDefaultGateway(gatewayIP string) error
-	DeleteRoute(destination string) error
+	AddRoute(ctx context.Context, destination string, interfaceName string) error
+	AddRouteViaGateway(ctx context.Context, destination string, gatewayIP string) error
+	ChangeDefaultGateway(ctx context.Context, gatewayIP string) error
+	DeleteRoute(ctx context.Context, destination string) error
+}
+
+// NewRouteManager creates the routing backend selected by route_backend.
+// Linux defaults to policy routing, other systems to the route command.
+func NewRouteManager(config *Config) (RouteManager, error) {
+	backend := config.RouteBackend
+	if backend == "" {
+		backend = BackendRoute
+		if runtime.GOOS == "linux" {
+			backend = BackendPolicy
+		}
+	}
+	switch backend {
+	case BackendRoute:
+		rm := NewOSRouteManager()
+		if config.RouteOpTimeout > 0 {
+			rm.Timeout = config.RouteOpTimeout
+		}
+		return rm, nil
+	case BackendPolicy:
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("route_backend %q is only supported on Linux", backend)
+		}
+		rm := NewPolicyRouteManager(config.PolicyTable, config.PolicyRulePriority, config.PolicyFwMark)
+		if config.RouteOpTimeout > 0 {
+			rm.Timeout = config.RouteOpTimeout
+		}
+		return rm, nil
+	default:
+		return nil, fmt.Errorf("unknown route_backend %q", backend)
+	}
 }
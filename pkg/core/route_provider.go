@@ -0,0 +1,420 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultProviderSchedule = "@every 1h"
+	defaultProviderTimeout  = 30 * time.Second
+	providerRestartDelay    = 30 * time.Second // Before restarting a watch provider that exited
+	maxProviderOutput       = 16 << 20
+)
+
+// RouteProviderConfig is an external program supplying tether rules. It
+// prints one JSON document {"domains": [...], "cidrs": [...]} on stdout
+// per run; in watch mode it keeps running and prints a new document (one
+// per line) whenever its rules change.
+type RouteProviderConfig struct {
+	Name     string        `yaml:"name"`     // Group name in the rules and route sources
+	Command  []string      `yaml:"command"`  // Program and arguments, run without a shell
+	Schedule string        `yaml:"schedule"` // Cron expression or "@every 15m" (default @every 1h), after a first run at startup
+	Timeout  time.Duration `yaml:"timeout"`  // Deadline of a scheduled run (default 30s)
+	Watch    bool          `yaml:"watch"`    // Long-running program, restarted when it exits
+}
+
+// ProviderRules is the document a provider prints
+type ProviderRules struct {
+	Domains []string `json:"domains"`
+	CIDRs   []string `json:"cidrs"`
+}
+
+// ProviderStatus reports the last runs of a provider
+type ProviderStatus struct {
+	Name        string    `json:"name"`
+	Domains     int       `json:"domains"`
+	CIDRs       int       `json:"cidrs"`
+	LastRun     time.Time `json:"last_run,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// ParseProviderOutput reads a provider document. Entries are normalized
+// like imported lists; invalid ones are returned as skipped.
+func ParseProviderOutput(data []byte) (*ProviderRules, []string, error) {
+	var doc ProviderRules
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid provider output: %w", err)
+	}
+	c := newRuleCollector()
+	var skipped []string
+	for _, d := range doc.Domains {
+		if reason := c.addPattern(strings.TrimSpace(d)); reason != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", d, reason))
+		}
+	}
+	for _, cidr := range doc.CIDRs {
+		if reason := c.addCIDR(cidr); reason != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", cidr, reason))
+		}
+	}
+	return &ProviderRules{Domains: c.Domains, CIDRs: c.CIDRs}, skipped, nil
+}
+
+// ProviderManager runs the route providers, each in its own goroutine so a
+// hanging or failing provider delays nothing else. A failed run keeps the
+// provider's last good rules.
+type ProviderManager struct {
+	providers []RouteProviderConfig
+	onChange  func() // Called when the rules of a provider changed
+
+	mu      sync.Mutex
+	results map[string]*ProviderRules
+	status  map[string]*ProviderStatus
+}
+
+func NewProviderManager(providers []RouteProviderConfig, onChange func()) *ProviderManager {
+	m := &ProviderManager{
+		providers: providers,
+		onChange:  onChange,
+		results:   make(map[string]*ProviderRules),
+		status:    make(map[string]*ProviderStatus),
+	}
+	for _, p := range providers {
+		m.status[p.Name] = &ProviderStatus{Name: p.Name}
+	}
+	return m
+}
+
+// Run runs every provider until ctx is done
+func (m *ProviderManager) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	seen := make(map[string]bool)
+	for _, p := range m.providers {
+		if err := validateProvider(p, seen); err != nil {
+			log.Printf("❌ Route provider %q disabled: %v", p.Name, err)
+			m.record(p.Name, nil, err)
+			continue
+		}
+		wg.Add(1)
+		go func(p RouteProviderConfig) {
+			defer wg.Done()
+			if p.Watch {
+				m.runWatch(ctx, p)
+			} else {
+				m.runScheduled(ctx, p)
+			}
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func validateProvider(p RouteProviderConfig, seen map[string]bool) error {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("name is required")
+	case seen[p.Name]:
+		return fmt.Errorf("duplicate name")
+	case len(p.Command) == 0:
+		return fmt.Errorf("command is required")
+	}
+	seen[p.Name] = true
+	if !p.Watch && p.Schedule != "" {
+		if _, err := cron.ParseStandard(p.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", p.Schedule, err)
+		}
+	}
+	return nil
+}
+
+// runScheduled runs the provider at startup and then on its schedule
+func (m *ProviderManager) runScheduled(ctx context.Context, p RouteProviderConfig) {
+	spec := p.Schedule
+	if spec == "" {
+		spec = defaultProviderSchedule
+	}
+	schedule, _ := cron.ParseStandard(spec) // Checked by validateProvider
+	for {
+		rules, err := m.runOnce(ctx, p)
+		if ctx.Err() != nil {
+			return
+		}
+		m.record(p.Name, rules, err)
+
+		timer := time.NewTimer(time.Until(schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// runOnce runs the provider to completion within its timeout
+func (m *ProviderManager) runOnce(ctx context.Context, p RouteProviderConfig) (*ProviderRules, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultProviderTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxProviderOutput}
+	cmd.Stderr = &limitedWriter{w: &stderr, n: 64 << 10}
+	cmd.WaitDelay = time.Second // Don't wait for children holding stdout open
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+		if msg := lastLine(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return m.parse(p.Name, stdout.Bytes())
+}
+
+// runWatch keeps the provider running and applies every line it prints
+func (m *ProviderManager) runWatch(ctx context.Context, p RouteProviderConfig) {
+	for {
+		err := m.watchOnce(ctx, p)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("exited")
+		}
+		m.record(p.Name, nil, fmt.Errorf("%w, restarting in %s", err, providerRestartDelay))
+
+		timer := time.NewTimer(providerRestartDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (m *ProviderManager) watchOnce(ctx context.Context, p RouteProviderConfig) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Read through an io.Pipe closed when the program exits, so children
+	// holding its stdout can't keep us reading after that
+	pr, pw := io.Pipe()
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdout = pw
+	cmd.Stderr = &lineLogger{prefix: "🧩 " + p.Name + ": "}
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("🧩 Route provider %s started (pid %d)", p.Name, cmd.Process.Pid)
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		exited <- err
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64<<10), maxProviderOutput)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rules, err := m.parse(p.Name, line)
+		m.record(p.Name, rules, err)
+	}
+	if err := scanner.Err(); err != nil {
+		cancel() // The program can't be followed any more
+		pr.CloseWithError(err)
+		<-exited
+		return err
+	}
+	return <-exited
+}
+
+func (m *ProviderManager) parse(name string, data []byte) (*ProviderRules, error) {
+	rules, skipped, err := ParseProviderOutput(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range skipped {
+		log.Printf("Warning: route provider %s: skipped %s", name, s)
+	}
+	return rules, nil
+}
+
+// record stores the outcome of a run and reports changed rules
+func (m *ProviderManager) record(name string, rules *ProviderRules, err error) {
+	m.mu.Lock()
+	status := m.status[name]
+	status.LastRun = time.Now()
+	changed := false
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		status.LastError = ""
+		status.LastSuccess = status.LastRun
+		status.Domains, status.CIDRs = len(rules.Domains), len(rules.CIDRs)
+		changed = !reflect.DeepEqual(m.results[name], rules)
+		m.results[name] = rules
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		log.Printf("❌ Route provider %s failed, keeping its previous rules: %v", name, err)
+		return
+	}
+	if changed {
+		log.Printf("🧩 Route provider %s: %d domains, %d CIDRs", name, len(rules.Domains), len(rules.CIDRs))
+		if m.onChange != nil {
+			m.onChange()
+		}
+	}
+}
+
+// Groups returns the current rules of every provider that succeeded once
+func (m *ProviderManager) Groups() map[string]*ProviderRules {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	groups := make(map[string]*ProviderRules, len(m.results))
+	for name, rules := range m.results {
+		groups[name] = rules
+	}
+	return groups
+}
+
+// Status returns the status of every provider, sorted by name
+func (m *ProviderManager) Status() []ProviderStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]ProviderStatus, 0, len(m.status))
+	for _, s := range m.status {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// WithProviderRules returns a copy of the config with the rules of each
+// provider group merged into tether_domains and tether_cidrs, lowercased
+// and without duplicates
+func (c *Config) WithProviderRules(groups map[string]*ProviderRules) *Config {
+	merged := *c
+	merged.TetherDomains = appendMissing(nil, c.TetherDomains)
+	merged.TetherCIDRs = appendMissing(nil, c.TetherCIDRs)
+	merged.providerCIDRs = make(map[string]*CIDRSet, len(groups))
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rules := groups[name]
+		merged.TetherDomains = appendMissing(merged.TetherDomains, rules.Domains)
+		merged.TetherCIDRs = appendMissing(merged.TetherCIDRs, rules.CIDRs)
+		if set, err := ParseCIDRSet(rules.CIDRs); err == nil {
+			merged.providerCIDRs[name] = set
+		}
+	}
+	return &merged
+}
+
+// providerGroupOf returns the provider whose CIDRs hold target, if any
+func (c *Config) providerGroupOf(target string) string {
+	names := make([]string, 0, len(c.providerCIDRs))
+	for name := range c.providerCIDRs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c.providerCIDRs[name].Contains(target) {
+			return name
+		}
+	}
+	return ""
+}
+
+const providerSourcePrefix = "provider:"
+
+// ProviderSource is the route source of a provider group's CIDRs
+func ProviderSource(name string) RouteSource {
+	return RouteSource(providerSourcePrefix + name)
+}
+
+// IsProvider reports whether the route comes from a route provider
+func (s RouteSource) IsProvider() bool {
+	return strings.HasPrefix(string(s), providerSourcePrefix)
+}
+
+// limitedWriter drops what is written past n bytes
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n <= 0 {
+		return len(p), nil
+	}
+	keep := p
+	if len(keep) > l.n {
+		keep = keep[:l.n]
+	}
+	l.n -= len(keep)
+	if _, err := l.w.Write(keep); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineLogger logs every complete line written to it
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Printf("%s%s", l.prefix, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	if len(l.buf) > 64<<10 {
+		l.buf = l.buf[:0]
+	}
+	return len(p), nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
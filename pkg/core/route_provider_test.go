@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProviderManager(t *testing.T) {
+	providers := []RouteProviderConfig{
+		{Name: "inventory", Command: []string{"sh", "-c", `echo '{"domains": ["*.corp.example", "not a domain"], "cidrs": ["10.9.0.0/16"]}'`}},
+		{Name: "broken", Command: []string{"sh", "-c", "echo 'inventory API down' >&2; exit 3"}},
+		{Name: "hanging", Command: []string{"sleep", "10"}, Timeout: 200 * time.Millisecond},
+		{Name: "stream", Watch: true, Command: []string{"sh", "-c",
+			`echo '{"domains": ["a.example"]}'; sleep 0.2; echo '{"domains": ["a.example", "b.example"]}'; sleep 10`}},
+		{Name: "invalid"},
+	}
+	changes := make(chan struct{}, 10)
+	m := NewProviderManager(providers, func() { changes <- struct{}{} })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	// inventory once, stream twice; failures never report a change
+	deadline := time.After(5 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-changes:
+		case <-deadline:
+			t.Fatalf("Timed out waiting for provider updates, got %d", i)
+		}
+	}
+	// The hanging provider reports once its timeout expired
+	for ran := false; !ran; {
+		ran = true
+		for _, s := range m.Status() {
+			ran = ran && !s.LastRun.IsZero()
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Timed out waiting for every provider to run")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Run to end with the context, got %v", err)
+	}
+
+	groups := m.Groups()
+	want := map[string]*ProviderRules{
+		"inventory": {Domains: []string{"*.corp.example"}, CIDRs: []string{"10.9.0.0/16"}},
+		"stream":    {Domains: []string{"a.example", "b.example"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("Expected groups %v, got %v", want, groups)
+	}
+	errs := make(map[string]string)
+	for _, s := range m.Status() {
+		errs[s.Name] = s.LastError
+	}
+	for name, msg := range map[string]string{"broken": "inventory API down", "hanging": "timed out", "invalid": "command is required", "inventory": ""} {
+		if got := errs[name]; msg == "" && got != "" || !strings.Contains(got, msg) {
+			t.Errorf("Provider %s: expected error containing %q, got %q", name, msg, got)
+		}
+	}
+
+	// Provider CIDRs are routed under the provider's name
+	t.Setenv("TMPDIR", t.TempDir())
+	base := &Config{TetherDomains: []string{"github.com"}, TetherCIDRs: []string{"192.168.0.0/16"}}
+	config := base.WithProviderRules(groups)
+	if len(base.TetherDomains) != 1 {
+		t.Errorf("WithProviderRules must not change the base config, got %v", base.TetherDomains)
+	}
+	if want := []string{"github.com", "*.corp.example", "a.example", "b.example"}; !reflect.DeepEqual(config.TetherDomains, want) {
+		t.Errorf("Expected domains %v, got %v", want, config.TetherDomains)
+	}
+	router, _ := NewRouter(config, NewMockRouteManager())
+	sources := make(map[string]RouteSource)
+	for _, route := range router.Plan().Routes {
+		sources[route.Destination] = route.Source
+	}
+	if want := map[string]RouteSource{"10.9.0.0/16": "provider:inventory", "192.168.0.0/16": SourceCIDR}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("Expected route sources %v, got %v", want, sources)
+	}
+}
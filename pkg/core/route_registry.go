@@ -0,0 +1,261 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultFlushInterval is how often a dirty registry is written to disk
+const defaultFlushInterval = 2 * time.Second
+
+// RouteSource tells where an installed route came from
+type RouteSource string
+
+const (
+	SourceCIDR    RouteSource = "cidr"    // tether_cidrs
+	SourceStatic  RouteSource = "static"  // Resolved from tether_domains by ApplyRoutes
+	SourceDynamic RouteSource = "dynamic" // Learned from DNS answers by the proxy
+)
+
+// RouteEntry is a destination routed via Phone along with its metadata
+type RouteEntry struct {
+	Destination string      `yaml:"destination" json:"destination"`
+	Source      RouteSource `yaml:"source" json:"source"`
+	Domain      string      `yaml:"domain,omitempty" json:"domain,omitempty"`
+	AddedAt     time.Time   `yaml:"added_at" json:"added_at"`
+	LastHit     time.Time   `yaml:"last_hit" json:"last_hit"`
+}
+
+// RouteRegistry is the concurrency-safe set of installed routes, keyed by
+// destination. It is shared by the Router, the DNS proxy handlers and the
+// coordinator. Changes are persisted write-behind: mutations only mark the
+// registry dirty and Run (or an explicit Flush) writes the state file.
+type RouteRegistry struct {
+	mu      sync.RWMutex
+	entries map[string]*RouteEntry
+	cover   *CIDRSet // every address covered by an entry
+	dirty   bool
+
+	path          string
+	flushInterval time.Duration
+	flushMu       sync.Mutex // serialises writes to path
+}
+
+// NewRouteRegistry creates a registry persisted at path. Entries saved by a
+// previous run are loaded so they can still be cleaned up.
+func NewRouteRegistry(path string) *RouteRegistry {
+	reg := &RouteRegistry{
+		entries:       make(map[string]*RouteEntry),
+		cover:         NewCIDRSet(),
+		path:          path,
+		flushInterval: defaultFlushInterval,
+	}
+	if saved, err := loadState(path); err == nil {
+		for i := range saved.Entries {
+			e := saved.Entries[i]
+			reg.entries[e.Destination] = &e
+			_ = reg.cover.Add(e.Destination)
+		}
+	}
+	return reg
+}
+
+// TryAdd inserts entry unless its destination is already covered by another
+// entry. It returns false when nothing was added, so concurrent callers can
+// use it to claim a destination before installing the route.
+func (reg *RouteRegistry) TryAdd(entry RouteEntry) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.entries[entry.Destination]; ok || reg.cover.Contains(entry.Destination) {
+		return false
+	}
+	reg.put(entry)
+	return true
+}
+
+// Put inserts or replaces an entry
+func (reg *RouteRegistry) Put(entry RouteEntry) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.put(entry)
+}
+
+func (reg *RouteRegistry) put(entry RouteEntry) {
+	now := time.Now()
+	if entry.AddedAt.IsZero() {
+		entry.AddedAt = now
+	}
+	if entry.LastHit.IsZero() {
+		entry.LastHit = now
+	}
+	reg.entries[entry.Destination] = &entry
+	_ = reg.cover.Add(entry.Destination)
+	reg.dirty = true
+}
+
+// Remove deletes the entry for destination
+func (reg *RouteRegistry) Remove(destination string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.entries[destination]; !ok {
+		return
+	}
+	delete(reg.entries, destination)
+	reg.rebuildCover()
+	reg.dirty = true
+}
+
+// Reset drops every entry
+func (reg *RouteRegistry) Reset() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.entries = make(map[string]*RouteEntry)
+	reg.cover = NewCIDRSet()
+	reg.dirty = true
+}
+
+func (reg *RouteRegistry) rebuildCover() {
+	reg.cover = NewCIDRSet()
+	for dest := range reg.entries {
+		_ = reg.cover.Add(dest)
+	}
+}
+
+// Covers reports whether the IP or CIDR is already routed by some entry
+func (reg *RouteRegistry) Covers(destination string) bool {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.cover.Contains(destination)
+}
+
+// Get returns a copy of the entry for destination
+func (reg *RouteRegistry) Get(destination string) (RouteEntry, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	e, ok := reg.entries[destination]
+	if !ok {
+		return RouteEntry{}, false
+	}
+	return *e, true
+}
+
+// Touch records a hit on the entry routing ip (exact or covering prefix)
+func (reg *RouteRegistry) Touch(ip string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	now := time.Now()
+	if e, ok := reg.entries[ip+"/32"]; ok {
+		e.LastHit = now
+		return
+	}
+	for dest, e := range reg.entries {
+		if p, err := netip.ParsePrefix(dest); err == nil && p.Contains(addr) {
+			e.LastHit = now
+			return
+		}
+	}
+}
+
+// Entries returns a snapshot of all entries sorted by destination
+func (reg *RouteRegistry) Entries() []RouteEntry {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	out := make([]RouteEntry, 0, len(reg.entries))
+	for _, e := range reg.entries {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Destination < out[j].Destination })
+	return out
+}
+
+// Destinations returns the sorted list of routed destinations
+func (reg *RouteRegistry) Destinations() []string {
+	entries := reg.Entries()
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Destination)
+	}
+	return out
+}
+
+// Len returns the number of entries
+func (reg *RouteRegistry) Len() int {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return len(reg.entries)
+}
+
+// CountBySource returns the number of entries per source
+func (reg *RouteRegistry) CountBySource() map[RouteSource]int {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	counts := make(map[RouteSource]int)
+	for _, e := range reg.entries {
+		counts[e.Source]++
+	}
+	return counts
+}
+
+// Flush writes the registry to disk if it changed since the last write.
+// An empty registry removes the state file.
+func (reg *RouteRegistry) Flush() error {
+	reg.flushMu.Lock()
+	defer reg.flushMu.Unlock()
+
+	reg.mu.Lock()
+	if !reg.dirty {
+		reg.mu.Unlock()
+		return nil
+	}
+	reg.dirty = false
+	reg.mu.Unlock()
+
+	entries := reg.Entries()
+	if len(entries) == 0 {
+		if err := os.Remove(reg.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	state := ResolvedIPs{Entries: entries}
+	for _, e := range entries {
+		state.Routes = append(state.Routes, e.Destination)
+	}
+	if err := saveState(reg.path, state); err != nil {
+		reg.mu.Lock()
+		reg.dirty = true
+		reg.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes the registry periodically until ctx is done, then flushes
+// one last time
+func (reg *RouteRegistry) Run(ctx context.Context) error {
+	ticker := time.NewTicker(reg.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := reg.Flush(); err != nil {
+				log.Printf("Warning: Could not save route registry: %v", err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			if err := reg.Flush(); err != nil {
+				log.Printf("Warning: Could not save route registry: %v", err)
+			}
+		}
+	}
+}
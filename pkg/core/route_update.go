@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Reconfigure returns a router for config that takes over the interfaces,
+// gateways and DNS leases of r, and brings the installed routes in line
+// with the new rules: only added domains are resolved, and only routes
+// that entered or left the plan are added or deleted. It is used when
+// provider output or a rule file changes, where a full refresh would drop
+// every route for a moment.
+func (r *Router) Reconfigure(ctx context.Context, config *Config) (*Router, error) {
+	next := &Router{
+		config:       config,
+		wifiIface:    r.wifiIface,
+		phoneIface:   r.phoneIface,
+		wifiMatch:    r.wifiMatch,
+		phoneMatch:   r.phoneMatch,
+		wifiGateway:  r.wifiGateway,
+		phoneGateway: r.phoneGateway,
+		routeManager: r.routeManager,
+		registry:     r.registry,
+		dynamicSet:   r.dynamicSet,
+		leases:       r.leases,
+		resolver:     r.resolver,
+	}
+	if r.phoneIface == nil {
+		return next, fmt.Errorf("phone interface not detected")
+	}
+
+	oldRules, newRules := NewRuleSet(r.config), NewRuleSet(config)
+	var added []string
+	for _, domain := range newRules.Domains {
+		if !slices.Contains(oldRules.Domains, domain) {
+			added = append(added, domain)
+		}
+	}
+	removed := make(map[string]bool)
+	for _, domain := range oldRules.Domains {
+		if !slices.Contains(newRules.Domains, domain) {
+			removed[domain] = true
+			r.leases.forgetDomain(domain)
+		}
+	}
+
+	// IPs of removed domains leave the plan, those of added domains join it
+	var resolved []string
+	for _, ip := range r.resolved() {
+		if !removed[strings.ToLower(r.leases.domainOf(ip))] {
+			resolved = append(resolved, ip)
+		}
+	}
+	if len(added) > 0 {
+		next.ensureResolvers()
+		lookupCtx, cancel := context.WithTimeout(ctx, defaultResolveTimeout)
+		now := time.Now()
+		for _, res := range next.lookupDomains(lookupCtx, added, 1) {
+			if res.Error != "" {
+				log.Printf("  ✗ Failed to resolve %s: %s", res.Target, res.Error)
+				continue
+			}
+			resolved = append(resolved, res.IPs...)
+			next.leases.setDomainExpiry(res.Domain, now.Add(res.TTL))
+			for _, ip := range res.IPs {
+				next.leases.observe(ip, res.Domain, res.TTL, now)
+			}
+		}
+		cancel()
+	}
+	next.resolvedIPs = resolved
+
+	plan := next.planRoutes()
+	planned := make(map[string]bool, len(plan))
+	var toAdd []string
+	for _, target := range plan {
+		planned[target] = true
+		if _, ok := r.registry.Get(target); ok {
+			// Already installed, possibly for another source
+			r.registry.Put(next.planEntry(target))
+			continue
+		}
+		toAdd = append(toAdd, target)
+	}
+	var toDelete []string
+	for _, e := range r.registry.Entries() {
+		switch {
+		case planned[e.Destination]:
+		case e.Source != SourceDynamic:
+			toDelete = append(toDelete, e.Destination)
+		case oldRules.MatchDomain(e.Domain) && !newRules.MatchDomain(e.Domain):
+			toDelete = append(toDelete, e.Destination) // Learned for a removed domain
+		}
+	}
+
+	// Add first, so traffic moving to a wider prefix never falls back to Wi-Fi
+	report := next.runRouteOps(ctx, "adding", toAdd, next.addPhoneRoute)
+	for _, target := range report.Succeeded {
+		r.registry.Put(next.planEntry(target))
+	}
+	addedCount := len(report.Succeeded)
+	deleted := next.runRouteOps(ctx, "deleting", toDelete, next.deleteRoute)
+	for _, target := range deleted.Succeeded {
+		r.registry.Remove(target)
+	}
+	report.Succeeded = append(report.Succeeded, deleted.Succeeded...)
+	report.Failed = append(report.Failed, deleted.Failed...)
+	report.errs = append(report.errs, deleted.errs...)
+	report.Duration += deleted.Duration
+	next.setLastReport(report)
+
+	log.Printf("✓ Rules updated: %d route(s) added, %d deleted, %d failed", addedCount, len(deleted.Succeeded), len(report.Failed))
+	if err := r.registry.Flush(); err != nil {
+		log.Printf("Warning: Could not save installed routes: %v", err)
+	}
+	r.closeResolvers()
+	return next, report.Err()
+}
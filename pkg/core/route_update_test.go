@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+func TestRouterReconfigure(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	base := &Config{TetherDomains: []string{"a.example.com", "b.example.com"}, TetherCIDRs: []string{"10.0.0.0/16"}}
+	config := base.WithProviderRules(map[string]*ProviderRules{"inventory": {CIDRs: []string{"172.16.0.0/24"}}})
+
+	mockRM := NewMockRouteManager()
+	router, _ := NewRouter(config, mockRM)
+	router.phoneIface = &utils.InterfaceInfo{DeviceName: "usb0"}
+	router.phoneGateway = "192.168.42.129"
+	router.SetResolver(&fakeResolver{answers: map[string][]string{
+		"a.example.com": {"1.1.1.1"},
+		"b.example.com": {"2.2.2.2"},
+		"c.example.com": {"3.3.3.3"},
+	}})
+	if err := router.ResolveDomains(context.Background()); err != nil {
+		t.Fatalf("ResolveDomains failed: %v", err)
+	}
+	for _, target := range router.planRoutes() {
+		router.registry.Put(router.planEntry(target))
+	}
+	router.registry.Put(RouteEntry{Destination: "4.4.4.4/32", Source: SourceDynamic, Domain: "b.example.com"})
+	router.registry.Put(RouteEntry{Destination: "5.5.5.5/32", Source: SourceDynamic, Domain: "geoip.example.net"})
+
+	// b.example.com is dropped for c.example.com, the provider moves its range
+	base = &Config{TetherDomains: []string{"a.example.com", "c.example.com"}, TetherCIDRs: []string{"10.0.0.0/16"}}
+	config = base.WithProviderRules(map[string]*ProviderRules{"inventory": {CIDRs: []string{"172.16.1.0/24"}}})
+	next, err := router.Reconfigure(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Reconfigure failed: %v", err)
+	}
+
+	sort.Strings(mockRM.addedRoutes)
+	sort.Strings(mockRM.deletedRoutes)
+	if want := []string{"172.16.1.0/24", "3.3.3.3/32"}; !reflect.DeepEqual(mockRM.addedRoutes, want) {
+		t.Errorf("Expected added %v, got %v", want, mockRM.addedRoutes)
+	}
+	if want := []string{"172.16.0.0/24", "2.2.2.2/32", "4.4.4.4/32"}; !reflect.DeepEqual(mockRM.deletedRoutes, want) {
+		t.Errorf("Expected deleted %v, got %v", want, mockRM.deletedRoutes)
+	}
+	want := []string{"1.1.1.1/32", "10.0.0.0/16", "172.16.1.0/24", "3.3.3.3/32", "5.5.5.5/32"}
+	if got := next.registry.Destinations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected registry %v, got %v", want, got)
+	}
+	if e, _ := next.registry.Get("172.16.1.0/24"); e.Source != ProviderSource("inventory") {
+		t.Errorf("Expected provider source, got %q", e.Source)
+	}
+	if e, _ := next.registry.Get("3.3.3.3/32"); e.Domain != "c.example.com" {
+		t.Errorf("Expected the new domain on its route, got %+v", e)
+	}
+
+	// The removed domain is no longer re-resolved
+	for _, domain := range next.leases.expiredDomains(time.Now().Add(time.Hour)) {
+		if domain == "b.example.com" {
+			t.Errorf("Removed domain still scheduled for re-resolution")
+		}
+	}
+}
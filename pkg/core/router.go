@@ -1,19 +1,31 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"network-router/pkg/utils"
 
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
-func getResolvedIPsFilePath() string {
+const (
+	defaultResolveConcurrency = 8
+	defaultResolveTimeout     = 60 * time.Second
+)
+
+// StateFilePath returns where installed routes are persisted for cleanup
+func StateFilePath() string {
 	return filepath.Join(os.TempDir(), ".resolved_ips.yaml")
 }
 
@@ -22,10 +34,34 @@ type Router struct {
 	config       *Config
 	wifiIface    *utils.InterfaceInfo
 	phoneIface   *utils.InterfaceInfo
+	wifiMatch    string // Selector that matched wifiIface
+	phoneMatch   string // Selector that matched phoneIface
 	resolvedIPs  []string
 	wifiGateway  string
 	phoneGateway string
 	routeManager RouteManager
+	lastReport   *RouteReport
+
+	// registry holds every destination routed via Phone; it may be shared
+	// with other Router instances (see SetRegistry)
+	registry *RouteRegistry
+
+	// dynamicSet, when set, receives DNS-learned IPs instead of per-IP routes
+	dynamicSet DynamicSet
+
+	// leases tracks DNS TTLs of resolved and learned IPs
+	leases *leaseTable
+
+	lastResolution []DomainResult
+
+	// resolver overrides per-group resolvers when set (see SetResolver)
+	resolver       Resolver
+	groupResolvers []Resolver
+	resolverIfaces string // Interfaces groupResolvers were built for
+
+	// mu guards resolvedIPs, lastResolution, lastReport and the resolvers, which
+	// expiry passes, applies and Plan use from different goroutines
+	mu sync.Mutex
 }
 
 // NewRouter creates a new Router instance
@@ -36,9 +72,31 @@ func NewRouter(config *Config, rm RouteManager) (*Router, error) {
 	return &Router{
 		config:       config,
 		routeManager: rm,
+		registry:     NewRouteRegistry(StateFilePath()),
+		leases:       newLeaseTable(),
 	}, nil
 }
 
+// SetRegistry makes the router record routes in a shared registry
+func (r *Router) SetRegistry(reg *RouteRegistry) {
+	r.registry = reg
+}
+
+// SetDynamicSet makes AddDynamicRoute add learned IPs to set
+func (r *Router) SetDynamicSet(set DynamicSet) {
+	r.dynamicSet = set
+}
+
+// Registry returns the registry of installed routes
+func (r *Router) Registry() *RouteRegistry {
+	return r.registry
+}
+
+// PhoneGateway returns the gateway of the phone interface, once detected
+func (r *Router) PhoneGateway() string {
+	return r.phoneGateway
+}
+
 // DetectInterfaces detects WiFi and Phone interfaces
 func (r *Router) DetectInterfaces() error {
 	interfaces, err := utils.GetNetworkInterfaces()
@@ -46,27 +104,25 @@ func (r *Router) DetectInterfaces() error {
 		return fmt.Errorf("error getting interfaces: %w", err)
 	}
 
-	// Use defaults if not in config
-	wifiKw := r.config.WifiInterfaceKeyword
-	if wifiKw == "" {
-		wifiKw = "Wi-Fi"
-	}
-	phoneKw := r.config.PhoneInterfaceKeyword
-	if phoneKw == "" {
-		phoneKw = "iPhone USB"
+	wifi, phone, err := SelectInterfaces(r.config, interfaces)
+	if err != nil {
+		return err
 	}
-
-	r.wifiIface = utils.FindInterfaceByName(interfaces, []string{wifiKw, "Wi-Fi"})
-	r.phoneIface = utils.FindInterfaceByName(interfaces, []string{phoneKw, "iPhone USB", "iPad USB", "RNDIS"})
+	r.wifiIface, r.wifiMatch = wifi.Interface, wifi.Selector
+	r.phoneIface, r.phoneMatch = phone.Interface, phone.Selector
 
 	if r.wifiIface == nil {
-		return fmt.Errorf("could not find Wi-Fi interface (keyword: %s)", wifiKw)
+		return fmt.Errorf("could not find Wi-Fi interface (selectors: %v)", r.config.WifiSelectors())
 	}
 
 	if r.phoneIface == nil {
-		return fmt.Errorf("could not find Phone Tethering interface (keyword: %s)", phoneKw)
+		return fmt.Errorf("could not find Phone Tethering interface (selectors: %v)", r.config.PhoneSelectors())
 	}
 
+	log.Printf("Wi-Fi: %s (matched %s), Phone: %s (matched %s)", r.wifiIface.DeviceName, r.wifiMatch, r.phoneIface.DeviceName, r.phoneMatch)
+	if phone.Confidence > 0 {
+		log.Printf("Phone uplink auto-detected as %s with confidence %.0f%%", phone.Type, phone.Confidence*100)
+	}
 	return nil
 }
 
@@ -88,10 +144,22 @@ func (r *Router) GetInterfaceStatus() (wifiActive, phoneActive bool) {
 	return
 }
 
-// ResolveDomains resolves all configured domains to IPs
-func (r *Router) ResolveDomains() error {
+// DomainResult reports the outcome of resolving a single configured domain
+type DomainResult struct {
+	Domain   string        `json:"domain"`
+	Target   string        `json:"target"`
+	IPs      []string      `json:"ips,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ResolveDomains resolves all configured domains to IPs. Lookups run
+// concurrently (dns_resolve_concurrency) under an overall deadline
+// (dns_resolve_timeout); cancelling ctx abandons pending lookups.
+func (r *Router) ResolveDomains(ctx context.Context) error {
 	log.Println("Resolving tethering domains...")
-	r.resolvedIPs = []string{}
 
 	// Check if both interfaces are active - potential for DNS conflicts
 	wifiActive := r.wifiIface != nil && utils.IsInterfaceActive(r.wifiIface.DeviceName)
@@ -103,48 +171,45 @@ func (r *Router) ResolveDomains() error {
 		log.Println("   This is normal - routing will continue with successfully resolved domains and CIDRs.")
 	}
 
-	// Deduplicate domains
+	// Deduplicate domains, sorted so results are reported in a stable order
 	uniqueDomains := make(map[string]bool)
 	for _, d := range r.config.TetherDomains {
 		uniqueDomains[d] = true
 	}
+	domains := make([]string, 0, len(uniqueDomains))
+	for d := range uniqueDomains {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	r.ensureResolvers()
 
-	totalDomains := len(uniqueDomains)
-	successCount := 0
-	failedCount := 0
-	var failedDomains []string
+	// If both interfaces are active, add retry logic for DNS resolution
+	maxAttempts := 1
+	if wifiActive && phoneActive {
+		maxAttempts = 3
+	}
 
-	for domain := range uniqueDomains {
-		targetDomain := domain
-		if strings.HasPrefix(domain, "*.") {
-			targetDomain = strings.TrimPrefix(domain, "*.")
-			log.Printf("  Wildcard domain detected '%s', attempting to resolve base domain '%s'\n", domain, targetDomain)
-		}
+	timeout := r.config.DNSResolveTimeout
+	if timeout <= 0 {
+		timeout = defaultResolveTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		var ips []string
-		var err error
+	results := r.lookupDomains(ctx, domains, maxAttempts)
 
-		// If both interfaces are active, add retry logic for DNS resolution
-		if wifiActive && phoneActive {
-			maxRetries := 3
-			for attempt := 1; attempt <= maxRetries; attempt++ {
-				ips, err = utils.ResolveDomainToIPs(targetDomain)
-				if err == nil {
-					break
-				}
-				if attempt < maxRetries {
-					log.Printf("  ⏳ DNS resolution attempt %d/%d failed for %s, retrying in 2s...", attempt, maxRetries, targetDomain)
-					time.Sleep(2 * time.Second)
-				}
-			}
-		} else {
-			ips, err = utils.ResolveDomainToIPs(targetDomain)
-		}
+	totalDomains := len(domains)
+	successCount := 0
+	failedCount := 0
+	var failedDomains []string
 
-		if err != nil {
+	now := time.Now()
+	resolved := []string{}
+	for _, res := range results {
+		if res.Error != "" {
 			failedCount++
-			failedDomains = append(failedDomains, targetDomain)
-			log.Printf("  ✗ Failed to resolve %s after retries", targetDomain)
+			failedDomains = append(failedDomains, res.Target)
+			log.Printf("  ✗ Failed to resolve %s after %d attempt(s) in %s: %s", res.Target, res.Attempts, res.Duration.Round(time.Millisecond), res.Error)
 			if wifiActive && phoneActive {
 				log.Printf("    (Network conflict - this is expected when both interfaces are active)")
 				log.Printf("    Routing will continue with successfully resolved domains and CIDRs")
@@ -152,9 +217,18 @@ func (r *Router) ResolveDomains() error {
 			continue
 		}
 		successCount++
-		log.Printf("  ✓ Resolved %s -> %v\n", targetDomain, ips)
-		r.resolvedIPs = append(r.resolvedIPs, ips...)
+		log.Printf("  ✓ Resolved %s -> %v (ttl %s, %s)\n", res.Target, res.IPs, res.TTL, res.Duration.Round(time.Millisecond))
+		resolved = append(resolved, res.IPs...)
+
+		r.leases.setDomainExpiry(res.Domain, now.Add(res.TTL))
+		for _, ip := range res.IPs {
+			r.leases.observe(ip, res.Domain, res.TTL, now)
+		}
 	}
+	r.mu.Lock()
+	r.resolvedIPs = resolved
+	r.lastResolution = results
+	r.mu.Unlock()
 
 	// Summary
 	log.Printf("\n📊 DNS Resolution Summary:")
@@ -168,6 +242,9 @@ func (r *Router) ResolveDomains() error {
 
 	// Only return error if NO domains resolved successfully
 	if successCount == 0 && totalDomains > 0 {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("failed to resolve any domains (%d total): %w", totalDomains, err)
+		}
 		return fmt.Errorf("failed to resolve any domains (%d total). Check your network connectivity", totalDomains)
 	}
 
@@ -179,8 +256,76 @@ func (r *Router) ResolveDomains() error {
 	return nil
 }
 
+// lookupDomains resolves domains concurrently (dns_resolve_concurrency)
+func (r *Router) lookupDomains(ctx context.Context, domains []string, maxAttempts int) []DomainResult {
+	concurrency := r.config.DNSResolveConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultResolveConcurrency
+	}
+
+	results := make([]DomainResult, len(domains))
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, domain := range domains {
+		g.Go(func() error {
+			results[i] = r.resolveDomain(ctx, domain, maxAttempts)
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
+
+// LastResolution returns the per-domain results of the last ResolveDomains call
+func (r *Router) LastResolution() []DomainResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastResolution
+}
+
+// resolved returns a copy of the IPs resolved for configured domains
+func (r *Router) resolved() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.resolvedIPs)
+}
+
+// resolveDomain resolves one configured domain, retrying up to maxAttempts
+// times with a 2s pause unless ctx is done first
+func (r *Router) resolveDomain(ctx context.Context, domain string, maxAttempts int) DomainResult {
+	res := DomainResult{Domain: domain, Target: resolveTarget(domain)}
+	if res.Target != domain {
+		log.Printf("  Wildcard domain detected '%s', attempting to resolve base domain '%s'\n", domain, res.Target)
+	}
+
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		res.Attempts = attempt
+		var ttl time.Duration
+		res.IPs, ttl, err = r.resolverFor(domain).Resolve(ctx, res.Target)
+		if err == nil {
+			res.TTL = r.clampTTL(ttl)
+			return res
+		}
+		if attempt < maxAttempts {
+			log.Printf("  ⏳ DNS resolution attempt %d/%d failed for %s, retrying in 2s...", attempt, maxAttempts, res.Target)
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				attempt = maxAttempts
+			case <-time.After(2 * time.Second):
+			}
+		}
+	}
+	res.Error = err.Error()
+	return res
+}
+
 // ApplyRoutes applies routing rules
-func (r *Router) ApplyRoutes() error {
+func (r *Router) ApplyRoutes(ctx context.Context) error {
 	log.Println("Applying routing rules...")
 
 	// Get gateways
@@ -195,62 +340,76 @@ func (r *Router) ApplyRoutes() error {
 		log.Printf("Warning: Could not get Phone gateway IP: %v", err)
 	}
 
-	// 1. Resolve Domains BEFORE switching gateway (using current/WiFi DNS)
-	// This prevents DNS resolution issues when Phone network DNS is not working
-	log.Println("\nResolving domains (using current DNS)...")
-	if err := r.ResolveDomains(); err != nil {
+	// 1. Resolve Domains BEFORE switching gateway (using the configured
+	// resolvers, current DNS by default). This prevents DNS resolution issues
+	// when Phone network DNS is not working
+	log.Println("\nResolving domains...")
+	if err := r.ResolveDomains(ctx); err != nil {
 		log.Printf("\n❌ Critical: %v", err)
 		log.Println("Cannot continue without any resolved domains or CIDRs.")
 		return err
 	}
 
+	// Merge CIDRs and resolved IPs, aggregate and apply exclusions
+	plan := r.planRoutes()
+	resolvedCount := len(r.resolved())
+
 	// Report on what will be routed
 	log.Printf("\n📋 Routing Plan:")
 	log.Printf("   CIDRs to route: %d", len(r.config.TetherCIDRs))
-	log.Printf("   Resolved IPs to route: %d", len(r.resolvedIPs))
-	totalRoutes := len(r.config.TetherCIDRs) + len(r.resolvedIPs)
-	if totalRoutes == 0 {
+	log.Printf("   Resolved IPs to route: %d", resolvedCount)
+	if len(r.config.TetherCIDRsExclude) > 0 {
+		log.Printf("   Excluded CIDRs: %d", len(r.config.TetherCIDRsExclude))
+	}
+	if len(plan) == 0 {
 		log.Println("\n⚠️  Warning: No routes to apply (no CIDRs and no resolved IPs)")
 		log.Println("   Please check your configuration or network connectivity.")
 		return fmt.Errorf("no routes to apply")
 	}
-	log.Printf("   Total routes to apply: %d\n", totalRoutes)
+	log.Printf("   Total routes to apply: %d (optimized from %d entries)\n", len(plan), len(r.config.TetherCIDRs)+resolvedCount)
 
 	// 2. Configure routes (Skipped switching default gateway as per request)
 	// We will add specific routes via Phone interface/gateway instead.
-
-	// Route Tether CIDRs to Phone
-	for _, cidr := range r.config.TetherCIDRs {
-		if err := r.addPhoneRoute(cidr); err != nil {
-			log.Printf("Error adding route for %s: %v", cidr, err)
-		} else {
-			log.Printf("✓ Added route for %s via Phone\n", cidr)
-		}
+	report := r.runRouteOps(ctx, "adding", plan, r.addPhoneRoute)
+	r.setLastReport(report)
+	for _, target := range report.Succeeded {
+		r.registry.Put(r.planEntry(target))
 	}
+	log.Printf("✓ Added %d route(s) via Phone in %s\n", len(report.Succeeded), report.Duration.Round(time.Millisecond))
 
-	// Route Resolved Domain IPs to Phone
-	for _, ip := range r.resolvedIPs {
-		target := ip
-		if !isCIDR(target) {
-			target = ip + "/32"
-		}
-
-		if err := r.addPhoneRoute(target); err != nil {
-			log.Printf("Error adding route for IP %s: %v", target, err)
-		} else {
-			log.Printf("✓ Added route for %s via Phone\n", target)
-		}
+	// Save installed routes for later cleanup
+	if err := r.registry.Flush(); err != nil {
+		log.Printf("Warning: Could not save installed routes: %v", err)
 	}
 
-	// Save resolved IPs for later cleanup
-	if err := r.saveResolvedIPs(); err != nil {
-		log.Printf("Warning: Could not save resolved IPs: %v", err)
+	if len(report.Succeeded) == 0 {
+		return fmt.Errorf("no routes could be installed: %w", report.Err())
+	}
+	if len(report.Failed) > 0 {
+		log.Printf("⚠️  Routing configuration completed with %d failed route(s):", len(report.Failed))
+		for _, f := range report.Failed {
+			log.Printf("   ✗ %s: %s", f.Destination, f.Error)
+		}
+		return nil
 	}
 
 	log.Println("Routing configuration completed successfully!")
 	return nil
 }
 
+// LastReport returns the outcome of the last ApplyRoutes or ClearRoutes call
+func (r *Router) LastReport() *RouteReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastReport
+}
+
+func (r *Router) setLastReport(report *RouteReport) {
+	r.mu.Lock()
+	r.lastReport = report
+	r.mu.Unlock()
+}
+
 // ClearRoutes clears all routing rules
 func (r *Router) ClearRoutes() error {
 	log.Println("Cleaning up routing configuration...")
@@ -260,7 +419,7 @@ func (r *Router) ClearRoutes() error {
 		wifiGateway, err := utils.GetInterfaceGateway(r.wifiIface.DeviceName)
 		if err == nil && wifiGateway != "" {
 			log.Printf("Resetting default gateway to Wifi Gateway (%s)...\n", wifiGateway)
-			if err := r.routeManager.ChangeDefaultGateway(wifiGateway); err != nil {
+			if err := r.routeManager.ChangeDefaultGateway(context.Background(), wifiGateway); err != nil {
 				log.Printf("Failed to reset default gateway: %v", err)
 			} else {
 				log.Println("✓ Default gateway reset to Wi-Fi.")
@@ -268,50 +427,63 @@ func (r *Router) ClearRoutes() error {
 		}
 	}
 
-	// Try to load saved IPs for more accurate cleanup
-	saved, err := loadResolvedIPs()
-	if err == nil {
+	// Routes recorded in the registry (loaded from disk after a restart)
+	// give the most accurate cleanup
+	targets := r.registry.Destinations()
+	if len(targets) > 0 {
+		log.Println("Using saved IP list for cleanup...")
+	} else if saved, err := loadState(StateFilePath()); err == nil {
+		// State file written before routes were recorded with metadata;
+		// the registry replaces it below
 		log.Println("Using saved IP list for cleanup...")
-		for _, cidr := range saved.CIDRs {
-			if err := r.routeManager.DeleteRoute(cidr); err == nil {
-				log.Printf("✓ Deleted route for %s (saved)\n", cidr)
+		os.Remove(StateFilePath())
+		targets = append(targets, saved.Routes...)
+		if len(targets) == 0 {
+			targets = append(targets, saved.CIDRs...)
+			for _, ip := range saved.IPs {
+				if !isCIDR(ip) {
+					ip += "/32"
+				}
+				targets = append(targets, ip)
 			}
 		}
-		for _, ip := range saved.IPs {
-			target := ip
-			if !isCIDR(target) {
-				target = ip + "/32"
-			}
-			if err := r.routeManager.DeleteRoute(target); err == nil {
-				log.Printf("✓ Deleted route for IP %s (saved)\n", target)
-			}
+	} else {
+		// Fallback to config
+		log.Println("No saved IP list found. Falling back to current configuration...")
+
+		// Resolve domains so the plan matches what ApplyRoutes would install
+		if len(r.resolved()) == 0 {
+			r.ResolveDomains(context.Background())
 		}
-		os.Remove(getResolvedIPsFilePath())
-		log.Println("Cleanup completed from saved file!")
-		return nil
+		targets = r.planRoutes()
 	}
 
-	// Fallback to config
-	log.Println("No saved IP list found. Falling back to current configuration...")
+	// Missing routes count as deleted, anything else is a real failure
+	report := r.runRouteOps(context.Background(), "deleting", targets, r.deleteRoute)
+	r.setLastReport(report)
+	log.Printf("✓ Deleted %d route(s) in %s\n", len(report.Succeeded), report.Duration.Round(time.Millisecond))
 
-	for _, cidr := range r.config.TetherCIDRs {
-		if err := r.routeManager.DeleteRoute(cidr); err == nil {
-			log.Printf("✓ Deleted route for %s\n", cidr)
+	for _, target := range report.Succeeded {
+		r.registry.Remove(target)
+	}
+	// Keep the failed routes on record so the next clear retries them
+	for _, f := range report.Failed {
+		if _, ok := r.registry.Get(f.Destination); !ok {
+			r.registry.Put(r.planEntry(f.Destination))
 		}
 	}
+	if err := r.registry.Flush(); err != nil {
+		log.Printf("Warning: Could not save remaining routes: %v", err)
+	}
 
-	// Resolve and delete domain routes
-	if len(r.resolvedIPs) == 0 {
-		r.ResolveDomains()
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("failed to delete %d of %d route(s): %w", len(report.Failed), len(targets), errors.Join(report.errs...))
 	}
 
-	for _, ip := range r.resolvedIPs {
-		target := ip
-		if !isCIDR(target) {
-			target = ip + "/32"
-		}
-		if err := r.routeManager.DeleteRoute(target); err == nil {
-			log.Printf("✓ Deleted route for IP %s\n", target)
+	// Backends with their own table/rules remove them once all routes are gone
+	if td, ok := r.routeManager.(RouteTeardown); ok {
+		if err := td.Teardown(); err != nil {
+			return err
 		}
 	}
 
@@ -319,56 +491,138 @@ func (r *Router) ClearRoutes() error {
 	return nil
 }
 
-// AddDynamicRoute adds a route for a single IP dynamically (used by DNS Proxy)
-func (r *Router) AddDynamicRoute(ip string) error {
+// AddDynamicRoute adds a route for a single IP dynamically (used by DNS Proxy).
+// domain and ttl come from the DNS answer and keep the IP's lease alive.
+// It is safe to call from concurrent DNS handler goroutines.
+func (r *Router) AddDynamicRoute(ip string, domain string, ttl time.Duration) error {
 	target := ip
 	if !isCIDR(target) {
 		target = ip + "/32"
 	}
+	r.leases.observe(ip, domain, r.clampTTL(ttl), time.Now())
 
-	// Check if already in resolvedIPs to avoid duplicate routes
-	for _, existingIP := range r.resolvedIPs {
-		if existingIP == ip {
-			return nil // Already routed
+	// With a set the kernel matches learned IPs itself; the set entry
+	// expires with the TTL so nothing is tracked here
+	if r.dynamicSet != nil && !isCIDR(ip) {
+		if r.registry.Covers(ip) {
+			r.registry.Touch(ip)
+			return nil
 		}
+		log.Printf("🚀 Dynamic Routing: Adding %s to nftables set\n", ip)
+		return r.dynamicSet.Add(ip, r.clampTTL(ttl))
+	}
+
+	// Claim the destination; IPs already covered by an installed route
+	// (exact or aggregated) only record the hit
+	if !r.registry.TryAdd(RouteEntry{Destination: target, Source: SourceDynamic, Domain: domain}) {
+		r.registry.Touch(ip)
+		return nil // Already routed
 	}
 
 	log.Printf("🚀 Dynamic Routing: Adding route for %s via Phone\n", target)
-	if err := r.addPhoneRoute(target); err != nil {
+	if err := r.addPhoneRoute(context.Background(), target); err != nil {
+		r.registry.Remove(target)
 		return err
 	}
 
-	r.resolvedIPs = append(r.resolvedIPs, ip)
+	// Persisted write-behind by the registry
+	return nil
+}
 
-	// Incrementally update the saved file
-	return r.saveResolvedIPs()
+// RoutePhoneUplink makes sure traffic marked with policy_fwmark (by the
+// nftables set or the application cgroup) leaves via the phone, and
+// returns the phone device and gateway
+func (r *Router) RoutePhoneUplink() (device, gateway string, err error) {
+	if r.phoneIface == nil {
+		return "", "", fmt.Errorf("phone interface not detected")
+	}
+	uplink, ok := r.routeManager.(UplinkRouter)
+	if !ok {
+		return "", "", fmt.Errorf("route backend does not support marked traffic, use route_backend %q", BackendPolicy)
+	}
+	if r.phoneGateway != "" {
+		err = uplink.EnsureDefault(context.Background(), r.phoneGateway, "")
+	} else {
+		err = uplink.EnsureDefault(context.Background(), "", r.phoneIface.DeviceName)
+	}
+	return r.phoneIface.DeviceName, r.phoneGateway, err
 }
 
 // Helper functions
 
-func (r *Router) addPhoneRoute(target string) error {
+// addPhoneRoute routes target via the Phone gateway (or interface). A route
+// that already exists is what we wanted, so it counts as success.
+func (r *Router) addPhoneRoute(ctx context.Context, target string) error {
+	var err error
 	if r.phoneGateway != "" {
-		return r.routeManager.AddRouteViaGateway(target, r.phoneGateway)
+		err = r.routeManager.AddRouteViaGateway(ctx, target, r.phoneGateway)
+	} else {
+		err = r.routeManager.AddRoute(ctx, target, r.phoneIface.DeviceName)
 	}
-	return r.routeManager.AddRoute(target, r.phoneIface.DeviceName)
+	if errors.Is(err, ErrExists) {
+		return nil
+	}
+	return err
 }
 
-func (r *Router) saveResolvedIPs() error {
-	data := ResolvedIPs{
-		IPs:   r.resolvedIPs,
-		CIDRs: r.config.TetherCIDRs,
+// deleteRoute removes target, treating a missing route as success
+func (r *Router) deleteRoute(ctx context.Context, target string) error {
+	err := r.routeManager.DeleteRoute(ctx, target)
+	if errors.Is(err, ErrNotFound) {
+		return nil
 	}
+	return err
+}
+
+// planRoutes merges configured CIDRs with resolved IPs, aggregates them within
+// the configured waste budget and carves out excluded ranges. Entries that
+// cannot be parsed are routed as-is so a typo never silently drops a route.
+func (r *Router) planRoutes() []string {
+	set := NewCIDRSet()
+	var passthrough []string
+	for _, entry := range append(append([]string{}, r.config.TetherCIDRs...), r.resolved()...) {
+		if err := set.Add(entry); err != nil {
+			log.Printf("Warning: %v, routing it unoptimized", err)
+			passthrough = append(passthrough, entry)
+		}
+	}
+
+	set = set.Aggregate(r.config.AggregateWaste, r.config.AggregateMinPrefix)
+
+	exclude, err := ParseCIDRSet(r.config.TetherCIDRsExclude)
+	if err != nil {
+		log.Printf("Warning: ignoring tether_cidrs_exclude: %v", err)
+	} else {
+		set.Subtract(exclude)
+	}
+
+	return append(set.Strings(), passthrough...)
+}
+
+// planEntry builds the registry entry for a destination from the plan
+func (r *Router) planEntry(target string) RouteEntry {
+	entry := RouteEntry{Destination: target, Source: SourceStatic}
+	if group := r.config.providerGroupOf(target); group != "" {
+		entry.Source = ProviderSource(group)
+	} else if cidrs, err := ParseCIDRSet(r.config.TetherCIDRs); err == nil && cidrs.Contains(target) {
+		entry.Source = SourceCIDR
+	} else if domain := r.leases.domainOf(strings.TrimSuffix(target, "/32")); domain != "" {
+		entry.Domain = domain
+	}
+	return entry
+}
+
+func saveState(path string, data ResolvedIPs) error {
 	bytes, err := yaml.Marshal(data)
 	if err != nil {
 		return err
 	}
-	filePath := getResolvedIPsFilePath()
-	log.Printf("Saving resolved IPs to: %s", filePath)
-	return os.WriteFile(filePath, bytes, 0644)
+	log.Printf("Saving resolved IPs to: %s", path)
+	return os.WriteFile(path, bytes, 0644)
 }
 
-func loadResolvedIPs() (*ResolvedIPs, error) {
-	bytes, err := os.ReadFile(getResolvedIPsFilePath())
+func loadState(path string) (*ResolvedIPs, error) {
+	bytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
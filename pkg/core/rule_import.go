@@ -0,0 +1,313 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats accepted by import and include entries
+const (
+	FormatList    = "list"    // One domain, wildcard, IP or CIDR per line
+	FormatDnsmasq = "dnsmasq" // server=/a.com/b.com/1.1.1.1 (also address, ipset, nftset, local)
+	FormatHosts   = "hosts"   // /etc/hosts style: "<ip> <name>..."
+	FormatClash   = "clash"   // Rule lines or rule-set payloads: DOMAIN-SUFFIX,a.com / +.a.com
+	FormatSurge   = "surge"   // Same rule lines as Clash
+)
+
+// ImportFormats lists the supported formats
+var ImportFormats = []string{FormatList, FormatDnsmasq, FormatHosts, FormatClash, FormatSurge}
+
+// IncludeConfig is an include entry: a rule list in another format whose
+// domains and CIDRs are merged into the tether rules on every (re)load
+type IncludeConfig struct {
+	Path   string `yaml:"path"`   // Relative to the config file
+	Format string `yaml:"format"` // See ImportFormats (default list)
+}
+
+// mergeIncludes appends the rules of every include entry to the tether
+// rules, skipping entries already present. Relative paths are resolved
+// against dir, the directory of the config file.
+func (c *Config) mergeIncludes(dir string) error {
+	for _, inc := range c.Include {
+		path := inc.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		res, err := ImportFile(path, inc.Format)
+		if err != nil {
+			return fmt.Errorf("include %s: %w", inc.Path, err)
+		}
+		c.TetherDomains = appendMissing(c.TetherDomains, res.Domains)
+		c.TetherCIDRs = appendMissing(c.TetherCIDRs, res.CIDRs)
+		c.sourceFiles = append(c.sourceFiles, path)
+		log.Printf("📥 Included %s: %d domains, %d CIDRs, %d line(s) skipped", inc.Path, len(res.Domains), len(res.CIDRs), len(res.Skipped))
+		for _, skipped := range res.Skipped {
+			log.Printf("   skipped %s", skipped)
+		}
+	}
+	return nil
+}
+
+// appendMissing appends the items not yet in list, lowercased; entries
+// compare case-insensitively
+func appendMissing(list, items []string) []string {
+	present := entrySet(list)
+	for _, item := range items {
+		item = strings.ToLower(item)
+		if !present[item] {
+			present[item] = true
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// entrySet indexes rule entries by their lowercased form
+func entrySet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, item := range list {
+		set[strings.ToLower(item)] = true
+	}
+	return set
+}
+
+// ImportResult holds the tether rules parsed from a list. Domains use the
+// config syntax ("*.a.com" for a domain and its subdomains) and both lists
+// are free of duplicates.
+type ImportResult struct {
+	Domains []string
+	CIDRs   []string
+	Skipped []SkippedLine
+}
+
+// YAML renders the rules as tether_domains and tether_cidrs config keys
+func (r *ImportResult) YAML() ([]byte, error) {
+	return yaml.Marshal(struct {
+		TetherDomains []string `yaml:"tether_domains,omitempty"`
+		TetherCIDRs   []string `yaml:"tether_cidrs,omitempty"`
+	}{r.Domains, r.CIDRs})
+}
+
+// SkippedLine is an input line that produced no rule
+type SkippedLine struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (s SkippedLine) String() string {
+	return fmt.Sprintf("line %d: %s (%s)", s.Line, s.Text, s.Reason)
+}
+
+// ImportFile parses the rule list at path
+func ImportFile(path, format string) (*ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseRules(file, format)
+}
+
+// ParseRules parses a rule list in one of ImportFormats. Comment lines
+// ('#', '//' or ';'), blank lines and the rule-set "payload:" header are
+// ignored; anything else that gives no rule is reported in Skipped.
+func ParseRules(r io.Reader, format string) (*ImportResult, error) {
+	var parse func(line string, res *ruleCollector) string
+	switch format {
+	case "", FormatList:
+		parse = parseListLine
+	case FormatDnsmasq:
+		parse = parseDnsmasqLine
+	case FormatHosts:
+		parse = parseHostsLine
+	case FormatClash, FormatSurge:
+		parse = parseClashLine
+	default:
+		return nil, fmt.Errorf("unknown format %q (supported: %s)", format, strings.Join(ImportFormats, ", "))
+	}
+
+	res := newRuleCollector()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "payload:" || strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "//") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		// dnsmasq uses '#' as a value ("server=/corp/#"), not for comments
+		if i := strings.Index(line, " #"); i >= 0 && format != FormatDnsmasq {
+			line = strings.TrimSpace(line[:i])
+		}
+		if reason := parse(line, res); reason != "" {
+			res.Skipped = append(res.Skipped, SkippedLine{Line: n, Text: line, Reason: reason})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &res.ImportResult, nil
+}
+
+// ruleCollector accumulates normalized rules without duplicates
+type ruleCollector struct {
+	ImportResult
+	seen map[string]bool
+}
+
+func newRuleCollector() *ruleCollector {
+	return &ruleCollector{seen: make(map[string]bool)}
+}
+
+// addDomain adds name as an exact domain, or as a domain with its
+// subdomains when wildcard is set. It returns a skip reason.
+func (c *ruleCollector) addDomain(name string, wildcard bool) string {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if !validDomain(name) {
+		return "invalid domain"
+	}
+	if wildcard {
+		name = "*." + name
+	}
+	if !c.seen[name] {
+		c.seen[name] = true
+		c.Domains = append(c.Domains, name)
+	}
+	return ""
+}
+
+// addPattern adds a domain written with any wildcard notation: "*.a.com"
+// and "+.a.com" (Clash) and ".a.com" all mean a.com and its subdomains
+func (c *ruleCollector) addPattern(pattern string) string {
+	for _, prefix := range []string{"*.", "+.", "."} {
+		if rest, ok := strings.CutPrefix(pattern, prefix); ok {
+			return c.addDomain(rest, true)
+		}
+	}
+	return c.addDomain(pattern, false)
+}
+
+func (c *ruleCollector) addCIDR(entry string) string {
+	r, err := parseEntry(entry)
+	if err != nil {
+		return "only IPv4 addresses and CIDRs are supported"
+	}
+	cidr := rangeToPrefixes(r)[0].String()
+	if !c.seen[cidr] {
+		c.seen[cidr] = true
+		c.CIDRs = append(c.CIDRs, cidr)
+	}
+	return ""
+}
+
+func parseListLine(line string, c *ruleCollector) string {
+	if isCIDR(line) || isIP(line) {
+		return c.addCIDR(line)
+	}
+	return c.addPattern(line)
+}
+
+// parseDnsmasqLine reads the domains of server, ipset and nftset
+// option=/d1/d2/value lines; dnsmasq matches each domain and its
+// subdomains. address and local lines answer locally (blocklists, LAN
+// names), so their domains are not routed.
+func parseDnsmasqLine(line string, c *ruleCollector) string {
+	option, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "not an option"
+	}
+	switch strings.TrimPrefix(strings.TrimSpace(option), "--") {
+	case "server", "ipset", "nftset":
+	case "address", "local":
+		return "answered locally by dnsmasq, not forwarded"
+	default:
+		return "option without domains"
+	}
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	if len(parts) < 3 || parts[0] != "" {
+		return "option without domains"
+	}
+	for _, domain := range parts[1 : len(parts)-1] {
+		if domain == "" || domain == "#" {
+			continue
+		}
+		if reason := c.addDomain(strings.TrimPrefix(domain, "*."), true); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// parseHostsLine adds each name of a hosts entry as an exact domain
+func parseHostsLine(line string, c *ruleCollector) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !isIP(fields[0]) {
+		return "not a hosts entry"
+	}
+	for _, name := range fields[1:] {
+		if name == "localhost" || strings.HasPrefix(name, "ip6-") || name == "broadcasthost" {
+			continue
+		}
+		if reason := c.addDomain(name, false); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// parseClashLine reads "TYPE,value[,policy]" rules and the bare entries of
+// rule-set payloads ("- '+.a.com'", "- 10.0.0.0/8")
+func parseClashLine(line string, c *ruleCollector) string {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+	line = strings.Trim(line, `'"`)
+	typ, rest, ok := strings.Cut(line, ",")
+	if !ok {
+		return parseListLine(line, c)
+	}
+	value, _, _ := strings.Cut(rest, ",")
+	value = strings.TrimSpace(value)
+	switch strings.ToUpper(strings.TrimSpace(typ)) {
+	case "DOMAIN":
+		return c.addDomain(value, false)
+	case "DOMAIN-SUFFIX", "HOST-SUFFIX":
+		return c.addDomain(value, true)
+	case "DOMAIN-WILDCARD":
+		return c.addPattern(value)
+	case "IP-CIDR", "IP-CIDR6":
+		return c.addCIDR(value)
+	default:
+		return "unsupported rule type " + typ
+	}
+}
+
+func isIP(s string) bool {
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// validDomain accepts host names made of letters, digits, '-' and '_'
+// labels; IP addresses are not domains
+func validDomain(name string) bool {
+	if name == "" || len(name) > 253 || isIP(name) {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, ch := range label {
+			if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	cases := []struct {
+		format  string
+		input   string
+		domains []string
+		cidrs   []string
+		skipped int
+	}{
+		{FormatList, "# comment\ngithub.com\n*.Example.com.\n+.example.com\n.example.com\n10.0.0.0/8\n1.2.3.4\nbad domain\n", []string{"github.com", "*.example.com"}, []string{"10.0.0.0/8", "1.2.3.4/32"}, 1},
+		{FormatDnsmasq, "server=/github.com/githubusercontent.com/1.1.1.1\nserver=/corp.example/#\nnftset=/github.com/4#inet#fw#tether4\ncache-size=1000\n", []string{"*.github.com", "*.githubusercontent.com", "*.corp.example"}, nil, 1},
+		{FormatHosts, "127.0.0.1 localhost\n140.82.112.3 github.com api.github.com # pinned\n::1 ip6-localhost\n", []string{"github.com", "api.github.com"}, nil, 0},
+		{FormatClash, "payload:\n  - DOMAIN-SUFFIX,googleapis.com,Phone\n  - DOMAIN,gemini.google.com\n  - '+.gstatic.com'\n  - IP-CIDR,172.217.0.0/16,no-resolve\n  - DOMAIN-KEYWORD,google\n", []string{"*.googleapis.com", "gemini.google.com", "*.gstatic.com"}, []string{"172.217.0.0/16"}, 1},
+		{FormatSurge, "// Surge ruleset\nDOMAIN-SUFFIX,githubcopilot.com\nIP-CIDR6,2001:db8::/32\n", []string{"*.githubcopilot.com"}, nil, 1},
+	}
+	for _, tc := range cases {
+		res, err := ParseRules(strings.NewReader(tc.input), tc.format)
+		if err != nil {
+			t.Fatalf("%s: ParseRules failed: %v", tc.format, err)
+		}
+		if !reflect.DeepEqual(res.Domains, tc.domains) || !reflect.DeepEqual(res.CIDRs, tc.cidrs) {
+			t.Errorf("%s: expected %v %v, got %v %v", tc.format, tc.domains, tc.cidrs, res.Domains, res.CIDRs)
+		}
+		if len(res.Skipped) != tc.skipped {
+			t.Errorf("%s: expected %d skipped line(s), got %v", tc.format, tc.skipped, res.Skipped)
+		}
+	}
+
+	// Blocklist and LAN lines answer locally, their domains are not routed
+	res, err := ParseRules(strings.NewReader("address=/ads.example/0.0.0.0\nlocal=/lan/\nserver=/github.com/1.1.1.1\n"), FormatDnsmasq)
+	if err != nil {
+		t.Fatalf("ParseRules failed: %v", err)
+	}
+	if !reflect.DeepEqual(res.Domains, []string{"*.github.com"}) || len(res.Skipped) != 2 || !strings.Contains(res.Skipped[0].Reason, "locally") {
+		t.Errorf("Expected address and local lines skipped, got %v skipped %v", res.Domains, res.Skipped)
+	}
+	if _, err := ParseRules(strings.NewReader(""), "adblock"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestAppendMissing(t *testing.T) {
+	got := appendMissing([]string{"GitHub.com"}, []string{"github.com", "Keep.Google.com", "keep.google.com"})
+	if want := []string{"GitHub.com", "keep.google.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got = withoutEntries(got, []string{"github.COM"}); !reflect.DeepEqual(got, []string{"keep.google.com"}) {
+		t.Errorf("Expected the entry removed regardless of case, got %v", got)
+	}
+	config := (&Config{TetherDomains: []string{"Corp.Example", "corp.example"}}).WithProviderRules(map[string]*ProviderRules{
+		"a": {Domains: []string{"API.corp.example"}},
+		"b": {Domains: []string{"api.corp.example", "CORP.example"}},
+	})
+	if want := []string{"corp.example", "api.corp.example"}; !reflect.DeepEqual(config.TetherDomains, want) {
+		t.Errorf("Expected provider domains merged once, got %v", config.TetherDomains)
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "config.yaml", "tether_domains: ['github.com']\ninclude:\n  - path: copilot.conf\n    format: dnsmasq\n")
+	writeTestFile(t, dir, "copilot.conf", "server=/githubcopilot.com/1.1.1.1\n")
+
+	config, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if want := []string{"github.com", "*.githubcopilot.com"}; !reflect.DeepEqual(config.TetherDomains, want) {
+		t.Errorf("Expected %v, got %v", want, config.TetherDomains)
+	}
+
+	// A reload re-reads the included file
+	writeTestFile(t, dir, "copilot.conf", "server=/githubcopilot.com/github.com/1.1.1.1\nnftset=/copilot-proxy.example/4#inet#fw#tether4\n")
+	config, err = LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if want := []string{"github.com", "*.githubcopilot.com", "*.github.com", "*.copilot-proxy.example"}; !reflect.DeepEqual(config.TetherDomains, want) {
+		t.Errorf("Expected %v after reload, got %v", want, config.TetherDomains)
+	}
+
+	writeTestFile(t, dir, "config.yaml", "include:\n  - path: missing.txt\n")
+	if _, err := LoadConfig(filepath.Join(dir, "config.yaml")); err == nil {
+		t.Error("Expected an error for a missing include")
+	}
+}
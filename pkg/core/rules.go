@@ -0,0 +1,64 @@
+package core
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+)
+
+// RuleSet is the compiled form of the tether rules, deciding per
+// destination whether it goes via the phone: tether_domains patterns and
+// tether_cidrs minus tether_cidrs_exclude
+type RuleSet struct {
+	Domains []string // Lowercase patterns; "*.example.com" includes example.com
+	CIDRs   *CIDRSet
+}
+
+// NewRuleSet compiles the rules of config. Entries that cannot be parsed
+// are logged and skipped.
+func NewRuleSet(config *Config) *RuleSet {
+	rs := &RuleSet{CIDRs: NewCIDRSet()}
+	seen := make(map[string]bool)
+	for _, d := range config.TetherDomains {
+		d = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+		if d != "" && !seen[d] {
+			seen[d] = true
+			rs.Domains = append(rs.Domains, d)
+		}
+	}
+	for _, entry := range config.TetherCIDRs {
+		if err := rs.CIDRs.Add(entry); err != nil {
+			log.Printf("Warning: %v, ignoring it", err)
+		}
+	}
+	for _, entry := range config.TetherCIDRsExclude {
+		if err := rs.CIDRs.Remove(entry); err != nil {
+			log.Printf("Warning: %v, ignoring it", err)
+		}
+	}
+	return rs
+}
+
+// Match reports whether host (a name or an IP) goes via the phone
+func (rs *RuleSet) Match(host string) bool {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return rs.MatchIP(host)
+	}
+	return rs.MatchDomain(host)
+}
+
+// MatchDomain reports whether domain matches one of the domain patterns
+func (rs *RuleSet) MatchDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, pattern := range rs.Domains {
+		if matchDomain(pattern, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchIP reports whether ip is in the routed CIDRs
+func (rs *RuleSet) MatchIP(ip string) bool {
+	return rs.CIDRs.Contains(ip)
+}
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"network-router/pkg/utils"
+)
+
+const (
+	defaultProxyListen = "127.0.0.1:1080"
+	proxyDialTimeout   = 15 * time.Second
+	proxyHandshake     = 30 * time.Second // Deadline for the client's request
+)
+
+// SOCKS5 constants (RFC 1928)
+const (
+	socksVersion     = 0x05
+	socksNoAuth      = 0x00
+	socksNoMethod    = 0xff
+	socksCmdConnect  = 0x01
+	socksAtypIPv4    = 0x01
+	socksAtypDomain  = 0x03
+	socksAtypIPv6    = 0x04
+	socksOK          = 0x00
+	socksFailure     = 0x01
+	socksUnreachable = 0x04
+	socksRefused     = 0x05
+	socksBadCommand  = 0x07
+	socksBadAddress  = 0x08
+)
+
+// TetherProxy is a local SOCKS5 and HTTP CONNECT proxy (both on one port)
+// applying the tether rules per connection: destinations matching
+// tether_domains or tether_cidrs are dialed from the phone interface and
+// its source address, everything else directly. It changes no routes, so
+// apps can opt in per profile and it works where routes can't be installed.
+type TetherProxy struct {
+	config *Config
+	phone  func() (string, error) // Current phone device
+
+	mu       sync.Mutex
+	rules    *RuleSet
+	listener net.Listener
+}
+
+// NewTetherProxy creates the proxy; phone returns the device of the active
+// phone uplink, or an error while there is none
+func NewTetherProxy(config *Config, phone func() (string, error)) *TetherProxy {
+	return &TetherProxy{
+		config: config,
+		rules:  NewRuleSet(config),
+		phone:  phone,
+	}
+}
+
+// Start listens on proxy_listen and serves in the background; a bind
+// failure is returned
+func (p *TetherProxy) Start() error {
+	addr := p.config.ProxyListen
+	if addr == "" {
+		addr = defaultProxyListen
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("proxy listen on %s: %w", addr, err)
+	}
+	p.mu.Lock()
+	p.listener = listener
+	p.mu.Unlock()
+
+	log.Printf("🔀 Tether proxy (SOCKS5 + HTTP CONNECT) listening on %s", listener.Addr())
+	go p.serve(listener)
+	return nil
+}
+
+// SetRules replaces the rules applied to new connections, e.g. after a
+// config reload
+func (p *TetherProxy) SetRules(rules *RuleSet) {
+	p.mu.Lock()
+	p.rules = rules
+	p.mu.Unlock()
+}
+
+func (p *TetherProxy) currentRules() *RuleSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rules
+}
+
+// Addr returns the listening address, nil when stopped
+func (p *TetherProxy) Addr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+// Stop closes the listener; established connections run until closed
+func (p *TetherProxy) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	log.Println("🛑 Stopping tether proxy...")
+	err := p.listener.Close()
+	p.listener = nil
+	return err
+}
+
+func (p *TetherProxy) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("❌ Tether proxy stopped: %v", err)
+			}
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+// handle tells the protocols apart by the first byte: SOCKS5 starts with
+// its version, HTTP with a method name
+func (p *TetherProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(proxyHandshake))
+
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	var upstream net.Conn
+	if first[0] == socksVersion {
+		upstream, err = p.handshakeSOCKS(conn, br)
+	} else {
+		upstream, err = p.handshakeHTTP(conn, br)
+	}
+	if err != nil {
+		log.Printf("⚠️ Tether proxy: %v", err)
+		return
+	}
+	defer upstream.Close()
+
+	conn.SetDeadline(time.Time{})
+	relay(conn, br, upstream)
+}
+
+// handshakeSOCKS serves the method negotiation and a CONNECT request
+func (p *TetherProxy) handshakeSOCKS(conn net.Conn, br *bufio.Reader) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return nil, err
+	}
+	method := byte(socksNoMethod)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil || method == socksNoMethod {
+		return nil, fmt.Errorf("SOCKS client offers no supported auth method")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(br, request); err != nil {
+		return nil, err
+	}
+	if request[1] != socksCmdConnect {
+		socksReply(conn, socksBadCommand)
+		return nil, fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socksAtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		n, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		socksReply(conn, socksBadAddress)
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	upstream, err := p.dial(addr)
+	if err != nil {
+		code := byte(socksFailure)
+		var opErr *net.OpError
+		switch {
+		case errors.Is(err, errNoPhone):
+			code = socksUnreachable
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			code = socksRefused
+		}
+		socksReply(conn, code)
+		return nil, err
+	}
+	if err := socksReply(conn, socksOK); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+// socksReply answers a request; the bound address is not meaningful here
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// handshakeHTTP serves a CONNECT request; plain HTTP proxying is not
+// supported, clients tunnel through CONNECT for https anyway
+func (p *TetherProxy) handshakeHTTP(conn net.Conn, br *bufio.Reader) (net.Conn, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\nAllow: CONNECT\r\nConnection: close\r\n\r\n")
+		return nil, fmt.Errorf("unsupported HTTP method %s", req.Method)
+	}
+	upstream, err := p.dial(req.Host)
+	if err != nil {
+		status := "502 Bad Gateway"
+		if errors.Is(err, errNoPhone) {
+			status = "503 Service Unavailable"
+		}
+		io.WriteString(conn, "HTTP/1.1 "+status+"\r\nConnection: close\r\n\r\n")
+		return nil, err
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+var errNoPhone = errors.New("phone uplink not available")
+
+// dial connects to addr (host:port) via the phone if it matches the tether
+// rules and directly otherwise. Names are only resolved here when CIDR
+// rules need the address; the connection then uses the matched address.
+func (p *TetherProxy) dial(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proxyDialTimeout)
+	defer cancel()
+
+	rules := p.currentRules()
+	viaPhone := rules.Match(host)
+	if !viaPhone && net.ParseIP(host) == nil && !rules.CIDRs.IsEmpty() {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 && rules.MatchIP(ips[0].String()) {
+			viaPhone = true
+			addr = net.JoinHostPort(ips[0].String(), port)
+		}
+	}
+
+	if !viaPhone {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	if p.phone == nil {
+		return nil, fmt.Errorf("%s: %w", addr, errNoPhone)
+	}
+	device, err := p.phone()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", addr, errNoPhone, err)
+	}
+	source, err := utils.InterfaceIPv4(device)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", addr, errNoPhone, err)
+	}
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: source},
+		Control:   utils.InterfaceDialControl(device),
+	}
+	log.Printf("🔀 Tether proxy: %s via Phone (%s)", addr, device)
+	return dialer.DialContext(ctx, "tcp4", addr)
+}
+
+// relay copies both ways until either side is done; buffered bytes the
+// client sent after its request are forwarded first
+func relay(client net.Conn, br *bufio.Reader, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, br)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRuleSet(t *testing.T) {
+	rs := NewRuleSet(&Config{
+		TetherDomains:      []string{"*.Example.com", "github.com", "github.com"},
+		TetherCIDRs:        []string{"10.0.0.0/8", "bogus"},
+		TetherCIDRsExclude: []string{"10.1.0.0/16"},
+	})
+	cases := map[string]bool{
+		"example.com":     true,
+		"api.example.com": true,
+		"github.com.":     true,
+		"api.github.com":  false,
+		"10.2.3.4":        true,
+		"10.1.2.3":        false, // Excluded
+		"192.168.1.1":     false,
+	}
+	for host, want := range cases {
+		if got := rs.Match(host); got != want {
+			t.Errorf("Match(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if len(rs.Domains) != 2 {
+		t.Errorf("Expected duplicate domains dropped, got %v", rs.Domains)
+	}
+}
+
+func TestTetherProxy(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	proxy := NewTetherProxy(&Config{
+		TetherDomains: []string{"*.phone.test"},
+		TetherCIDRs:   []string{"10.0.0.0/8"},
+		ProxyListen:   "127.0.0.1:0",
+	}, func() (string, error) { return "", fmt.Errorf("no phone") })
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer proxy.Stop()
+
+	dialProxy := func() net.Conn {
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	expectEcho := func(conn net.Conn, r io.Reader) {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("Expected echo through the proxy, got %q, %v", buf, err)
+		}
+	}
+	socks := func(conn net.Conn, atyp byte, addr []byte, port uint16) byte {
+		req := append([]byte{5, 1, 0, 5, 1, 0, atyp}, addr...)
+		req = append(req, byte(port>>8), byte(port))
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, 12)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply[3]
+	}
+	echoPort := uint16(echo.Addr().(*net.TCPAddr).Port)
+
+	// Not matching the rules: direct
+	conn := dialProxy()
+	if code := socks(conn, 1, []byte{127, 0, 0, 1}, echoPort); code != 0 {
+		t.Fatalf("SOCKS CONNECT to %s failed with %d", echo.Addr(), code)
+	}
+	expectEcho(conn, conn)
+	conn.Close()
+
+	conn = dialProxy()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n\r\n", echo.Addr())
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("HTTP CONNECT failed: %v %v", resp, err)
+	}
+	expectEcho(conn, br)
+	conn.Close()
+
+	// Matching the rules while the phone is down: refused, never direct
+	conn = dialProxy()
+	name := "api.phone.test"
+	if code := socks(conn, 3, append([]byte{byte(len(name))}, name...), 443); code != socksUnreachable {
+		t.Errorf("Expected host unreachable for %s, got %d", name, code)
+	}
+	conn.Close()
+
+	conn = dialProxy()
+	fmt.Fprintf(conn, "CONNECT 10.1.2.3:443 HTTP/1.1\r\nHost: 10.1.2.3:443\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a CIDR match without phone, got %v %v", resp, err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,106 @@
+// Package utilstest provides test doubles for the utils package: a command
+// runner replaying outputs recorded on real machines.
+package utilstest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeRunner replays recorded command outputs instead of executing
+// anything; install it with utils.SetRunner. It lets the detection, gateway
+// and route code be tested on any OS against outputs captured on a real Mac.
+type FakeRunner struct {
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	calls     []string
+}
+
+type fakeResponse struct {
+	output []byte
+	err    error
+}
+
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{responses: make(map[string]fakeResponse)}
+}
+
+// On records the output (and error, if any) returned for the command line
+// cmd, e.g. "ipconfig getoption en0 router"
+func (f *FakeRunner) On(cmd string, output string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[cmd] = fakeResponse{output: []byte(output), err: err}
+}
+
+// LoadTranscript records every command of a transcript file. Each command
+// starts with a "$ <command line>" header followed by its output; a
+// "! <message>" line makes the command fail with that error:
+//
+//	$ ipconfig getoption en0 router
+//	192.168.1.1
+//	$ sudo route -n delete 10.0.0.0/8
+//	route: writing to routing socket: not in table
+//	! exit status 1
+func (f *FakeRunner) LoadTranscript(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var cmd string
+	var output strings.Builder
+	var cmdErr error
+	flush := func() {
+		if cmd != "" {
+			f.On(cmd, output.String(), cmdErr)
+		}
+		output.Reset()
+		cmdErr = nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "$ "):
+			flush()
+			cmd = strings.TrimSpace(strings.TrimPrefix(line, "$ "))
+		case strings.HasPrefix(line, "! "):
+			cmdErr = errors.New(strings.TrimPrefix(line, "! "))
+		case cmd != "":
+			output.WriteString(line)
+			output.WriteString("\n")
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+func (f *FakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, cmd)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp, ok := f.responses[cmd]
+	if !ok {
+		return nil, fmt.Errorf("fake runner: no recorded output for %q", cmd)
+	}
+	return resp.output, resp.err
+}
+
+// Calls returns the command lines run so far, in order
+func (f *FakeRunner) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// AppCgroup is a cgroup v2 whose sockets get Mark from an nftables rule, so
+// the policy routing rule for the mark sends all traffic of the processes
+// in it via the phone. Sockets of other cgroups (e.g. systemd units) can be
+// marked as well, see MarkCgroups. Processes are moved in through
+// cgroup.procs and keep the cgroup across exec.
+type AppCgroup struct {
+	Root  string // cgroup2 mount point
+	Path  string // Relative to Root, e.g. network-router/phone
+	Table string // inet table owned by network-router
+	Mark  uint32
+}
+
+func NewAppCgroup(path, table string, mark uint32) *AppCgroup {
+	return &AppCgroup{Root: DefaultCgroupRoot, Path: strings.Trim(path, "/"), Table: table, Mark: mark}
+}
+
+// Dir returns the cgroup directory
+func (g *AppCgroup) Dir() string {
+	return filepath.Join(g.Root, g.Path)
+}
+
+// Setup creates the cgroup and (re)creates the table marking its sockets
+// and masquerading their packets (see masqueradeMarked). The cgroup must
+// exist before the rule is loaded, nft resolves the path.
+func (g *AppCgroup) Setup(ctx context.Context) error {
+	if err := os.MkdirAll(g.Dir(), 0755); err != nil {
+		return fmt.Errorf("cgroup %s: %w", g.Path, err)
+	}
+	// Adding then deleting the table makes the script idempotent
+	script := fmt.Sprintf("add table inet %[1]s; delete table inet %[1]s; "+
+		"add table inet %[1]s; "+
+		"add chain inet %[1]s output { type route hook output priority mangle; }; "+
+		"add chain inet %[1]s extra; "+
+		"%[2]s; "+
+		"add rule inet %[1]s output jump extra; "+
+		"%[3]s",
+		g.Table, g.markRule("output", g.Path), masqueradeMarked(g.Table, g.Mark))
+	output, err := runRouteCmd(ctx, "sudo", "nft", script)
+	if err != nil {
+		return newRouteError("add", "nftables table "+g.Table, output, err)
+	}
+	return nil
+}
+
+// MarkCgroups replaces the set of other cgroups (paths relative to Root)
+// whose sockets are marked too
+func (g *AppCgroup) MarkCgroups(ctx context.Context, paths []string) error {
+	script := fmt.Sprintf("flush chain inet %s extra", g.Table)
+	for _, p := range paths {
+		script += "; " + g.markRule("extra", p)
+	}
+	output, err := runRouteCmd(ctx, "sudo", "nft", script)
+	if err != nil {
+		return newRouteError("change", "nftables chain "+g.Table+" extra", output, err)
+	}
+	return nil
+}
+
+// markRule matches sockets created in the cgroup at path or below it
+func (g *AppCgroup) markRule(chain, path string) string {
+	path = strings.Trim(path, "/")
+	level := strings.Count(path, "/") + 1
+	return fmt.Sprintf("add rule inet %s %s socket cgroupv2 level %d \"%s\" meta mark set 0x%x",
+		g.Table, chain, level, path, g.Mark)
+}
+
+// AddProcess moves pid, with all its threads, into the cgroup
+func (g *AppCgroup) AddProcess(pid int) error {
+	return writeCgroupProcs(g.Dir(), pid)
+}
+
+// Processes lists the PIDs in the cgroup
+func (g *AppCgroup) Processes() ([]int, error) {
+	file, err := os.Open(filepath.Join(g.Dir(), "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pids []int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, scanner.Err()
+}
+
+// Teardown deletes the table, moves remaining processes back to the root
+// cgroup and removes the cgroup
+func (g *AppCgroup) Teardown(ctx context.Context) error {
+	output, err := runRouteCmd(ctx, "sudo", "nft", "delete", "table", "inet", g.Table)
+	if err != nil {
+		err = newRouteError("delete", "nftables table "+g.Table, output, err)
+	}
+	pids, _ := g.Processes()
+	for _, pid := range pids {
+		_ = writeCgroupProcs(g.Root, pid) // Best effort, the process may be gone
+	}
+	// Remove the cgroup and the parents we created, stopping at the first
+	// one still in use
+	for dir := g.Dir(); dir != g.Root && strings.HasPrefix(dir, g.Root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return err
+}
+
+func writeCgroupProcs(dir string, pid int) error {
+	err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+	if err != nil {
+		return fmt.Errorf("move process %d to cgroup %s: %w", pid, dir, err)
+	}
+	return nil
+}
+
+// ProcessCgroup returns the cgroup v2 path of pid, relative to the cgroup
+// root, from <procRoot>/<pid>/cgroup
+func ProcessCgroup(procRoot string, pid int) (string, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return strings.Trim(path, "/"), nil
+		}
+	}
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+}
+
+// UnitCgroup returns the cgroup path of a running systemd unit, relative
+// to the cgroup root; it is empty when the unit is not running
+func UnitCgroup(ctx context.Context, unit string) (string, error) {
+	output, err := runCmd(ctx, "systemctl", "show", "--property", "ControlGroup", "--value", unit)
+	if err != nil {
+		return "", fmt.Errorf("systemctl show %s: %w: %s", unit, err, strings.TrimSpace(string(output)))
+	}
+	return strings.Trim(strings.TrimSpace(string(output)), "/"), nil
+}
@@ -0,0 +1,36 @@
+//go:build darwin
+
+package utils
+
+import (
+	"net"
+	"strings"
+	"syscall"
+)
+
+// InterfaceDialControl returns a net.Dialer Control function that scopes a
+// socket to the given interface (IP_BOUND_IF), so packets leave through it
+// regardless of the routing table. An empty device returns nil.
+func InterfaceDialControl(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		iface, err := net.InterfaceByName(device)
+		if err != nil {
+			return err
+		}
+		var sockErr error
+		err = c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_BOUND_IF, iface.Index)
+			} else {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_BOUND_IF, iface.Index)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
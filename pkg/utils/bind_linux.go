@@ -0,0 +1,24 @@
+//go:build linux
+
+package utils
+
+import "syscall"
+
+// InterfaceDialControl returns a net.Dialer Control function that binds a
+// socket to the given interface (SO_BINDTODEVICE), so packets leave through
+// it regardless of the routing table. An empty device returns nil.
+func InterfaceDialControl(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.BindToDevice(int(fd), device)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
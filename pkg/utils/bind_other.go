@@ -0,0 +1,19 @@
+//go:build !darwin && !linux
+
+package utils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// InterfaceDialControl is not supported on this platform; binding to a
+// device fails the dial instead of silently using the default route.
+func InterfaceDialControl(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("binding to interface %s is not supported on this platform", device)
+	}
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// FileWatcher polls files for changes of their size or modification time.
+// Polling needs no platform support and copes with editors that replace
+// files instead of writing them in place.
+type FileWatcher struct {
+	Interval time.Duration
+	Files    func() []string // Files to watch, asked again after every change
+
+	stamps map[string]fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	missing bool
+}
+
+// Watch calls onChange whenever one of the files changes, appears or
+// disappears, until ctx is done
+func (w *FileWatcher) Watch(ctx context.Context, onChange func()) error {
+	w.stamps = w.snapshot()
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if w.Changed() {
+				onChange()
+				w.stamps = w.snapshot()
+			}
+		}
+	}
+}
+
+// Changed reports whether a file differs from the last snapshot and takes
+// a new one
+func (w *FileWatcher) Changed() bool {
+	current := w.snapshot()
+	changed := len(current) != len(w.stamps)
+	for path, stamp := range current {
+		if prev, ok := w.stamps[path]; !ok || prev != stamp {
+			changed = true
+		}
+	}
+	w.stamps = current
+	return changed
+}
+
+func (w *FileWatcher) snapshot() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, path := range w.Files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			stamps[path] = fileStamp{missing: true}
+			continue
+		}
+		stamps[path] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+	return stamps
+}
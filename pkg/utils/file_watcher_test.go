@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcherChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goog.json")
+	if err := os.WriteFile(path, []byte(`{"prefixes": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := &FileWatcher{Files: func() []string { return []string{path} }}
+	w.Changed()
+	if w.Changed() {
+		t.Error("Expected no change for an untouched file")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"prefixes": [{"ipv4Prefix": "8.8.4.0/24"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Error("Expected a change after rewriting the file")
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Error("Expected a change of the modification time")
+	}
+
+	os.Remove(path)
+	if !w.Changed() {
+		t.Error("Expected a change after removing the file")
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// IPRule is a Linux policy routing rule sending matching traffic to a table
+type IPRule struct {
+	To       string // Destination prefix; empty for fwmark rules
+	FwMark   uint32 // Packet mark; 0 for destination rules
+	Table    int
+	Priority int
+}
+
+func (r IPRule) args() []string {
+	var args []string
+	if r.To != "" {
+		args = append(args, "to", r.To)
+	}
+	if r.FwMark != 0 {
+		args = append(args, "fwmark", fmt.Sprintf("0x%x", r.FwMark))
+	}
+	args = append(args, "lookup", strconv.Itoa(r.Table))
+	if r.Priority > 0 {
+		args = append(args, "priority", strconv.Itoa(r.Priority))
+	}
+	return args
+}
+
+func (r IPRule) String() string {
+	if r.To != "" {
+		return r.To
+	}
+	return fmt.Sprintf("fwmark 0x%x", r.FwMark)
+}
+
+// AddIPRule adds a policy routing rule. The kernel accepts duplicate rules,
+// so callers must avoid adding the same rule twice.
+func AddIPRule(ctx context.Context, rule IPRule) error {
+	output, err := runRouteCmd(ctx, append([]string{"sudo", "ip", "rule", "add"}, rule.args()...)...)
+	if err != nil {
+		return newRouteError("add", "rule "+rule.String(), output, err)
+	}
+	return nil
+}
+
+// DeleteIPRule deletes one policy routing rule; a missing rule is ErrNotFound
+func DeleteIPRule(ctx context.Context, rule IPRule) error {
+	output, err := runRouteCmd(ctx, append([]string{"sudo", "ip", "rule", "del"}, rule.args()...)...)
+	if err != nil {
+		return newRouteError("delete", "rule "+rule.String(), output, err)
+	}
+	return nil
+}
+
+// ReplaceTableDefault points the default route of a routing table at a
+// gateway (or a device when gatewayIP is empty) in a single atomic change
+func ReplaceTableDefault(ctx context.Context, table int, gatewayIP, device string) error {
+	args := []string{"sudo", "ip", "route", "replace", "default"}
+	if gatewayIP != "" {
+		args = append(args, "via", gatewayIP)
+	}
+	if device != "" {
+		args = append(args, "dev", device)
+	}
+	args = append(args, "table", strconv.Itoa(table))
+	output, err := runRouteCmd(ctx, args...)
+	if err != nil {
+		return newRouteError("change", fmt.Sprintf("default table %d", table), output, err)
+	}
+	return nil
+}
+
+// FlushTable removes every route of a routing table
+func FlushTable(ctx context.Context, table int) error {
+	output, err := runRouteCmd(ctx, "sudo", "ip", "route", "flush", "table", strconv.Itoa(table))
+	if err != nil {
+		return newRouteError("delete", fmt.Sprintf("table %d", table), output, err)
+	}
+	return nil
+}
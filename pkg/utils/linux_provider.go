@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tetherDrivers are the kernel drivers used by phones sharing their
+// connection over USB
+var tetherDrivers = map[string]string{
+	"ipheth":     "iPhone USB",
+	"rndis_host": "RNDIS",
+	"cdc_ether":  "USB Ethernet (CDC)",
+	"cdc_ncm":    "USB Ethernet (NCM)",
+}
+
+// rtfGateway is RTF_GATEWAY from linux/route.h
+const rtfGateway = 0x2
+
+// LinuxProvider discovers interfaces from /sys/class/net, their addresses
+// over netlink and gateways from /proc/net/route
+type LinuxProvider struct {
+	SysClassNet  string // default /sys/class/net
+	ProcNetRoute string // default /proc/net/route
+
+	// addrs lists the addresses of a device; tests replace it
+	addrs func(deviceName string) ([]netip.Prefix, error)
+}
+
+func NewLinuxProvider() *LinuxProvider {
+	return &LinuxProvider{
+		SysClassNet:  "/sys/class/net",
+		ProcNetRoute: "/proc/net/route",
+		addrs:        netlinkAddrs,
+	}
+}
+
+// Interfaces lists physical network devices. Names follow the macOS hardware
+// port names ("Wi-Fi", "iPhone USB", "RNDIS") so keyword matching keeps working.
+func (p *LinuxProvider) Interfaces() ([]InterfaceInfo, error) {
+	entries, err := os.ReadDir(p.SysClassNet)
+	if err != nil {
+		return nil, err
+	}
+	var interfaces []InterfaceInfo
+	for _, entry := range entries {
+		dev := entry.Name()
+		dir := filepath.Join(p.SysClassNet, dev)
+		// Virtual devices (lo, bridges, veth, tun) have no backing device
+		if _, err := os.Stat(filepath.Join(dir, "device")); err != nil {
+			continue
+		}
+		iface := InterfaceInfo{
+			DeviceName: dev,
+			MacAddress: readSysfs(dir, "address"),
+		}
+		if target, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil {
+			iface.Driver = filepath.Base(target)
+		}
+		iface.Kind, iface.Name = classifyLinuxDevice(dir, iface.Driver)
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces, nil
+}
+
+// classifyLinuxDevice derives kind and display name from sysfs and the driver
+func classifyLinuxDevice(dir, driver string) (InterfaceKind, string) {
+	if name, ok := tetherDrivers[driver]; ok {
+		return KindTether, name
+	}
+	// cfg80211 devices link their phy; older wext drivers have wireless/
+	for _, name := range []string{"phy80211", "wireless"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			return KindWifi, "Wi-Fi"
+		}
+	}
+	return KindWired, "Ethernet"
+}
+
+// IsActive reports whether the device is up and has an IPv4 address
+func (p *LinuxProvider) IsActive(deviceName string) bool {
+	switch readSysfs(filepath.Join(p.SysClassNet, deviceName), "operstate") {
+	case "up", "unknown": // "unknown" is common for USB tether drivers
+	default:
+		return false
+	}
+	addrs, err := p.addrs(deviceName)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if a.Addr().Is4() {
+			return true
+		}
+	}
+	return false
+}
+
+// Gateway returns the default gateway routed through deviceName
+func (p *LinuxProvider) Gateway(deviceName string) (string, error) {
+	file, err := os.Open(p.ProcNetRoute)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	gateway, err := parseProcNetRoute(file, deviceName)
+	if err != nil {
+		return "", err
+	}
+	if gateway == "" {
+		return "", fmt.Errorf("no gateway found for interface %s", deviceName)
+	}
+	return gateway, nil
+}
+
+// DNSServer returns the first IPv4 DNS server of deviceName as configured by
+// DHCP, asking systemd-resolved first, then NetworkManager
+func (p *LinuxProvider) DNSServer(deviceName string) (string, error) {
+	var errs []string
+	for _, cmd := range [][]string{
+		{"resolvectl", "dns", deviceName},
+		{"nmcli", "-g", "IP4.DNS", "device", "show", deviceName},
+	} {
+		output, err := runCmd(context.Background(), cmd[0], cmd[1:]...)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", cmd[0], err))
+			continue
+		}
+		if server := firstIPv4(string(output)); server != "" {
+			return server, nil
+		}
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("no DNS server found for interface %s (%s)", deviceName, strings.Join(errs, "; "))
+	}
+	return "", fmt.Errorf("no DNS server found for interface %s", deviceName)
+}
+
+// firstIPv4 returns the first IPv4 address in resolvectl ("Link 5 (usb0):
+// 192.168.42.129 fe80::1") or nmcli ("192.168.42.129 | 8.8.8.8") output
+func firstIPv4(output string) string {
+	for _, field := range strings.FieldsFunc(output, func(r rune) bool { return r == ' ' || r == '|' || r == '\n' || r == '\t' }) {
+		if addr, err := netip.ParseAddr(field); err == nil && addr.Is4() {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+// parseProcNetRoute finds the default route of deviceName in /proc/net/route
+// format, where addresses are little-endian hex
+func parseProcNetRoute(r io.Reader, deviceName string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != deviceName || fields[1] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfGateway == 0 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		var a [4]byte
+		binary.BigEndian.PutUint32(a[:], binary.LittleEndian.Uint32(raw))
+		return netip.AddrFrom4(a).String(), nil
+	}
+	return "", scanner.Err()
+}
+
+// netlinkAddrs lists device addresses; on Linux the net package queries
+// them over an RTM_GETADDR netlink request
+func netlinkAddrs(deviceName string) ([]netip.Prefix, error) {
+	iface, err := net.InterfaceByName(deviceName)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var out []netip.Prefix
+	for _, a := range addrs {
+		if p, err := netip.ParsePrefix(a.String()); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
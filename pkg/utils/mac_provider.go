@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+)
+
+// MacProvider discovers interfaces with the macOS networksetup, ifconfig and
+// ipconfig commands (run through the current Runner)
+type MacProvider struct{}
+
+func (MacProvider) Interfaces() ([]InterfaceInfo, error) {
+	output, err := runCmd(context.Background(), "networksetup", "-listallhardwareports")
+	if err != nil {
+		return nil, err
+	}
+	return ParseHardwarePorts(string(output)), nil
+}
+
+func (MacProvider) IsActive(deviceName string) bool {
+	output, err := runCmd(context.Background(), "ifconfig", deviceName)
+	if err != nil {
+		return false
+	}
+	return hasIPv4Address(string(output))
+}
+
+func (MacProvider) DNSServer(deviceName string) (string, error) {
+	// ipconfig getoption <deviceName> domain_name_server
+	server, err := getDHCPOption(deviceName, "domain_name_server")
+	if err != nil {
+		return "", err
+	}
+	if server == "" {
+		return "", fmt.Errorf("no DNS server found for interface %s", deviceName)
+	}
+	return server, nil
+}
+
+func (MacProvider) Gateway(deviceName string) (string, error) {
+	// ipconfig getoption <deviceName> router
+	gateway, err := getDHCPOption(deviceName, "router")
+	if err != nil {
+		return "", err
+	}
+	if gateway == "" {
+		return "", fmt.Errorf("no gateway found for interface %s", deviceName)
+	}
+	return gateway, nil
+}
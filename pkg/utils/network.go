@@ -5,9 +5,10 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 // InterfaceInfo holds information about a network interface
@@ -15,18 +16,19 @@ type InterfaceInfo struct {
 	Name       string // e.g., "Wi-Fi"
 	DeviceName string // e.g., "en0"
 	MacAddress string
+	Kind       InterfaceKind
+	Driver     string // kernel driver, when the provider knows it (Linux)
 }
 
-// GetNetworkInterfaces processes 'networksetup -listallhardwareports' to find interfaces
+// GetNetworkInterfaces lists network interfaces using the current provider
 func GetNetworkInterfaces() ([]InterfaceInfo, error) {
-	cmd := exec.Command("networksetup", "-listallhardwareports")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
+	return currentProvider().Interfaces()
+}
 
+// ParseHardwarePorts parses the output of 'networksetup -listallhardwareports'
+func ParseHardwarePorts(output string) []InterfaceInfo {
 	var interfaces []InterfaceInfo
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner := bufio.NewScanner(strings.NewReader(output))
 
 	var currentInterface InterfaceInfo
 
@@ -50,7 +52,10 @@ func GetNetworkInterfaces() ([]InterfaceInfo, error) {
 		interfaces = append(interfaces, currentInterface)
 	}
 
-	return interfaces, nil
+	for i := range interfaces {
+		interfaces[i].Kind = classifyHardwarePort(interfaces[i].Name)
+	}
+	return interfaces
 }
 
 // FindInterfaceByName searches for a specific interface by common keywords
@@ -67,7 +72,13 @@ func FindInterfaceByName(interfaces []InterfaceInfo, keywords []string) *Interfa
 
 // ResolveDomainToIPs resolves a domain name to a list of IPs
 func ResolveDomainToIPs(domain string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	return lookupIPv4(context.Background(), domain)
+}
+
+// lookupIPv4 resolves a domain through the system resolver, giving up after 5s
+// or when ctx is done
+func lookupIPv4(ctx context.Context, domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", domain)
@@ -92,3 +103,67 @@ func ResolveDomainToIPs(domain string) ([]string, error) {
 
 	return ipStrings, nil
 }
+
+// ResolveDomainWithTTL resolves a domain to IPv4 addresses with the system
+// resolver, so scoped resolvers (macOS /etc/resolver, VPN split DNS) apply,
+// along with the smallest TTL of the answer set (0 = unknown). The system
+// resolver does not report TTLs; they are read from a direct query to the
+// servers of /etc/resolv.conf.
+func ResolveDomainWithTTL(ctx context.Context, domain string) ([]string, time.Duration, error) {
+	ips, err := lookupIPv4(ctx, domain)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return ips, 0, nil
+	}
+	for _, server := range config.Servers {
+		if _, ttl, err := QueryA(ctx, net.JoinHostPort(server, config.Port), "", domain); err == nil {
+			return ips, ttl, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return ips, 0, nil
+}
+
+// QueryA sends an A query for domain to server (host:port) and returns the
+// IPv4 answers with their smallest TTL. If device is set the query is sent
+// through that interface only.
+func QueryA(ctx context.Context, server, device, domain string) ([]string, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	c := &dns.Client{
+		Timeout: 5 * time.Second,
+		Dialer: &net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: InterfaceDialControl(device),
+		},
+	}
+
+	resp, _, err := c.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DNS query to %s failed: %v", server, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, 0, fmt.Errorf("DNS resolution failed: %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	var ipStrings []string
+	var minTTL uint32
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			ipStrings = append(ipStrings, a.A.String())
+			if len(ipStrings) == 1 || a.Hdr.Ttl < minTTL {
+				minTTL = a.Hdr.Ttl
+			}
+		}
+	}
+	if len(ipStrings) == 0 {
+		return nil, 0, fmt.Errorf("no IPv4 addresses found")
+	}
+	return ipStrings, time.Duration(minTTL) * time.Second, nil
+}
@@ -1,22 +1,50 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"net"
 	"strings"
 )
 
 // GetInterfaceGateway retrieves the router/gateway IP for a given interface
 func GetInterfaceGateway(deviceName string) (string, error) {
-	// ipconfig getoption <deviceName> router
-	cmd := exec.Command("ipconfig", "getoption", deviceName, "router")
-	output, err := cmd.Output()
+	return currentProvider().Gateway(deviceName)
+}
+
+// GetInterfaceDNSServer retrieves the DNS server learned via DHCP on a given interface
+func GetInterfaceDNSServer(deviceName string) (string, error) {
+	return currentProvider().DNSServer(deviceName)
+}
+
+func getDHCPOption(deviceName, option string) (string, error) {
+	output, err := runCmd(context.Background(), "ipconfig", "getoption", deviceName, option)
 	if err != nil {
 		return "", err
 	}
-	gateway := strings.TrimSpace(string(output))
-	if gateway == "" {
-		return "", fmt.Errorf("no gateway found for interface %s", deviceName)
+	return parseOptionValue(string(output)), nil
+}
+
+// parseOptionValue parses the output of 'ipconfig getoption', which is the
+// bare value or nothing when the option is not set
+func parseOptionValue(output string) string {
+	return strings.TrimSpace(output)
+}
+
+// InterfaceIPv4 returns the first IPv4 address of a device
+func InterfaceIPv4(device string) (net.IP, error) {
+	iface, err := net.InterfaceByName(device)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4(), nil
+		}
 	}
-	return gateway, nil
+	return nil, fmt.Errorf("no IPv4 address on %s", device)
 }
@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"network-router/pkg/internal/utilstest"
+
+	"github.com/miekg/dns"
+)
+
+// useTranscript replays a recorded transcript for the duration of the test
+func useTranscript(t *testing.T, path string) *utilstest.FakeRunner {
+	t.Helper()
+	fake := utilstest.NewFakeRunner()
+	if err := fake.LoadTranscript(path); err != nil {
+		t.Fatalf("LoadTranscript failed: %v", err)
+	}
+	prevRunner := SetRunner(fake)
+	prevProvider := SetProvider(MacProvider{})
+	t.Cleanup(func() {
+		SetRunner(prevRunner)
+		SetProvider(prevProvider)
+	})
+	return fake
+}
+
+func TestDetectionFromRecordedOutput(t *testing.T) {
+	useTranscript(t, "testdata/darwin_tether.txt")
+
+	interfaces, err := GetNetworkInterfaces()
+	if err != nil {
+		t.Fatalf("GetNetworkInterfaces failed: %v", err)
+	}
+	var devices []string
+	for _, iface := range interfaces {
+		devices = append(devices, iface.DeviceName)
+	}
+	if want := []string{"en4", "bridge0", "en0", "en1", "en8"}; !reflect.DeepEqual(devices, want) {
+		t.Errorf("Expected devices %v, got %v", want, devices)
+	}
+
+	phone := FindInterfaceByName(interfaces, []string{"iPhone USB"})
+	if phone == nil || phone.DeviceName != "en8" || phone.MacAddress != "5e:5b:35:8c:2d:64" {
+		t.Fatalf("Unexpected phone interface: %+v", phone)
+	}
+
+	if !IsInterfaceActive("en0") || !IsInterfaceActive("en8") {
+		t.Errorf("Expected en0 and en8 to be active")
+	}
+	if IsInterfaceActive("en4") {
+		t.Errorf("en4 has only an IPv6 link-local address and should be inactive")
+	}
+
+	if gw, err := GetInterfaceGateway("en8"); err != nil || gw != "172.20.10.1" {
+		t.Errorf("Expected gateway 172.20.10.1, got %q (%v)", gw, err)
+	}
+	if _, err := GetInterfaceGateway("en4"); err == nil {
+		t.Errorf("Expected an error for an interface without a router option")
+	}
+}
+
+func TestRouteCommandsFromRecordedOutput(t *testing.T) {
+	fake := useTranscript(t, "testdata/darwin_tether.txt")
+
+	if err := AddRouteViaGateway(t.Context(), "10.10.0.0/16", "172.20.10.1"); err != nil {
+		t.Errorf("AddRouteViaGateway failed: %v", err)
+	}
+	if err := AddRouteViaGateway(t.Context(), "10.20.0.0/16", "172.20.10.1"); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists, got %v", err)
+	}
+	if err := DeleteRoute(t.Context(), "10.20.0.0/16"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	want := []string{
+		"sudo route -n add 10.10.0.0/16 172.20.10.1",
+		"sudo route -n add 10.20.0.0/16 172.20.10.1",
+		"sudo route -n delete 10.20.0.0/16",
+	}
+	if !reflect.DeepEqual(fake.Calls(), want) {
+		t.Errorf("Expected calls %v, got %v", want, fake.Calls())
+	}
+}
+
+func TestLinuxProvider(t *testing.T) {
+	p := &LinuxProvider{
+		SysClassNet:  "testdata/linux/sys/class/net",
+		ProcNetRoute: "testdata/linux/proc_net_route",
+		addrs: func(dev string) ([]netip.Prefix, error) {
+			switch dev {
+			case "usb0":
+				return []netip.Prefix{netip.MustParsePrefix("192.168.43.20/24")}, nil
+			case "wlp2s0":
+				return []netip.Prefix{netip.MustParsePrefix("192.168.1.50/24"), netip.MustParsePrefix("fe80::1/64")}, nil
+			case "enx0a5c81d4c0ee":
+				return []netip.Prefix{netip.MustParsePrefix("fe80::2/64")}, nil
+			}
+			return nil, nil
+		},
+	}
+
+	interfaces, err := p.Interfaces()
+	if err != nil {
+		t.Fatalf("Interfaces failed: %v", err)
+	}
+	got := map[string]InterfaceInfo{}
+	for _, iface := range interfaces {
+		got[iface.DeviceName] = iface
+	}
+	// lo and docker0 are virtual and skipped
+	want := map[string]InterfaceInfo{
+		"enp3s0":          {Name: "Ethernet", DeviceName: "enp3s0", MacAddress: "54:e1:ad:7c:0b:19", Kind: KindWired, Driver: "r8169"},
+		"enx0a5c81d4c0ee": {Name: "iPhone USB", DeviceName: "enx0a5c81d4c0ee", MacAddress: "0a:5c:81:d4:c0:ee", Kind: KindTether, Driver: "ipheth"},
+		"usb0":            {Name: "RNDIS", DeviceName: "usb0", MacAddress: "b6:2e:91:5a:c3:07", Kind: KindTether, Driver: "rndis_host"},
+		"wlp2s0":          {Name: "Wi-Fi", DeviceName: "wlp2s0", MacAddress: "8c:8d:28:4f:31:a2", Kind: KindWifi, Driver: "iwlwifi"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// A name that doesn't exist on Linux falls through to the kind
+	wifi, sel, err := SelectInterface(interfaces, []InterfaceSelector{{Name: "AirPort"}, {Kind: KindWifi}}, nil)
+	if err != nil || wifi == nil || wifi.DeviceName != "wlp2s0" || sel.String() != "kind=wifi" {
+		t.Errorf("Expected wlp2s0 matched by kind=wifi, got %+v (%v, %v)", wifi, sel, err)
+	}
+
+	cases := map[string]bool{
+		"usb0":            true,  // operstate unknown, has IPv4
+		"wlp2s0":          true,  // up, has IPv4
+		"enx0a5c81d4c0ee": false, // up, IPv6 link-local only
+		"enp3s0":          false, // down
+	}
+	for dev, active := range cases {
+		if p.IsActive(dev) != active {
+			t.Errorf("IsActive(%s) = %v, want %v", dev, !active, active)
+		}
+	}
+
+	if gw, err := p.Gateway("usb0"); err != nil || gw != "192.168.43.1" {
+		t.Errorf("Expected usb0 gateway 192.168.43.1, got %q (%v)", gw, err)
+	}
+	if gw, err := p.Gateway("wlp2s0"); err != nil || gw != "192.168.1.1" {
+		t.Errorf("Expected wlp2s0 gateway 192.168.1.1, got %q (%v)", gw, err)
+	}
+	if _, err := p.Gateway("enp3s0"); err == nil {
+		t.Errorf("Expected no gateway for enp3s0")
+	}
+
+	// DHCP DNS: systemd-resolved first, NetworkManager when it isn't running
+	fake := utilstest.NewFakeRunner()
+	fake.On("resolvectl dns usb0", "Link 5 (usb0): fe80::1%usb0 192.168.43.1\n", nil)
+	fake.On("resolvectl dns wlp2s0", "Failed to get global data: Unit dbus-org.freedesktop.resolve1.service not found.\n", errors.New("exit status 1"))
+	fake.On("nmcli -g IP4.DNS device show wlp2s0", "192.168.1.1 | 8.8.8.8\n", nil)
+	fake.On("resolvectl dns enp3s0", "Link 2 (enp3s0):\n", nil)
+	fake.On("nmcli -g IP4.DNS device show enp3s0", "\n", nil)
+	prevRunner := SetRunner(fake)
+	defer SetRunner(prevRunner)
+	for dev, want := range map[string]string{"usb0": "192.168.43.1", "wlp2s0": "192.168.1.1", "enp3s0": ""} {
+		server, err := p.DNSServer(dev)
+		if server != want || (want == "") != (err != nil) {
+			t.Errorf("DNSServer(%s) = %q, %v; want %q", dev, server, err, want)
+		}
+	}
+}
+
+func TestSelectInterface(t *testing.T) {
+	interfaces := []InterfaceInfo{
+		{Name: "Wi-Fi", DeviceName: "en0", MacAddress: "3c:22:fb:a1:5e:09", Kind: KindWifi},
+		{Name: "iPhone USB", DeviceName: "en8", MacAddress: "5e:5b:35:8c:2d:64", Kind: KindTether},
+		{Name: "iPhone USB 2", DeviceName: "en9", MacAddress: "9a:10:77:02:4e:51", Kind: KindTether},
+		{Name: "USB 10/100/1000 LAN", DeviceName: "en5", MacAddress: "00:e0:4c:68:01:9f", Kind: KindWired, Driver: "r8152"},
+	}
+	active := func(dev string) bool { return dev == "en9" }
+
+	cases := []struct {
+		selectors []InterfaceSelector
+		want      string
+		matchedBy string
+	}{
+		{[]InterfaceSelector{{Device: "en8"}}, "en8", "device=en8"},
+		{[]InterfaceSelector{{Device: "en7"}, {MAC: "9A-10-77"}}, "en9", "mac=9A-10-77"},
+		{[]InterfaceSelector{{Regex: `^iPhone USB \d$`}}, "en9", `regex=^iPhone USB \d$`},
+		{[]InterfaceSelector{{Driver: "r8152"}}, "en5", "driver=r8152"},
+		// Both phones match; the active one wins
+		{[]InterfaceSelector{{Name: "iphone"}}, "en9", "name=iphone"},
+		// All fields must match
+		{[]InterfaceSelector{{Kind: KindTether, MAC: "5e:5b:35:8c:2d:64"}}, "en8", "mac=5e:5b:35:8c:2d:64,kind=tether"},
+		{[]InterfaceSelector{{Kind: KindWifi, Device: "en8"}}, "", ""},
+	}
+	for _, tc := range cases {
+		iface, sel, err := SelectInterface(interfaces, tc.selectors, active)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.selectors, err)
+		}
+		if tc.want == "" {
+			if iface != nil {
+				t.Errorf("%v: expected no match, got %s", tc.selectors, iface.DeviceName)
+			}
+			continue
+		}
+		if iface == nil || iface.DeviceName != tc.want || sel.String() != tc.matchedBy {
+			t.Errorf("%v: expected %s matched by %s, got %+v by %v", tc.selectors, tc.want, tc.matchedBy, iface, sel)
+		}
+	}
+
+	if _, _, err := SelectInterface(interfaces, []InterfaceSelector{{Regex: "("}}, nil); err == nil {
+		t.Errorf("Expected an error for an invalid regex")
+	}
+}
+
+func TestQueryAMinTTL(t *testing.T) {
+	// An answer with TTL 0 must not be taken for "no TTL yet"
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		for i, ttl := range []uint32{0, 300} {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   net.IPv4(10, 0, 0, byte(i+1)),
+			})
+		}
+		w.WriteMsg(resp)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	ips, ttl, err := QueryA(context.Background(), pc.LocalAddr().String(), "", "example.com")
+	if err != nil {
+		t.Fatalf("QueryA failed: %v", err)
+	}
+	if len(ips) != 2 || ttl != 0 {
+		t.Errorf("Expected 2 IPs with TTL 0, got %v with %v", ips, ttl)
+	}
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// nfnetlink constants (linux/netfilter/nfnetlink.h, nf_tables.h)
+const (
+	nfnlSubsysNFTables = 10
+	nfnlMsgBatchBegin  = 0x10
+	nfnlMsgBatchEnd    = 0x11
+	nftMsgNewSetElem   = 12
+	nftMsgDelSetElem   = 14
+	nfprotoINet        = 1
+
+	nlmFRequest = 0x1
+	nlmFAck     = 0x4
+	nlmFCreate  = 0x400
+	nlaFNested  = 0x8000
+
+	nftaSetElemListTable    = 1
+	nftaSetElemListSet      = 2
+	nftaSetElemListElements = 3
+	nftaListElem            = 1
+	nftaSetElemKey          = 1
+	nftaSetElemTimeout      = 4
+	nftaDataValue           = 1
+)
+
+// NFTSet is a named nftables set of IPv4 destinations with per-element
+// timeouts, like dnsmasq's nftset. Packets to an address in the set get
+// Mark, which a policy routing rule sends via the phone. The table, set and
+// mark rules are created with the nft command; elements are added over
+// netlink so a DNS answer is routed without spawning a process.
+type NFTSet struct {
+	Table string // inet table owned by network-router
+	Name  string
+	Mark  uint32
+
+	mu  sync.Mutex
+	seq uint32
+}
+
+// nftSend sends netlink batches to the kernel; tests replace it
+var nftSend = sendNFTBatch
+
+func NewNFTSet(table, name string, mark uint32) *NFTSet {
+	return &NFTSet{Table: table, Name: name, Mark: mark}
+}
+
+// Setup (re)creates the table with an empty set and the rules marking
+// locally generated and forwarded packets to its addresses
+func (s *NFTSet) Setup(ctx context.Context) error {
+	mark := fmt.Sprintf("0x%x", s.Mark)
+	// Adding then deleting the table makes the script idempotent
+	script := fmt.Sprintf("add table inet %[1]s; delete table inet %[1]s; "+
+		"add table inet %[1]s; "+
+		"add set inet %[1]s %[2]s { type ipv4_addr; flags timeout; }; "+
+		"add chain inet %[1]s output { type route hook output priority mangle; }; "+
+		"add rule inet %[1]s output ip daddr @%[2]s meta mark set %[3]s; "+
+		"add chain inet %[1]s prerouting { type filter hook prerouting priority mangle; }; "+
+		"add rule inet %[1]s prerouting ip daddr @%[2]s meta mark set %[3]s; "+
+		"%[4]s",
+		s.Table, s.Name, mark, masqueradeMarked(s.Table, s.Mark))
+	output, err := runRouteCmd(ctx, "sudo", "nft", script)
+	if err != nil {
+		return newRouteError("add", "nftables table "+s.Table, output, err)
+	}
+	return nil
+}
+
+// masqueradeMarked returns the nft commands adding a nat chain to table
+// that masquerades packets carrying mark. A local socket picks its source
+// address at connect() time from the main table, before the packet is
+// marked and rerouted, so without it packets would leave the phone link
+// with the Wi-Fi address.
+func masqueradeMarked(table string, mark uint32) string {
+	return fmt.Sprintf("add chain inet %[1]s postrouting { type nat hook postrouting priority srcnat; }; "+
+		"add rule inet %[1]s postrouting meta mark 0x%[2]x masquerade", table, mark)
+}
+
+// Teardown deletes the table with its set and rules
+func (s *NFTSet) Teardown(ctx context.Context) error {
+	output, err := runRouteCmd(ctx, "sudo", "nft", "delete", "table", "inet", s.Table)
+	if err != nil {
+		return newRouteError("delete", "nftables table "+s.Table, output, err)
+	}
+	return nil
+}
+
+// Add inserts ip into the set, expiring after ttl (at least one second).
+// An element already in the set is replaced, so every DNS answer restarts
+// its timeout.
+func (s *NFTSet) Add(ip string, ttl time.Duration) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Unmap().Is4() {
+		return fmt.Errorf("nftset: not an IPv4 address: %q", ip)
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq += 5
+	msg := encodeSetElemBatch(s.seq, s.Table, s.Name, addr.Unmap(), ttl)
+	return nftSend(msg, s.seq+3)
+}
+
+// encodeSetElemBatch builds a transaction that adds, deletes and adds the
+// element again (sequences seq+1..seq+3 between batch begin and end). The
+// kernel ignores the timeout when adding an existing element; the first add
+// makes the delete succeed for a new one, and the last add sets the timeout.
+func encodeSetElemBatch(seq uint32, table, set string, addr netip.Addr, ttl time.Duration) []byte {
+	a4 := addr.As4()
+	timeout := make([]byte, 8)
+	binary.BigEndian.PutUint64(timeout, uint64(ttl.Milliseconds()))
+
+	elem := nlAttr(nftaListElem|nlaFNested,
+		nlAttr(nftaSetElemKey|nlaFNested, nlAttr(nftaDataValue, a4[:])),
+		nlAttr(nftaSetElemTimeout, timeout),
+	)
+	body := concat(
+		nfGenMsg(nfprotoINet, 0),
+		nlAttr(nftaSetElemListTable, cString(table)),
+		nlAttr(nftaSetElemListSet, cString(set)),
+		nlAttr(nftaSetElemListElements|nlaFNested, elem),
+	)
+	return concat(
+		nlMsg(nfnlMsgBatchBegin, nlmFRequest, seq, nfGenMsg(0, nfnlSubsysNFTables)),
+		nlMsg(nfnlSubsysNFTables<<8|nftMsgNewSetElem, nlmFRequest|nlmFCreate, seq+1, body),
+		nlMsg(nfnlSubsysNFTables<<8|nftMsgDelSetElem, nlmFRequest, seq+2, body),
+		nlMsg(nfnlSubsysNFTables<<8|nftMsgNewSetElem, nlmFRequest|nlmFCreate|nlmFAck, seq+3, body),
+		nlMsg(nfnlMsgBatchEnd, nlmFRequest, seq+4, nfGenMsg(0, nfnlSubsysNFTables)),
+	)
+}
+
+// nlMsg wraps payload in a netlink header (host byte order)
+func nlMsg(typ, flags uint16, seq uint32, payload []byte) []byte {
+	b := make([]byte, 16, 16+len(payload))
+	binary.NativeEndian.PutUint32(b[0:], uint32(16+len(payload)))
+	binary.NativeEndian.PutUint16(b[4:], typ)
+	binary.NativeEndian.PutUint16(b[6:], flags)
+	binary.NativeEndian.PutUint32(b[8:], seq)
+	return append(b, payload...)
+}
+
+// nlAttr encodes a netlink attribute, padded to 4 bytes
+func nlAttr(typ uint16, values ...[]byte) []byte {
+	value := concat(values...)
+	b := make([]byte, 4, 4+len(value)+3)
+	binary.NativeEndian.PutUint16(b[0:], uint16(4+len(value)))
+	binary.NativeEndian.PutUint16(b[2:], typ)
+	b = append(b, value...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// nfGenMsg is the nfnetlink header; res_id is big-endian
+func nfGenMsg(family uint8, resID uint16) []byte {
+	b := []byte{family, 0, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], resID)
+	return b
+}
+
+func cString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+const netlinkNetfilter = 12 // NETLINK_NETFILTER
+
+// sendNFTBatch sends a batch on a netfilter netlink socket and waits for
+// the acknowledgement of message ackSeq. An error reported for any message
+// of the batch aborts the whole transaction.
+func sendNFTBatch(msg []byte, ackSeq uint32) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, netlinkNetfilter)
+	if err != nil {
+		return fmt.Errorf("nftset: netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("nftset: netlink bind: %w", err)
+	}
+	tv := syscall.Timeval{Sec: 2}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("nftset: netlink timeout: %w", err)
+	}
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("nftset: netlink send: %w", err)
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("nftset: netlink receive: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("nftset: netlink parse: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+				err := syscall.Errno(-errno)
+				if err == syscall.EPERM {
+					return fmt.Errorf("nftset: %w: %v", ErrPermission, err)
+				}
+				return fmt.Errorf("nftset: add element: %w", err)
+			}
+			if m.Header.Seq == ackSeq {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// parseAttrs splits netlink attributes into type -> value (nested flag cleared)
+func parseAttrs(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+	attrs := map[uint16][]byte{}
+	for len(b) >= 4 {
+		l := int(binary.NativeEndian.Uint16(b[0:]))
+		typ := binary.NativeEndian.Uint16(b[2:]) &^ nlaFNested
+		if l < 4 || l > len(b) {
+			t.Fatalf("bad attribute length %d", l)
+		}
+		attrs[typ] = b[4:l]
+		b = b[(l+3)&^3:]
+	}
+	return attrs
+}
+
+func TestEncodeSetElemBatch(t *testing.T) {
+	msg := encodeSetElemBatch(40, "network_router", "tether4", netip.MustParseAddr("142.250.72.14"), 90*time.Second)
+
+	msgs, err := syscall.ParseNetlinkMessage(msg)
+	if err != nil {
+		t.Fatalf("ParseNetlinkMessage failed: %v", err)
+	}
+	if len(msgs) != 5 {
+		t.Fatalf("Expected begin, add, delete, add and end messages, got %d", len(msgs))
+	}
+	if msgs[0].Header.Type != nfnlMsgBatchBegin || msgs[4].Header.Type != nfnlMsgBatchEnd {
+		t.Errorf("Unexpected batch framing: %d, %d", msgs[0].Header.Type, msgs[4].Header.Type)
+	}
+	for i, op := range []uint16{nftMsgNewSetElem, nftMsgDelSetElem, nftMsgNewSetElem} {
+		if h := msgs[i+1].Header; h.Type != nfnlSubsysNFTables<<8|op || h.Seq != uint32(41+i) {
+			t.Errorf("Unexpected element header %d: %+v", i, h)
+		}
+	}
+
+	add := msgs[3]
+	if add.Header.Flags&nlmFAck == 0 {
+		t.Errorf("The last element message must request an ack")
+	}
+	if add.Data[0] != nfprotoINet {
+		t.Errorf("Expected inet family, got %d", add.Data[0])
+	}
+
+	attrs := parseAttrs(t, add.Data[4:])
+	if got := string(attrs[nftaSetElemListTable]); got != "network_router\x00" {
+		t.Errorf("Unexpected table %q", got)
+	}
+	if got := string(attrs[nftaSetElemListSet]); got != "tether4\x00" {
+		t.Errorf("Unexpected set %q", got)
+	}
+	elem := parseAttrs(t, parseAttrs(t, attrs[nftaSetElemListElements])[nftaListElem])
+	key := parseAttrs(t, elem[nftaSetElemKey])[nftaDataValue]
+	if netip.AddrFrom4([4]byte(key)).String() != "142.250.72.14" {
+		t.Errorf("Unexpected key %v", key)
+	}
+	if ms := binary.BigEndian.Uint64(elem[nftaSetElemTimeout]); ms != 90000 {
+		t.Errorf("Expected 90000ms timeout, got %d", ms)
+	}
+}
+
+// fakeNFTKernel applies element batches like nf_tables: adding an element
+// that exists succeeds without touching its timeout
+type fakeNFTKernel struct {
+	timeouts map[netip.Addr]uint64 // ms
+}
+
+func (k *fakeNFTKernel) send(t *testing.T, msg []byte) {
+	t.Helper()
+	msgs, err := syscall.ParseNetlinkMessage(msg)
+	if err != nil {
+		t.Fatalf("ParseNetlinkMessage failed: %v", err)
+	}
+	next := make(map[netip.Addr]uint64, len(k.timeouts))
+	for addr, ms := range k.timeouts {
+		next[addr] = ms
+	}
+	for _, m := range msgs[1 : len(msgs)-1] {
+		attrs := parseAttrs(t, m.Data[4:])
+		elem := parseAttrs(t, parseAttrs(t, attrs[nftaSetElemListElements])[nftaListElem])
+		addr := netip.AddrFrom4([4]byte(parseAttrs(t, elem[nftaSetElemKey])[nftaDataValue]))
+		_, exists := next[addr]
+		switch m.Header.Type &^ (nfnlSubsysNFTables << 8) {
+		case nftMsgNewSetElem:
+			if !exists {
+				next[addr] = binary.BigEndian.Uint64(elem[nftaSetElemTimeout])
+			}
+		case nftMsgDelSetElem:
+			if !exists {
+				t.Fatalf("Deleting missing element %s aborts the batch", addr)
+			}
+			delete(next, addr)
+		}
+	}
+	k.timeouts = next
+}
+
+func TestNFTSetAddRefreshesTimeout(t *testing.T) {
+	kernel := &fakeNFTKernel{timeouts: map[netip.Addr]uint64{}}
+	prev := nftSend
+	nftSend = func(msg []byte, ackSeq uint32) error {
+		kernel.send(t, msg)
+		return nil
+	}
+	t.Cleanup(func() { nftSend = prev })
+
+	set := NewNFTSet("network_router", "tether4", 0x66)
+	addr := netip.MustParseAddr("142.250.72.14")
+	for _, tc := range []struct {
+		ttl  time.Duration
+		want uint64
+	}{
+		{time.Minute, 60000},
+		{10 * time.Minute, 600000}, // A new answer moves the expiry
+		{2 * time.Minute, 120000},
+	} {
+		if err := set.Add(addr.String(), tc.ttl); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if got := kernel.timeouts[addr]; got != tc.want {
+			t.Errorf("After adding with ttl %s expected timeout %dms, got %d", tc.ttl, tc.want, got)
+		}
+	}
+}
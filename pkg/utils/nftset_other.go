@@ -0,0 +1,9 @@
+//go:build !linux
+
+package utils
+
+import "fmt"
+
+func sendNFTBatch(msg []byte, ackSeq uint32) error {
+	return fmt.Errorf("nftables sets are only supported on Linux")
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// InterfaceKind classifies a network interface by what it is connected to
+type InterfaceKind string
+
+const (
+	KindWifi   InterfaceKind = "wifi"
+	KindTether InterfaceKind = "tether" // USB/RNDIS tethering to a phone
+	KindWired  InterfaceKind = "wired"
+)
+
+// InterfaceProvider discovers interfaces, their state, their gateway and
+// the DNS server learned via DHCP. MacProvider uses networksetup/ifconfig/
+// ipconfig, LinuxProvider reads sysfs, netlink and /proc/net/route and asks
+// systemd-resolved or NetworkManager for DNS servers.
+type InterfaceProvider interface {
+	Interfaces() ([]InterfaceInfo, error)
+	IsActive(deviceName string) bool
+	Gateway(deviceName string) (string, error)
+	DNSServer(deviceName string) (string, error)
+}
+
+var (
+	providerMu sync.RWMutex
+	provider   InterfaceProvider = defaultProvider()
+)
+
+func defaultProvider() InterfaceProvider {
+	if runtime.GOOS == "linux" {
+		return NewLinuxProvider()
+	}
+	return MacProvider{}
+}
+
+// SetProvider replaces the interface provider and returns the previous one
+func SetProvider(p InterfaceProvider) InterfaceProvider {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	prev := provider
+	provider = p
+	return prev
+}
+
+func currentProvider() InterfaceProvider {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	return provider
+}
+
+// classifyHardwarePort derives the kind from a macOS hardware port name
+func classifyHardwarePort(name string) InterfaceKind {
+	lower := strings.ToLower(name)
+	switch {
+	case lower == "wi-fi" || lower == "airport":
+		return KindWifi
+	case strings.Contains(lower, "iphone usb"), strings.Contains(lower, "ipad usb"), strings.Contains(lower, "rndis"):
+		return KindTether
+	}
+	return KindWired
+}
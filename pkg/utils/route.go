@@ -1,82 +1,154 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 )
 
+// Route errors returned (wrapped) by the route functions below.
+// Use errors.Is to tell them apart.
+var (
+	ErrExists     = errors.New("route already exists")
+	ErrNotFound   = errors.New("route not found")
+	ErrNoGateway  = errors.New("gateway unreachable")
+	ErrPermission = errors.New("permission denied")
+)
+
+// RouteError describes a failed route command
+type RouteError struct {
+	Op          string // "add", "delete", "change"
+	Destination string
+	Output      string // trimmed CLI output
+	Err         error  // one of the sentinel errors above, or the exec error
+}
+
+func (e *RouteError) Error() string {
+	if e.Output != "" {
+		return fmt.Sprintf("failed to %s route %s: %s (%v)", e.Op, e.Destination, e.Output, e.Err)
+	}
+	return fmt.Sprintf("failed to %s route %s: %v", e.Op, e.Destination, e.Err)
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// classifyRouteOutput maps `route` / `ip route` / sudo messages to a sentinel error
+func classifyRouteOutput(output string) error {
+	out := strings.ToLower(output)
+	switch {
+	case strings.Contains(out, "file exists"):
+		return ErrExists
+	case strings.Contains(out, "not in table"),
+		strings.Contains(out, "no such process"),
+		strings.Contains(out, "no such file or directory"): // ip rule del
+		return ErrNotFound
+	case strings.Contains(out, "network is unreachable"),
+		strings.Contains(out, "nexthop has invalid gateway"),
+		strings.Contains(out, "bad address"):
+		return ErrNoGateway
+	case strings.Contains(out, "must be root"),
+		strings.Contains(out, "operation not permitted"),
+		strings.Contains(out, "permission denied"),
+		strings.Contains(out, "password is required"):
+		return ErrPermission
+	}
+	return nil
+}
+
+// newRouteError builds a RouteError from a failed command
+func newRouteError(op, destination string, output []byte, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return &RouteError{Op: op, Destination: destination, Output: strings.TrimSpace(string(output)), Err: err}
+	}
+	out := strings.TrimSpace(string(output))
+	if kind := classifyRouteOutput(out); kind != nil {
+		err = kind
+	}
+	return &RouteError{Op: op, Destination: destination, Output: out, Err: err}
+}
+
+// runRouteCmd runs a route command, reporting ctx expiry as the error so
+// callers can tell a timeout from a command failure
+func runRouteCmd(ctx context.Context, args ...string) ([]byte, error) {
+	output, err := runCmd(ctx, args[0], args[1:]...)
+	if err != nil && ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	return output, err
+}
+
 // AddRoute adds a static route for a network to a specific interface
-func AddRoute(destination string, interfaceName string) error {
+func AddRoute(ctx context.Context, destination string, interfaceName string) error {
 	// route add <destination> -interface <interfaceName>
 	fmt.Printf("Adding route: %s via %s\n", destination, interfaceName)
-	cmd := exec.Command("sudo", "route", "-n", "add", destination, "-interface", interfaceName)
-	output, err := cmd.CombinedOutput()
+	output, err := runRouteCmd(ctx, "sudo", "route", "-n", "add", destination, "-interface", interfaceName)
 	if err != nil {
-		return fmt.Errorf("failed to add route %s: %s (%v)", destination, string(output), err)
+		return newRouteError("add", destination, output, err)
 	}
 	return nil
 }
 
 // AddRouteViaGateway adds a static route via a specific gateway IP
-func AddRouteViaGateway(destination string, gatewayIP string) error {
+func AddRouteViaGateway(ctx context.Context, destination string, gatewayIP string) error {
 	// route add <destination> <gatewayIP>
 	fmt.Printf("Adding route: %s via gateway %s\n", destination, gatewayIP)
-	cmd := exec.Command("sudo", "route", "-n", "add", destination, gatewayIP)
-	output, err := cmd.CombinedOutput()
+	output, err := runRouteCmd(ctx, "sudo", "route", "-n", "add", destination, gatewayIP)
 	if err != nil {
-		return fmt.Errorf("failed to add route %s via %s: %s (%v)", destination, gatewayIP, string(output), err)
+		return newRouteError("add", destination+" via "+gatewayIP, output, err)
 	}
 	return nil
 }
 
 // ChangeDefaultGateway changes the default route to a specific gateway IP
-func ChangeDefaultGateway(gatewayIP string) error {
+func ChangeDefaultGateway(ctx context.Context, gatewayIP string) error {
 	// route change default <gatewayIP>
 	fmt.Printf("Changing default gateway to IP: %s\n", gatewayIP)
-	cmd := exec.Command("sudo", "route", "change", "default", gatewayIP)
-	output, err := cmd.CombinedOutput()
+	output, err := runRouteCmd(ctx, "sudo", "route", "change", "default", gatewayIP)
 	if err != nil {
 		// Try adding if change failed (maybe no default route exists)
 		// Or maybe the current default is an interface route
-		cmdAdd := exec.Command("sudo", "route", "add", "default", gatewayIP)
-		outputAdd, errAdd := cmdAdd.CombinedOutput()
+		outputAdd, errAdd := runRouteCmd(ctx, "sudo", "route", "add", "default", gatewayIP)
 		if errAdd != nil {
-			return fmt.Errorf("failed to change default route to %s: %s / %s", gatewayIP, string(output), string(outputAdd))
+			return newRouteError("change", "default via "+gatewayIP, append(append(output, " / "...), outputAdd...), errAdd)
 		}
 	}
 	return nil
 }
 
 // DeleteRoute deletes a route
-func DeleteRoute(destination string) error {
+func DeleteRoute(ctx context.Context, destination string) error {
 	fmt.Printf("Deleting route: %s\n", destination)
-	cmd := exec.Command("sudo", "route", "-n", "delete", destination)
-	output, err := cmd.CombinedOutput()
+	output, err := runRouteCmd(ctx, "sudo", "route", "-n", "delete", destination)
 	if err != nil {
-		// Does not return error if route not found usually, but good to know
-		return fmt.Errorf("failed to delete route %s: %s (%v)", destination, string(output), err)
+		// Route not found is reported as ErrNotFound so callers can ignore it
+		return newRouteError("delete", destination, output, err)
 	}
 	return nil
 }
 
+// IsInterfaceActive reports whether the interface has an IPv4 address
 func IsInterfaceActive(deviceName string) bool {
-	// Check if interface has an IP address using ifconfig
-	cmd := exec.Command("ifconfig", deviceName)
-	output, err := cmd.Output()
-	if err != nil {
-		return false
+	return currentProvider().IsActive(deviceName)
+}
+
+// hasIPv4Address parses 'ifconfig <dev>' output for an "inet " line
+func hasIPv4Address(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "inet ") {
+			return true
+		}
 	}
-	// Very basic check for "inet "
-	return strings.Contains(string(output), "inet ")
+	return false
 }
 
 // ShowRoutingTable displays the current routing table using netstat
 func ShowRoutingTable() error {
 	fmt.Println("Current Routing Table (netstat -nr):")
-	cmd := exec.Command("netstat", "-nr")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	output, err := runCmd(context.Background(), "netstat", "-nr")
+	os.Stdout.Write(output)
+	return err
 }
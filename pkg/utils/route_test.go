@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewRouteErrorClassifiesOutput(t *testing.T) {
+	cases := []struct {
+		output string
+		want   error
+	}{
+		{"route: writing to routing socket: File exists\nadd net 1.2.3.4: gateway 172.20.10.1: File exists", ErrExists},
+		{"route: writing to routing socket: not in table\ndelete net 1.2.3.4: not in table", ErrNotFound},
+		{"RTNETLINK answers: No such process", ErrNotFound},
+		{"RTNETLINK answers: No such file or directory", ErrNotFound},
+		{"route: writing to routing socket: Network is unreachable", ErrNoGateway},
+		{"route: must be root to alter routing table", ErrPermission},
+		{"sudo: a password is required", ErrPermission},
+	}
+	for _, tc := range cases {
+		err := newRouteError("add", "1.2.3.4/32", []byte(tc.output), errors.New("exit status 1"))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("output %q: got %v, want %v", tc.output, err, tc.want)
+		}
+	}
+
+	err := newRouteError("add", "1.2.3.4/32", []byte("something else"), errors.New("exit status 1"))
+	for _, sentinel := range []error{ErrExists, ErrNotFound, ErrNoGateway, ErrPermission} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("unclassified output matched %v", sentinel)
+		}
+	}
+}
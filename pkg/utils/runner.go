@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// defaultCommandTimeout bounds commands run without a deadline
+const defaultCommandTimeout = 10 * time.Second
+
+// Runner executes external commands (networksetup, ifconfig, ipconfig,
+// route, netstat). Every OS call in this package goes through the current
+// Runner so tests can replay recorded outputs instead (see utilstest.FakeRunner).
+type Runner interface {
+	// Run executes name with args and returns its combined stdout/stderr
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands with os/exec
+type ExecRunner struct {
+	// Timeout applies when ctx has no deadline of its own
+	Timeout time.Duration
+}
+
+func (e ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok && e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil && ctx.Err() != nil {
+		// Report the timeout/cancellation rather than "signal: killed"
+		return output, ctx.Err()
+	}
+	return output, err
+}
+
+var (
+	runnerMu sync.RWMutex
+	runner   Runner = ExecRunner{Timeout: defaultCommandTimeout}
+)
+
+// SetRunner replaces the command runner and returns the previous one
+func SetRunner(r Runner) Runner {
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	prev := runner
+	runner = r
+	return prev
+}
+
+// runCmd runs a command through the current Runner
+func runCmd(ctx context.Context, name string, args ...string) ([]byte, error) {
+	runnerMu.RLock()
+	r := runner
+	runnerMu.RUnlock()
+	return r.Run(ctx, name, args...)
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// InterfaceSelector matches network interfaces. Every field that is set
+// must match; an empty selector matches nothing.
+type InterfaceSelector struct {
+	Device string        `yaml:"device"` // Exact device name, e.g. "en8"
+	Name   string        `yaml:"name"`   // Case-insensitive substring of the hardware port name
+	Regex  string        `yaml:"regex"`  // Regular expression on the hardware port name or device name
+	MAC    string        `yaml:"mac"`    // Full MAC address or OUI prefix, e.g. "5e:5b:35"
+	Driver string        `yaml:"driver"` // Kernel driver, e.g. "ipheth" (Linux)
+	Kind   InterfaceKind `yaml:"kind"`   // wifi, tether or wired
+}
+
+// String describes the selector for logs and status, e.g. "device=en8"
+func (s InterfaceSelector) String() string {
+	var parts []string
+	add := func(key, value string) {
+		if value != "" {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	add("device", s.Device)
+	add("name", s.Name)
+	add("regex", s.Regex)
+	add("mac", s.MAC)
+	add("driver", s.Driver)
+	add("kind", string(s.Kind))
+	return strings.Join(parts, ",")
+}
+
+// matcher returns a function reporting whether an interface matches s
+func (s InterfaceSelector) matcher() (func(InterfaceInfo) bool, error) {
+	if s == (InterfaceSelector{}) {
+		return nil, fmt.Errorf("empty interface selector")
+	}
+	var re *regexp.Regexp
+	if s.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(s.Regex); err != nil {
+			return nil, fmt.Errorf("invalid interface regex %q: %w", s.Regex, err)
+		}
+	}
+	mac := normalizeMAC(s.MAC)
+	return func(iface InterfaceInfo) bool {
+		switch {
+		case s.Device != "" && iface.DeviceName != s.Device,
+			s.Name != "" && !strings.Contains(strings.ToLower(iface.Name), strings.ToLower(s.Name)),
+			re != nil && !re.MatchString(iface.Name) && !re.MatchString(iface.DeviceName),
+			mac != "" && !strings.HasPrefix(normalizeMAC(iface.MacAddress), mac),
+			s.Driver != "" && iface.Driver != s.Driver,
+			s.Kind != "" && iface.Kind != s.Kind:
+			return false
+		}
+		return true
+	}, nil
+}
+
+// normalizeMAC lowercases a MAC and drops separators so "5E-5B-35" matches "5e:5b:35:..."
+func normalizeMAC(mac string) string {
+	return strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(mac))
+}
+
+// SelectInterface tries selectors in priority order and returns the first
+// interface matched along with the selector that matched it. When a selector
+// matches several interfaces, an active one (per active, if non-nil) wins.
+func SelectInterface(interfaces []InterfaceInfo, selectors []InterfaceSelector, active func(string) bool) (*InterfaceInfo, *InterfaceSelector, error) {
+	for i := range selectors {
+		match, err := selectors[i].matcher()
+		if err != nil {
+			return nil, nil, err
+		}
+		var candidates []InterfaceInfo
+		for _, iface := range interfaces {
+			if match(iface) {
+				candidates = append(candidates, iface)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		chosen := candidates[0]
+		if len(candidates) > 1 && active != nil {
+			for _, c := range candidates {
+				if active(c.DeviceName) {
+					chosen = c
+					break
+				}
+			}
+		}
+		return &chosen, &selectors[i], nil
+	}
+	return nil, nil, nil
+}
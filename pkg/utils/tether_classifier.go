@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// TetherType is the kind of phone connection an interface looks like
+type TetherType string
+
+const (
+	TetherIPhoneUSB    TetherType = "iphone-usb"
+	TetherAndroidUSB   TetherType = "android-usb" // RNDIS or NCM
+	TetherBluetoothPAN TetherType = "bluetooth-pan"
+	TetherWifiHotspot  TetherType = "wifi-hotspot" // Phone hotspot joined by a second Wi-Fi adapter
+)
+
+// TetherCandidate is an interface classified as a possible phone uplink
+type TetherCandidate struct {
+	Interface  InterfaceInfo
+	Type       TetherType
+	Confidence float64  // 0..1
+	Reasons    []string // Signals that contributed, for logs
+	Active     bool     // Device is up, see ClassifyTethers
+}
+
+// Well-known gateways handed out by phones
+const (
+	iosHotspotGateway     = "172.20.10.1"    // iOS Personal Hotspot (USB, Wi-Fi and Bluetooth)
+	androidUSBGateway     = "192.168.42.129" // Android USB tethering
+	androidHotspotGateway = "192.168.43.1"   // Android Wi-Fi hotspot
+)
+
+// strongTetherSignal is the score a driver, port name or gateway must reach
+// before weaker hints such as the MAC are counted
+const strongTetherSignal = 0.7
+
+// tetherScore accumulates independent signals per type (noisy-or), so
+// several weak signals add up without ever exceeding 1
+type tetherScore struct {
+	scores  map[TetherType]float64
+	reasons []string
+}
+
+func (s *tetherScore) add(t TetherType, weight float64, reason string) {
+	s.scores[t] = 1 - (1-s.scores[t])*(1-weight)
+	s.reasons = append(s.reasons, reason)
+}
+
+func (s *tetherScore) best() (TetherType, float64) {
+	var bestType TetherType
+	var bestScore float64
+	for _, t := range []TetherType{TetherIPhoneUSB, TetherAndroidUSB, TetherBluetoothPAN, TetherWifiHotspot} {
+		if s.scores[t] > bestScore {
+			bestType, bestScore = t, s.scores[t]
+		}
+	}
+	return bestType, bestScore
+}
+
+// ClassifyTether scores how likely iface is a phone uplink using its port
+// name, driver, MAC and DHCP gateway (empty if unknown). primaryWifi is the
+// device used for the regular Wi-Fi network; any other Wi-Fi adapter may be
+// joined to a phone hotspot.
+func ClassifyTether(iface InterfaceInfo, gateway, primaryWifi string) (TetherCandidate, bool) {
+	s := &tetherScore{scores: make(map[TetherType]float64)}
+	name := strings.ToLower(iface.Name)
+
+	// Port names (macOS) and drivers (Linux)
+	switch {
+	case strings.Contains(name, "iphone usb"), strings.Contains(name, "ipad usb"):
+		s.add(TetherIPhoneUSB, 0.9, fmt.Sprintf("port name %q", iface.Name))
+	case strings.Contains(name, "rndis"):
+		s.add(TetherAndroidUSB, 0.8, fmt.Sprintf("port name %q", iface.Name))
+	case strings.Contains(name, "bluetooth pan"):
+		s.add(TetherBluetoothPAN, 0.9, fmt.Sprintf("port name %q", iface.Name))
+	}
+	switch iface.Driver {
+	case "ipheth":
+		s.add(TetherIPhoneUSB, 0.95, "driver ipheth")
+	case "rndis_host":
+		s.add(TetherAndroidUSB, 0.85, "driver rndis_host")
+	case "cdc_ncm":
+		s.add(TetherAndroidUSB, 0.7, "driver cdc_ncm")
+	case "cdc_ether":
+		// Also used by plain USB Ethernet adapters
+		s.add(TetherAndroidUSB, 0.4, "driver cdc_ether")
+	case "bnep":
+		s.add(TetherBluetoothPAN, 0.9, "driver bnep")
+	}
+	if strings.HasPrefix(iface.DeviceName, "bnep") {
+		s.add(TetherBluetoothPAN, 0.8, "device "+iface.DeviceName)
+	}
+
+	// DHCP gateway patterns
+	secondWifi := iface.Kind == KindWifi && iface.DeviceName != primaryWifi
+	tetherGateway := gateway == iosHotspotGateway || gateway == androidUSBGateway
+	switch gateway {
+	case iosHotspotGateway:
+		reason := "gateway " + gateway + " (iOS hotspot)"
+		switch {
+		case secondWifi:
+			s.add(TetherWifiHotspot, 0.8, reason)
+		case s.scores[TetherBluetoothPAN] > 0:
+			s.add(TetherBluetoothPAN, 0.5, reason)
+		default:
+			s.add(TetherIPhoneUSB, 0.6, reason)
+		}
+	case androidUSBGateway:
+		s.add(TetherAndroidUSB, 0.6, "gateway "+gateway+" (Android USB tethering)")
+	case androidHotspotGateway:
+		if secondWifi {
+			s.add(TetherWifiHotspot, 0.7, "gateway "+gateway+" (Android hotspot)")
+		}
+	}
+	if secondWifi && gateway != "" {
+		s.add(TetherWifiHotspot, 0.3, "second Wi-Fi adapter with a gateway")
+	}
+
+	// Phones present a random, locally administered MAC over USB. Many USB
+	// Ethernet adapters do too, so it only backs up a strong signal.
+	if t, score := s.best(); (score >= strongTetherSignal || tetherGateway) && (t == TetherIPhoneUSB || t == TetherAndroidUSB) && isLocallyAdministered(iface.MacAddress) {
+		s.add(t, 0.2, "locally administered MAC")
+	}
+
+	t, score := s.best()
+	if score == 0 {
+		return TetherCandidate{}, false
+	}
+	return TetherCandidate{Interface: iface, Type: t, Confidence: score, Reasons: s.reasons}, true
+}
+
+// ClassifyTethers returns the interfaces that look like a phone uplink,
+// active ones first, then most confident first: macOS lists ports such as
+// Bluetooth PAN whether or not they are connected. gateway looks up the
+// DHCP gateway of a device (return "" if unknown); isActive reports whether
+// a device is up (nil treats every device as up).
+func ClassifyTethers(interfaces []InterfaceInfo, primaryWifi string, gateway func(string) string, isActive func(string) bool) []TetherCandidate {
+	var out []TetherCandidate
+	for _, iface := range interfaces {
+		if iface.DeviceName == primaryWifi {
+			continue
+		}
+		gw := ""
+		if gateway != nil {
+			gw = gateway(iface.DeviceName)
+		}
+		if c, ok := ClassifyTether(iface, gw, primaryWifi); ok {
+			c.Active = isActive == nil || isActive(iface.DeviceName)
+			out = append(out, c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Active != out[j].Active {
+			return out[i].Active
+		}
+		return out[i].Confidence > out[j].Confidence
+	})
+	return out
+}
+
+// isLocallyAdministered reports whether the MAC has the locally administered bit set
+func isLocallyAdministered(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) == 0 {
+		return false
+	}
+	return hw[0]&0x02 != 0
+}
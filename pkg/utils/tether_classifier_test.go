@@ -0,0 +1,87 @@
+package utils
+
+import "testing"
+
+func TestClassifyTethers(t *testing.T) {
+	interfaces := []InterfaceInfo{
+		{Name: "Wi-Fi", DeviceName: "en0", MacAddress: "3c:22:fb:a1:5e:09", Kind: KindWifi},
+		{Name: "iPhone USB", DeviceName: "en8", MacAddress: "5e:5b:35:8c:2d:64", Kind: KindTether},
+		{Name: "Ethernet", DeviceName: "usb0", MacAddress: "b6:2e:91:5a:c3:07", Kind: KindTether, Driver: "rndis_host"},
+		{Name: "Bluetooth PAN", DeviceName: "en6", MacAddress: "f0:18:98:10:22:33", Kind: KindWired},
+		{Name: "Wi-Fi", DeviceName: "wlx00c0ca9b1e2f", MacAddress: "00:c0:ca:9b:1e:2f", Kind: KindWifi, Driver: "rt2800usb"},
+		{Name: "Ethernet", DeviceName: "enp3s0", MacAddress: "54:e1:ad:7c:0b:19", Kind: KindWired, Driver: "r8169"},
+	}
+	gateways := map[string]string{
+		"en0":             "192.168.1.1",
+		"en8":             "172.20.10.1",
+		"usb0":            "192.168.42.129",
+		"wlx00c0ca9b1e2f": "172.20.10.1",
+		"enp3s0":          "10.0.0.1",
+	}
+
+	candidates := ClassifyTethers(interfaces, "en0", func(dev string) string { return gateways[dev] }, nil)
+
+	got := map[string]TetherCandidate{}
+	for _, c := range candidates {
+		got[c.Interface.DeviceName] = c
+	}
+	want := map[string]TetherType{
+		"en8":             TetherIPhoneUSB,
+		"usb0":            TetherAndroidUSB,
+		"en6":             TetherBluetoothPAN,
+		"wlx00c0ca9b1e2f": TetherWifiHotspot,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d candidates, got %+v", len(want), candidates)
+	}
+	for dev, typ := range want {
+		c, ok := got[dev]
+		if !ok || c.Type != typ {
+			t.Errorf("%s: expected %s, got %+v", dev, typ, c)
+			continue
+		}
+		if c.Confidence < 0.5 || c.Confidence > 1 {
+			t.Errorf("%s: unexpected confidence %.2f", dev, c.Confidence)
+		}
+	}
+
+	// Port name, iOS gateway and a random MAC make the iPhone the best pick
+	if candidates[0].Interface.DeviceName != "en8" {
+		t.Errorf("Expected en8 first, got %s", candidates[0].Interface.DeviceName)
+	}
+	for i := 1; i < len(candidates); i++ {
+		if candidates[i].Confidence > candidates[i-1].Confidence {
+			t.Errorf("Candidates not sorted by confidence: %+v", candidates)
+		}
+	}
+
+	// A Bluetooth PAN port is always listed on macOS; a plugged-in iPhone
+	// scoring the same wins while the PAN is down
+	pan := []InterfaceInfo{
+		{Name: "Bluetooth PAN", DeviceName: "en6", MacAddress: "f0:18:98:10:22:33", Kind: KindWired},
+		{Name: "iPhone USB", DeviceName: "en7", MacAddress: "5c:5b:35:8c:2d:64", Kind: KindTether},
+	}
+	candidates = ClassifyTethers(pan, "en0", nil, func(dev string) bool { return dev == "en7" })
+	if len(candidates) != 2 || candidates[0].Interface.DeviceName != "en7" || !candidates[0].Active || candidates[1].Active {
+		t.Errorf("Expected the active iPhone USB before the Bluetooth PAN, got %+v", candidates)
+	}
+	if candidates[0].Confidence != candidates[1].Confidence {
+		t.Errorf("Expected equal confidence, got %.2f and %.2f", candidates[0].Confidence, candidates[1].Confidence)
+	}
+
+	// A plain USB Ethernet adapter on cdc_ether is too weak a guess to auto-pick
+	dongle, ok := ClassifyTether(InterfaceInfo{DeviceName: "eth1", MacAddress: "00:e0:4c:68:01:9f", Driver: "cdc_ether"}, "192.168.1.1", "en0")
+	if !ok || dongle.Confidence >= 0.5 {
+		t.Errorf("Expected a weak cdc_ether guess, got %+v", dongle)
+	}
+	// Even with a random MAC, which alone proves nothing
+	dongle, ok = ClassifyTether(InterfaceInfo{DeviceName: "eth1", MacAddress: "02:e0:4c:68:01:9f", Driver: "cdc_ether"}, "192.168.1.1", "en0")
+	if !ok || dongle.Confidence >= 0.5 {
+		t.Errorf("Expected a random MAC not to lift a cdc_ether guess, got %+v", dongle)
+	}
+	// The Android USB gateway makes it a phone after all
+	phone, ok := ClassifyTether(InterfaceInfo{DeviceName: "eth1", MacAddress: "02:e0:4c:68:01:9f", Driver: "cdc_ether"}, "192.168.42.129", "en0")
+	if !ok || phone.Type != TetherAndroidUSB || phone.Confidence < 0.8 {
+		t.Errorf("Expected a cdc_ether phone on the Android USB gateway, got %+v", phone)
+	}
+}